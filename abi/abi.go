@@ -0,0 +1,265 @@
+// Package abi describes the methods and events of contracts and encodes the
+// calls to them.
+//
+// An ABI is JSON:
+//
+//	{
+//	  "methods": [{"name": "add", "inputs": [{"name": "a", "type": "uint256"}, {"name": "b", "type": "uint256"}], "outputs": [{"type": "uint256"}]}],
+//	  "events": [{"name": "Added", "inputs": [{"name": "sum", "type": "uint256"}]}]
+//	}
+//
+// The call data of a method, the Data of the transaction invoking the
+// contract, is the selector of the method followed by its encoded
+// arguments. A contract dispatches on the selector, read as an int:
+//
+//	push 0
+//	push 4
+//	calldata          ; the 4 bytes of the selector
+//	toint
+//	pushn 0xec2d2fd3  ; selector of add(uint256,uint256)
+//	eq
+//	push @add
+//	jumpi
+//
+// Values are encoded as words of 32 bytes. Ints are big-endian, bools are
+// 0 or 1, addresses and bytes32 are aligned to the right of their word. A
+// bytes or a string is a word holding the offset of its content from the
+// start of the values, the content being its length in a word then its
+// bytes padded with zeros to a multiple of words, after the words of the
+// other values. The return value of a method and the data of an event are
+// encoded the same way, so a method with one int output returns it as it
+// is. The topic of an event log is the hash of the signature of the event.
+package abi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/matrix-go/block/core"
+)
+
+// SelectorSize is the size of the selector starting the call data
+const SelectorSize = 4
+
+// Type is the type of a value
+type Type string
+
+const (
+	Uint256 Type = "uint256" // a *big.Int, uint64 or int are accepted
+	Bool    Type = "bool"
+	Address Type = "address" // a types.Address
+	Bytes32 Type = "bytes32" // a [32]byte or a types.Hash
+	Bytes   Type = "bytes"
+	String  Type = "string"
+)
+
+func (t Type) valid() bool {
+	switch t {
+	case Uint256, Bool, Address, Bytes32, Bytes, String:
+		return true
+	default:
+		return false
+	}
+}
+
+// dynamic reports whether values of t have their content after the words of
+// the other values
+func (t Type) dynamic() bool {
+	return t == Bytes || t == String
+}
+
+// Param is a value taken or returned by a method
+type Param struct {
+	Name string `json:"name,omitempty"`
+	Type Type   `json:"type"`
+}
+
+// Params are the values of a method or an event, in order
+type Params []Param
+
+func (ps Params) types() string {
+	types := make([]string, len(ps))
+	for i, p := range ps {
+		types[i] = string(p.Type)
+	}
+	return strings.Join(types, ",")
+}
+
+// Method is a function of a contract
+type Method struct {
+	Name    string `json:"name"`
+	Inputs  Params `json:"inputs"`
+	Outputs Params `json:"outputs"`
+}
+
+// Signature is the name of the method and the types of its inputs, such as
+// add(uint256,uint256)
+func (m *Method) Signature() string {
+	return fmt.Sprintf("%s(%s)", m.Name, m.Inputs.types())
+}
+
+// Selector is the start of the hash of the signature
+func (m *Method) Selector() []byte {
+	h := sha256.Sum256([]byte(m.Signature()))
+	return h[:SelectorSize]
+}
+
+// Pack returns the call data calling the method with args
+func (m *Method) Pack(args ...any) ([]byte, error) {
+	data, err := m.Inputs.Encode(args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Name, err)
+	}
+	return append(m.Selector(), data...), nil
+}
+
+// Unpack decodes the return value of the method
+func (m *Method) Unpack(ret []byte) ([]any, error) {
+	values, err := m.Outputs.Decode(ret)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Name, err)
+	}
+	return values, nil
+}
+
+// Event is a log emitted by a contract
+type Event struct {
+	Name   string `json:"name"`
+	Inputs Params `json:"inputs"`
+}
+
+// Signature is the name of the event and the types of its values
+func (e *Event) Signature() string {
+	return fmt.Sprintf("%s(%s)", e.Name, e.Inputs.types())
+}
+
+// Topic is the hash of the signature, the topic of the logs of the event
+func (e *Event) Topic() []byte {
+	h := sha256.Sum256([]byte(e.Signature()))
+	return h[:]
+}
+
+// ABI are the methods and the events of a contract
+type ABI struct {
+	Methods []*Method `json:"methods"`
+	Events  []*Event  `json:"events"`
+}
+
+// Parse reads the JSON of an ABI
+func Parse(data []byte) (*ABI, error) {
+	var a ABI
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidABI, err)
+	}
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (a *ABI) validate() error {
+	selectors := make(map[string]string)
+	for _, m := range a.Methods {
+		if m.Name == "" {
+			return fmt.Errorf("%w: method without name", ErrInvalidABI)
+		}
+		if err := validateParams(m.Name, m.Inputs, m.Outputs); err != nil {
+			return err
+		}
+		if other, ok := selectors[string(m.Selector())]; ok {
+			return fmt.Errorf("%w: %s and %s have the same selector", ErrInvalidABI, other, m.Signature())
+		}
+		selectors[string(m.Selector())] = m.Signature()
+	}
+	events := make(map[string]struct{})
+	for _, e := range a.Events {
+		if e.Name == "" {
+			return fmt.Errorf("%w: event without name", ErrInvalidABI)
+		}
+		if err := validateParams(e.Name, e.Inputs); err != nil {
+			return err
+		}
+		if _, ok := events[e.Name]; ok {
+			return fmt.Errorf("%w: duplicate event %s", ErrInvalidABI, e.Name)
+		}
+		events[e.Name] = struct{}{}
+	}
+	return nil
+}
+
+func validateParams(name string, params ...Params) error {
+	for _, ps := range params {
+		for _, p := range ps {
+			if !p.Type.valid() {
+				return fmt.Errorf("%s: %w: %q", name, ErrUnknownType, p.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// Method returns the first method called name
+func (a *ABI) Method(name string) (*Method, error) {
+	for _, m := range a.Methods {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownMethod, name)
+}
+
+// Event returns the event called name
+func (a *ABI) Event(name string) (*Event, error) {
+	for _, e := range a.Events {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, name)
+}
+
+// Pack returns the call data calling the method name with args
+func (a *ABI) Pack(name string, args ...any) ([]byte, error) {
+	m, err := a.Method(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.Pack(args...)
+}
+
+// Unpack decodes the return value of the method name
+func (a *ABI) Unpack(name string, ret []byte) ([]any, error) {
+	m, err := a.Method(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.Unpack(ret)
+}
+
+// UnpackLog returns the event of log and its decoded values
+func (a *ABI) UnpackLog(log *core.Log) (*Event, []any, error) {
+	for _, e := range a.Events {
+		if string(e.Topic()) != string(log.Topic) {
+			continue
+		}
+		values, err := e.Inputs.Decode(log.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		return e, values, nil
+	}
+	return nil, nil, fmt.Errorf("%w: topic %x", ErrUnknownEvent, log.Topic)
+}
+
+var (
+	ErrInvalidABI    = errors.New("invalid abi")
+	ErrUnknownType   = errors.New("unknown type")
+	ErrUnknownMethod = errors.New("unknown method")
+	ErrUnknownEvent  = errors.New("unknown event")
+	ErrArgumentCount = errors.New("wrong number of arguments")
+	ErrArgumentType  = errors.New("wrong argument type")
+	ErrInvalidData   = errors.New("invalid encoded data")
+)
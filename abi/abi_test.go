@@ -0,0 +1,212 @@
+package abi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/matrix-go/block/asm"
+	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/crypto"
+	"github.com/matrix-go/block/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const adderABI = `{
+	"methods": [
+		{"name": "add", "inputs": [{"name": "a", "type": "uint256"}, {"name": "b", "type": "uint256"}], "outputs": [{"type": "uint256"}]},
+		{"name": "greet", "inputs": [{"name": "to", "type": "address"}, {"name": "msg", "type": "string"}], "outputs": [{"type": "bool"}]}
+	],
+	"events": [{"name": "Added", "inputs": [{"name": "sum", "type": "uint256"}]}]
+}`
+
+func TestParse(t *testing.T) {
+	a, err := Parse([]byte(adderABI))
+	require.NoError(t, err)
+	require.Len(t, a.Methods, 2)
+	add, err := a.Method("add")
+	require.NoError(t, err)
+	assert.Equal(t, "add(uint256,uint256)", add.Signature())
+	h := sha256.Sum256([]byte("add(uint256,uint256)"))
+	assert.Equal(t, h[:4], add.Selector())
+	added, err := a.Event("Added")
+	require.NoError(t, err)
+	assert.Equal(t, "Added(uint256)", added.Signature())
+	_, err = a.Method("sub")
+	assert.ErrorIs(t, err, ErrUnknownMethod)
+
+	tests := []struct {
+		name string
+		abi  string
+		err  error
+	}{
+		{name: "json", abi: `{"methods": [`, err: ErrInvalidABI},
+		{name: "type", abi: `{"methods": [{"name": "f", "inputs": [{"type": "int"}]}]}`, err: ErrUnknownType},
+		{name: "output type", abi: `{"methods": [{"name": "f", "outputs": [{"type": "uint"}]}]}`, err: ErrUnknownType},
+		{name: "unnamed", abi: `{"methods": [{"inputs": []}]}`, err: ErrInvalidABI},
+		{name: "overload", abi: `{"methods": [{"name": "f"}, {"name": "f"}]}`, err: ErrInvalidABI},
+		{name: "event", abi: `{"events": [{"name": "E"}, {"name": "E"}]}`, err: ErrInvalidABI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.abi))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestMethod_Pack(t *testing.T) {
+	a, err := Parse([]byte(adderABI))
+	require.NoError(t, err)
+	to := types.Address{0x01}
+	data, err := a.Pack("greet", to, "hi")
+	require.NoError(t, err)
+
+	greet, _ := a.Method("greet")
+	assert.Equal(t, greet.Selector(), data[:SelectorSize])
+	args := data[SelectorSize:]
+	require.Len(t, args, 4*wordSize)
+	assert.Equal(t, rightAligned(to.Bytes()), args[:wordSize])
+	assert.Equal(t, uintWord(2*wordSize), args[wordSize:2*wordSize])
+	assert.Equal(t, uintWord(2), args[2*wordSize:3*wordSize])
+	assert.Equal(t, append([]byte("hi"), make([]byte, wordSize-2)...), args[3*wordSize:])
+
+	_, err = a.Pack("greet", to)
+	assert.ErrorIs(t, err, ErrArgumentCount)
+	_, err = a.Pack("greet", "to", "hi")
+	assert.ErrorIs(t, err, ErrArgumentType)
+	_, err = a.Pack("add", -1, 2)
+	assert.ErrorIs(t, err, ErrArgumentType)
+}
+
+func TestParams_Decode(t *testing.T) {
+	params := Params{{Type: Uint256}, {Type: Bytes}, {Type: Bool}, {Type: Address}, {Type: Bytes32}, {Type: String}}
+	hash := types.RandomHash()
+	long := make([]byte, 40)
+	long[39] = 7
+	args := []any{uint64(1 << 40), long, true, types.Address{0xaa}, hash, ""}
+	data, err := params.Encode(args...)
+	require.NoError(t, err)
+	values, err := params.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, []any{new(big.Int).SetUint64(1 << 40), long, true, types.Address{0xaa}, [32]byte(hash), ""}, values)
+
+	// encoded values are checked
+	bad := func(i int, w []byte) []byte {
+		b := append([]byte{}, data...)
+		copy(b[i*wordSize:], w)
+		return b
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "short", data: data[:5*wordSize]},
+		{name: "offset", data: bad(1, uintWord(uint64(len(data))))},
+		{name: "length", data: bad(6, uintWord(100))},
+		{name: "bool", data: bad(2, uintWord(2))},
+		{name: "address", data: bad(3, []byte{1})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := params.Decode(tt.data)
+			assert.ErrorIs(t, err, ErrInvalidData)
+		})
+	}
+}
+
+func TestABI_UnpackLog(t *testing.T) {
+	a, err := Parse([]byte(adderABI))
+	require.NoError(t, err)
+	added, _ := a.Event("Added")
+	event, values, err := a.UnpackLog(&core.Log{Topic: added.Topic(), Data: uintWord(5)})
+	require.NoError(t, err)
+	assert.Equal(t, added, event)
+	assert.Equal(t, []any{big.NewInt(5)}, values)
+
+	_, _, err = a.UnpackLog(&core.Log{Topic: []byte("ev"), Data: uintWord(5)})
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+	_, _, err = a.UnpackLog(&core.Log{Topic: added.Topic()})
+	assert.ErrorIs(t, err, ErrInvalidData)
+}
+
+func TestContract(t *testing.T) {
+	a, err := Parse([]byte(adderABI))
+	require.NoError(t, err)
+	add, _ := a.Method("add")
+	added, _ := a.Event("Added")
+	// add returns the sum of its arguments and logs it, other methods fail
+	code := asm.MustAssemble(fmt.Sprintf(`
+		push 0
+		push 4
+		calldata
+		toint
+		pushn 0x%x
+		eq
+		push @add
+		jumpi
+		.byte 0xff
+	add:
+		jumpdest
+		push 4
+		push 32
+		calldata
+		toint
+		push 36
+		push 32
+		calldata
+		toint
+		add
+		dup
+		tobytes
+		pushn 0x%s
+		tobytes
+		log
+		return
+	`, add.Selector(), hex.EncodeToString(added.Topic())))
+
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: core.NewBlock(&core.Header{Version: 1, GasLimit: 100_000})})
+	require.NoError(t, err)
+	privateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	deploy := core.NewTransaction(nil)
+	deploy.InnerTx = &core.DeployTx{Code: code}
+	deploy.GasLimit = 10_000
+	require.NoError(t, deploy.Sign(privateKey))
+	call, err := a.Pack("add", 2, big.NewInt(3))
+	require.NoError(t, err)
+	invoke := core.NewTransaction(call)
+	invoke.InnerTx = &core.InvokeTx{Contract: core.ContractAddress(privateKey.PublicKey().Address(), 0)}
+	invoke.GasLimit = 10_000
+	invoke.Nonce = 1
+	greet, err := a.Pack("greet", types.Address{}, "hi")
+	require.NoError(t, err)
+	unknown := core.NewTransaction(greet)
+	unknown.InnerTx = invoke.InnerTx
+	unknown.GasLimit = 10_000
+	unknown.Nonce = 2
+	require.NoError(t, invoke.Sign(privateKey))
+	require.NoError(t, unknown.Sign(privateKey))
+	block, err := chain.ProposeBlock(privateKey, []*core.Transaction{deploy, invoke, unknown})
+	require.NoError(t, err)
+	require.NoError(t, chain.AddBlock(block))
+
+	receipt, err := chain.GetReceipt(invoke.GetHash(core.NewTransactionHasher()))
+	require.NoError(t, err)
+	require.False(t, receipt.Failed(), receipt.Err)
+	ret, err := a.Unpack("add", receipt.Return)
+	require.NoError(t, err)
+	assert.Equal(t, []any{big.NewInt(5)}, ret)
+	require.Len(t, receipt.Logs, 1)
+	event, values, err := a.UnpackLog(receipt.Logs[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Added", event.Name)
+	assert.Equal(t, []any{big.NewInt(5)}, values)
+
+	receipt, err = chain.GetReceipt(unknown.GetHash(core.NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.Contains(t, receipt.Err, core.ErrUnknownOpcode.Error())
+}
@@ -0,0 +1,200 @@
+package abi
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/matrix-go/block/types"
+)
+
+// wordSize is the size of the words of the encoding, those of the VM
+const wordSize = 32
+
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 8*wordSize), big.NewInt(1))
+
+// Encode encodes args, one for every param
+func (ps Params) Encode(args ...any) ([]byte, error) {
+	if len(args) != len(ps) {
+		return nil, fmt.Errorf("%w: %d, expected %d", ErrArgumentCount, len(args), len(ps))
+	}
+	head := make([]byte, 0, len(ps)*wordSize)
+	var tail []byte
+	for i, p := range ps {
+		b, err := encodeValue(p.Type, args[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		if !p.Type.dynamic() {
+			head = append(head, b...)
+			continue
+		}
+		offset := len(ps)*wordSize + len(tail)
+		head = append(head, uintWord(uint64(offset))...)
+		tail = append(tail, b...)
+	}
+	return append(head, tail...), nil
+}
+
+// encodeValue returns the word of a static value, the length and the content
+// of a dynamic one
+func encodeValue(t Type, arg any) ([]byte, error) {
+	switch t {
+	case Uint256:
+		x, err := toBig(arg)
+		if err != nil {
+			return nil, err
+		}
+		return x.FillBytes(make([]byte, wordSize)), nil
+	case Bool:
+		b, ok := arg.(bool)
+		if !ok {
+			return nil, typeError(t, arg)
+		}
+		if b {
+			return uintWord(1), nil
+		}
+		return uintWord(0), nil
+	case Address:
+		addr, ok := arg.(types.Address)
+		if !ok {
+			return nil, typeError(t, arg)
+		}
+		return rightAligned(addr.Bytes()), nil
+	case Bytes32:
+		switch b := arg.(type) {
+		case [32]byte:
+			return b[:], nil
+		case types.Hash:
+			return b.Bytes(), nil
+		default:
+			return nil, typeError(t, arg)
+		}
+	case Bytes, String:
+		var b []byte
+		switch v := arg.(type) {
+		case []byte:
+			b = v
+		case string:
+			b = []byte(v)
+		default:
+			return nil, typeError(t, arg)
+		}
+		padded := make([]byte, (len(b)+wordSize-1)/wordSize*wordSize)
+		copy(padded, b)
+		return append(uintWord(uint64(len(b))), padded...), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, t)
+	}
+}
+
+func toBig(arg any) (*big.Int, error) {
+	var x *big.Int
+	switch v := arg.(type) {
+	case *big.Int:
+		x = v
+	case uint64:
+		x = new(big.Int).SetUint64(v)
+	case uint:
+		x = new(big.Int).SetUint64(uint64(v))
+	case int:
+		x = big.NewInt(int64(v))
+	case int64:
+		x = big.NewInt(v)
+	default:
+		return nil, typeError(Uint256, arg)
+	}
+	if x.Sign() < 0 || x.Cmp(maxUint256) > 0 {
+		return nil, fmt.Errorf("%w: %s out of range of uint256", ErrArgumentType, x)
+	}
+	return x, nil
+}
+
+func typeError(t Type, arg any) error {
+	return fmt.Errorf("%w: %T for %s", ErrArgumentType, arg, t)
+}
+
+func uintWord(x uint64) []byte {
+	return new(big.Int).SetUint64(x).FillBytes(make([]byte, wordSize))
+}
+
+func rightAligned(b []byte) []byte {
+	w := make([]byte, wordSize)
+	copy(w[wordSize-len(b):], b)
+	return w
+}
+
+// Decode decodes the values of the params from data. Ints are returned as
+// *big.Int, bytes32 as [32]byte.
+func (ps Params) Decode(data []byte) ([]any, error) {
+	if len(data) < len(ps)*wordSize {
+		return nil, fmt.Errorf("%w: %d bytes for %d values", ErrInvalidData, len(data), len(ps))
+	}
+	values := make([]any, len(ps))
+	for i, p := range ps {
+		w := data[i*wordSize : (i+1)*wordSize]
+		v, err := decodeValue(p.Type, w, data)
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %w", i, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// decodeValue decodes the value of the word w of data
+func decodeValue(t Type, w, data []byte) (any, error) {
+	switch t {
+	case Uint256:
+		return new(big.Int).SetBytes(w), nil
+	case Bool:
+		switch x := new(big.Int).SetBytes(w); {
+		case x.Sign() == 0:
+			return false, nil
+		case x.Cmp(big.NewInt(1)) == 0:
+			return true, nil
+		default:
+			return nil, fmt.Errorf("%w: bool %s", ErrInvalidData, x)
+		}
+	case Address:
+		if err := checkPadding(w[:wordSize-20]); err != nil {
+			return nil, err
+		}
+		return types.AddressFromBytes(w[wordSize-20:]), nil
+	case Bytes32:
+		return [32]byte(w), nil
+	case Bytes, String:
+		offset, err := decodeSize(w, len(data)-wordSize)
+		if err != nil {
+			return nil, err
+		}
+		size, err := decodeSize(data[offset:offset+wordSize], len(data)-offset-wordSize)
+		if err != nil {
+			return nil, err
+		}
+		b := data[offset+wordSize : offset+wordSize+size]
+		if t == String {
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, t)
+	}
+}
+
+// decodeSize reads an offset or a length of at most limit
+func decodeSize(w []byte, limit int) (int, error) {
+	x := new(big.Int).SetBytes(w)
+	if limit < 0 || !x.IsInt64() || x.Int64() > int64(limit) {
+		return 0, fmt.Errorf("%w: %s out of the %d bytes left", ErrInvalidData, x, max(limit, 0))
+	}
+	return int(x.Int64()), nil
+}
+
+func checkPadding(b []byte) error {
+	for _, c := range b {
+		if c != 0 {
+			return fmt.Errorf("%w: address with non-zero padding", ErrInvalidData)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"fmt"
@@ -50,9 +51,16 @@ func (s *Server) Stop() error {
 func (s *Server) SetRouter() *gin.Engine {
 	eg := gin.Default()
 	eg.GET("/block/:hash", s.handleGetBlock)
+	eg.GET("/block/:hash/receipts", s.handleGetBlockReceipts)
 	eg.GET("/tx/:hash", s.handleGetTransaction)
+	eg.GET("/tx/:hash/proof", s.handleGetTransactionProof)
+	eg.GET("/tx/:hash/receipt", s.handleGetTransactionReceipt)
 	eg.POST("/tx", s.handlePostTransaction)
+	eg.POST("/tx/simulate", s.handleSimulateTransaction)
+	eg.POST("/tx/call", s.handleCallTransaction)
 	eg.GET("/balance/:address", s.handleGetBalance)
+	eg.GET("/contract/:address", s.handleGetContract)
+	eg.GET("/debug/tx/:hash/trace", s.handleTraceTransaction)
 	eg.GET("/test", s.handleTest)
 	return eg
 }
@@ -64,7 +72,7 @@ func (s *Server) handleGetBlock(ctx *gin.Context) {
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
 				"msg":   "failed to get block",
-				"error": err,
+				"error": err.Error(),
 			})
 			return
 		}
@@ -81,7 +89,7 @@ func (s *Server) handleGetBlock(ctx *gin.Context) {
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"msg":   "failed to decode hash",
-			"error": err,
+			"error": err.Error(),
 		})
 		return
 	}
@@ -89,7 +97,7 @@ func (s *Server) handleGetBlock(ctx *gin.Context) {
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"msg":   "failed to get block",
-			"error": err,
+			"error": err.Error(),
 		})
 		return
 	}
@@ -111,7 +119,7 @@ func (s *Server) handleGetTransaction(ctx *gin.Context) {
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
 				"msg":   "failed to get block",
-				"error": err,
+				"error": err.Error(),
 			})
 			return
 		}
@@ -128,7 +136,7 @@ func (s *Server) handleGetTransaction(ctx *gin.Context) {
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"msg":   "failed to decode hash",
-			"error": err,
+			"error": err.Error(),
 		})
 		return
 	}
@@ -136,7 +144,7 @@ func (s *Server) handleGetTransaction(ctx *gin.Context) {
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"msg":   "failed to get block",
-			"error": err,
+			"error": err.Error(),
 		})
 		return
 	}
@@ -146,12 +154,90 @@ func (s *Server) handleGetTransaction(ctx *gin.Context) {
 	})
 }
 
+// hashParam decodes the hash parameter of the path, it answers the request
+// itself when the hash is invalid
+func hashParam(ctx *gin.Context) (types.Hash, bool) {
+	hash := ctx.Param("hash")
+	if strings.HasPrefix(hash, "0x") {
+		hash = hash[2:]
+	}
+	hashByte, err := hex.DecodeString(hash)
+	if err == nil && len(hashByte) != 32 {
+		err = fmt.Errorf("hash of %d bytes, expected 32", len(hashByte))
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg":   "failed to decode hash",
+			"error": err.Error(),
+		})
+		return types.Hash{}, false
+	}
+	return types.HashFromBytes(hashByte), true
+}
+
+func (s *Server) handleGetTransactionProof(ctx *gin.Context) {
+	hash, ok := hashParam(ctx)
+	if !ok {
+		return
+	}
+	proof, err := s.chain.GetTransactionProof(hash)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"msg":   "failed to get transaction proof",
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":   "success",
+		"proof": proof,
+	})
+}
+
+func (s *Server) handleGetTransactionReceipt(ctx *gin.Context) {
+	hash, ok := hashParam(ctx)
+	if !ok {
+		return
+	}
+	receipt, err := s.chain.GetReceipt(hash)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"msg":   "failed to get transaction receipt",
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":     "success",
+		"receipt": receipt,
+	})
+}
+
+func (s *Server) handleGetBlockReceipts(ctx *gin.Context) {
+	hash, ok := hashParam(ctx)
+	if !ok {
+		return
+	}
+	receipts, err := s.chain.GetBlockReceipts(hash)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"msg":   "failed to get block receipts",
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":      "success",
+		"receipts": receipts,
+	})
+}
+
 func (s *Server) handlePostTransaction(ctx *gin.Context) {
 	var tx core.Transaction
 	if err := tx.Decode(core.NewTxDecoder(ctx.Request.Body)); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"msg":   "failed to decode transaction",
-			"error": err,
+			"error": err.Error(),
 		})
 		return
 	}
@@ -162,6 +248,68 @@ func (s *Server) handlePostTransaction(ctx *gin.Context) {
 	})
 }
 
+// handleSimulateTransaction executes the posted transaction without
+// committing it, the transaction does not need to be signed
+func (s *Server) handleSimulateTransaction(ctx *gin.Context) {
+	s.simulateTransaction(ctx, s.chain.Simulate)
+}
+
+// handleCallTransaction executes the posted transaction as a read of the
+// chain, its sender needs neither an account nor funds
+func (s *Server) handleCallTransaction(ctx *gin.Context) {
+	s.simulateTransaction(ctx, s.chain.Call)
+}
+
+func (s *Server) simulateTransaction(ctx *gin.Context, simulate func(*core.Transaction) (*core.Simulation, error)) {
+	var tx core.Transaction
+	if err := tx.Decode(core.NewTxDecoder(ctx.Request.Body)); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg":   "failed to decode transaction",
+			"error": err.Error(),
+		})
+		return
+	}
+	simulation, err := simulate(&tx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg":   "failed to simulate transaction",
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":     "success",
+		"receipt": simulation.Receipt,
+		"diff":    simulation.Diff,
+	})
+}
+
+// handleTraceTransaction executes again a mined transaction and responds
+// with its steps as JSON lines
+func (s *Server) handleTraceTransaction(ctx *gin.Context) {
+	hash, ok := hashParam(ctx)
+	if !ok {
+		return
+	}
+	var trace bytes.Buffer
+	tracer := core.NewJSONTracer(&trace)
+	if _, err := s.chain.TraceTransaction(hash, tracer); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"msg":   "failed to trace transaction",
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := tracer.Err(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg":   "failed to trace transaction",
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.Data(http.StatusOK, "application/x-ndjson", trace.Bytes())
+}
+
 func (s *Server) handleGetBalance(ctx *gin.Context) {
 	addr := ctx.Param("address")
 	if addr == "" {
@@ -189,8 +337,38 @@ func (s *Server) handleGetBalance(ctx *gin.Context) {
 		})
 		return
 	}
+	nonce, err := s.chain.GetNonce(a)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"msg": "failed to get nonce",
+		})
+		return
+	}
 	ctx.JSON(http.StatusOK, gin.H{
 		"msg":     "success",
 		"balance": balance,
+		"nonce":   nonce,
+	})
+}
+
+func (s *Server) handleGetContract(ctx *gin.Context) {
+	addrBytes, err := hex.DecodeString(strings.TrimPrefix(ctx.Param("address"), "0x"))
+	if err != nil || len(addrBytes) != 20 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"msg": "failed to decode address",
+		})
+		return
+	}
+	code, err := s.chain.GetContractCode(types.AddressFromBytes(addrBytes))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"msg":   "failed to get contract",
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":  "success",
+		"code": hex.EncodeToString(code),
 	})
 }
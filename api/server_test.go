@@ -1,11 +1,18 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"github.com/matrix-go/block/asm"
 	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/crypto"
+	"github.com/matrix-go/block/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -22,3 +29,292 @@ func TestServer_SetRouter(t *testing.T) {
 	assert.Equal(t, http.StatusOK, recorder.Code)
 	assert.Equal(t, "{\"msg\":\"success\"}", recorder.Body.String())
 }
+
+func TestServer_GetTransactionProof(t *testing.T) {
+	genesis := core.NewBlock(&core.Header{Version: 1})
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+
+	validator, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	txs := make([]*core.Transaction, 0)
+	for i := 0; i < 3; i++ {
+		tx := core.NewTransaction(nil)
+		require.NoError(t, tx.Sign(validator))
+		txs = append(txs, tx)
+	}
+	block, err := chain.ProposeBlock(validator, txs)
+	require.NoError(t, err)
+	require.NoError(t, chain.AddBlock(block))
+
+	server := NewServer(ServerConfig{}, chain, nil)
+	router := server.SetRouter()
+
+	hash := txs[1].GetHash(core.NewTransactionHasher())
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/tx/0x"+hash.String()+"/proof", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var resp struct {
+		Proof core.TransactionProof `json:"proof"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+	assert.Equal(t, hash, resp.Proof.TxHash)
+	assert.Equal(t, block.DataHash, resp.Proof.DataHash)
+	assert.True(t, resp.Proof.Verify())
+
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/tx/0x"+types.RandomHash().String()+"/proof", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestServer_MalformedHash(t *testing.T) {
+	genesis := core.NewBlock(&core.Header{Version: 1})
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+	router := NewServer(ServerConfig{}, chain, nil).SetRouter()
+
+	for _, hash := range []string{"0xzz", "0x0102"} {
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/tx/"+hash+"/receipt", nil)
+		require.NoError(t, err)
+		router.ServeHTTP(recorder, req)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+		var resp struct {
+			Msg   string `json:"msg"`
+			Error string `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+		assert.Equal(t, "failed to decode hash", resp.Msg)
+		assert.NotEmpty(t, resp.Error)
+	}
+}
+
+func TestServer_GetReceipts(t *testing.T) {
+	genesis := core.NewBlock(&core.Header{Version: 1, GasLimit: 1000})
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+
+	validator, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	// log "hi" with topic "ev"
+	logTx := core.NewTransaction(asm.MustAssemble(`
+		push "hi"
+		push "ev"
+		log
+	`))
+	logTx.GasLimit = 100
+	require.NoError(t, logTx.Sign(validator))
+	// division by zero
+	failedTx := core.NewTransaction(asm.MustAssemble("push 1\npush 0\ndiv"))
+	failedTx.GasLimit = 100
+	failedTx.Nonce = 1
+	require.NoError(t, failedTx.Sign(validator))
+	block, err := chain.ProposeBlock(validator, []*core.Transaction{logTx, failedTx})
+	require.NoError(t, err)
+	require.NoError(t, chain.AddBlock(block))
+
+	router := NewServer(ServerConfig{}, chain, nil).SetRouter()
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/tx/0x"+logTx.GetHash(core.NewTransactionHasher()).String()+"/receipt", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var resp struct {
+		Receipt core.Receipt `json:"receipt"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+	assert.False(t, resp.Receipt.Failed())
+	assert.Equal(t, block.GetHash(core.NewHeaderHasher()), resp.Receipt.BlockHash)
+	require.Len(t, resp.Receipt.Logs, 1)
+	assert.Equal(t, "ev", string(resp.Receipt.Logs[0].Topic))
+	assert.Equal(t, "hi", string(resp.Receipt.Logs[0].Data))
+
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/block/0x"+block.GetHash(core.NewHeaderHasher()).String()+"/receipts", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var blockResp struct {
+		Receipts []core.Receipt `json:"receipts"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &blockResp))
+	require.Len(t, blockResp.Receipts, 2)
+	assert.True(t, blockResp.Receipts[1].Failed())
+	assert.Equal(t, block.GasUsed, blockResp.Receipts[0].GasUsed+blockResp.Receipts[1].GasUsed)
+}
+
+func TestServer_GetContract(t *testing.T) {
+	genesis := core.NewBlock(&core.Header{Version: 1, GasLimit: 1000})
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+
+	validator, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	tx := core.NewTransaction(nil)
+	tx.InnerTx = &core.DeployTx{Code: asm.MustAssemble("push 1")}
+	tx.GasLimit = 100
+	require.NoError(t, tx.Sign(validator))
+	block, err := chain.ProposeBlock(validator, []*core.Transaction{tx})
+	require.NoError(t, err)
+	require.NoError(t, chain.AddBlock(block))
+
+	router := NewServer(ServerConfig{}, chain, nil).SetRouter()
+	contract := core.ContractAddress(validator.PublicKey().Address(), 0)
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/contract/0x"+contract.String(), nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"code":"010a"`)
+
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/contract/0x"+types.Address{}.String(), nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestServer_SimulateTransaction(t *testing.T) {
+	genesis := core.NewBlock(&core.Header{Version: 1, GasLimit: 1000})
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+	root := chain.StateRoot()
+
+	sender, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	// return 7, unsigned
+	tx := core.NewTransaction(asm.MustAssemble("push 7\nreturn"))
+	tx.From = sender.PublicKey()
+	tx.GasLimit = 100
+	var buf bytes.Buffer
+	require.NoError(t, tx.Encode(core.NewTxEncoder(&buf)))
+
+	router := NewServer(ServerConfig{}, chain, nil).SetRouter()
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/tx/simulate", &buf)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	var resp struct {
+		Receipt core.Receipt   `json:"receipt"`
+		Diff    core.StateDiff `json:"diff"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+	assert.False(t, resp.Receipt.Failed())
+	assert.Equal(t, int64(7), new(big.Int).SetBytes(resp.Receipt.Return).Int64())
+	require.Len(t, resp.Diff.Accounts, 1)
+	assert.Equal(t, sender.PublicKey().Address(), resp.Diff.Accounts[0].Address)
+	assert.Equal(t, uint64(1), resp.Diff.Accounts[0].After.Nonce)
+	assert.Equal(t, root, chain.StateRoot())
+
+	// the nonce is checked against the state
+	tx.Nonce = 5
+	buf.Reset()
+	require.NoError(t, tx.Encode(core.NewTxEncoder(&buf)))
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/tx/simulate", &buf)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), core.ErrNonceTooHigh.Error())
+}
+
+func TestServer_CallTransaction(t *testing.T) {
+	genesis := core.NewBlock(&core.Header{Version: 1, GasLimit: 1000})
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: genesis, MinGasPrice: 1})
+	require.NoError(t, err)
+
+	// an unfunded sender reads 7, with neither gas price nor gas limit
+	sender, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	tx := core.NewTransaction(asm.MustAssemble("push 7\nreturn"))
+	tx.From = sender.PublicKey()
+	var buf bytes.Buffer
+	require.NoError(t, tx.Encode(core.NewTxEncoder(&buf)))
+	body := buf.Bytes()
+
+	router := NewServer(ServerConfig{}, chain, nil).SetRouter()
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/tx/simulate", bytes.NewReader(body))
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "/tx/call", bytes.NewReader(body))
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var resp struct {
+		Receipt core.Receipt   `json:"receipt"`
+		Diff    core.StateDiff `json:"diff"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+	assert.False(t, resp.Receipt.Failed())
+	assert.Equal(t, int64(7), new(big.Int).SetBytes(resp.Receipt.Return).Int64())
+	assert.Empty(t, resp.Diff.Accounts)
+}
+
+func TestServer_TraceTransaction(t *testing.T) {
+	genesis := core.NewBlock(&core.Header{Version: 1, GasLimit: 1000})
+	chain, err := core.NewBlockchain(core.BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+
+	validator, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	// store 1 under K then divide by zero
+	tx := core.NewTransaction(asm.MustAssemble(`
+		push 1
+		pushb 'K'
+		store
+		push 1
+		push 0
+		div
+	`))
+	tx.GasLimit = 200
+	require.NoError(t, tx.Sign(validator))
+	block, err := chain.ProposeBlock(validator, []*core.Transaction{tx})
+	require.NoError(t, err)
+	require.NoError(t, chain.AddBlock(block))
+
+	router := NewServer(ServerConfig{}, chain, nil).SetRouter()
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/debug/tx/0x"+tx.GetHash(core.NewTransactionHasher()).String()+"/trace", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	require.Len(t, lines, 6)
+	var step struct {
+		Op      string `json:"op"`
+		Storage []struct {
+			Key   string `json:"key"`
+			Write bool   `json:"write"`
+		} `json:"storage"`
+		Err string `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &step))
+	assert.Equal(t, "store", step.Op)
+	require.Len(t, step.Storage, 1)
+	assert.Equal(t, "0x4b", step.Storage[0].Key)
+	assert.True(t, step.Storage[0].Write)
+	require.NoError(t, json.Unmarshal([]byte(lines[5]), &step))
+	assert.Equal(t, "div", step.Op)
+	assert.Contains(t, step.Err, core.ErrDivisionByZero.Error())
+
+	recorder = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/debug/tx/0x"+types.RandomHash().String()+"/trace", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,379 @@
+// Package asm translates between the text assembly of the VM and its bytecode.
+//
+// A line holds a label, an instruction or both, ";" starts a comment:
+//
+//	start:              ; names the offset of the next instruction
+//	    jumpdest
+//	    push 3          ; push the int 3
+//	    push 100000     ; ints above 255 are pushed with pushn
+//	    pushn 0x0001    ; push the bytes after pushn as an int, 2 of them here
+//	    pushb 'F'       ; push the byte 'F', 0x46 works as well
+//	    push "FOO"      ; push every byte of FOO then pack them
+//	    push @start     ; push the offset of a label
+//	    jump
+//	    .byte 0x0a 0x0c ; raw bytes, for code the VM cannot run
+//
+// Instructions are the mnemonics of core.Instruction.
+package asm
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/matrix-go/block/core"
+)
+
+// MustAssemble is Assemble for code known to be valid, it panics otherwise
+func MustAssemble(src string) []byte {
+	code, err := Assemble(src)
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+// Assemble returns the bytecode of the assembly src
+func Assemble(src string) ([]byte, error) {
+	stmts, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]int)
+	offset := 0
+	for _, stmt := range stmts {
+		if stmt.label != "" {
+			if _, ok := labels[stmt.label]; ok {
+				return nil, fmt.Errorf("line %d: label %s: %w", stmt.line, stmt.label, ErrDuplicateLabel)
+			}
+			labels[stmt.label] = offset
+		}
+		offset += stmt.size()
+	}
+
+	var code []byte
+	// starts are the offsets at which the VM must find the instructions
+	starts := make(map[int]*statement)
+	for _, stmt := range stmts {
+		if stmt.mnemonic == "" {
+			continue
+		}
+		if stmt.mnemonic != ".byte" {
+			starts[len(code)] = stmt
+		}
+		if code, err = stmt.emit(code, labels); err != nil {
+			return nil, fmt.Errorf("line %d: %w", stmt.line, err)
+		}
+	}
+	// an operand that is a push opcode is read as a push of the byte
+	// before it
+	decoded := make(map[int]struct{})
+	for _, instr := range decode(code) {
+		decoded[instr.offset] = struct{}{}
+	}
+	for offset, stmt := range starts {
+		if _, ok := decoded[offset]; !ok {
+			return nil, fmt.Errorf("line %d: %s %s: %w", stmt.line, stmt.mnemonic, strings.Join(stmt.args, " "), ErrAmbiguousOperand)
+		}
+	}
+	return code, nil
+}
+
+// statement is a line of assembly
+type statement struct {
+	line     int
+	label    string
+	mnemonic string
+	args     []string
+}
+
+func (s *statement) size() int {
+	switch {
+	case s.mnemonic == "":
+		return 0
+	case s.mnemonic == ".byte":
+		return len(s.args)
+	case s.isPush() && strings.HasPrefix(s.args[0], `"`):
+		str, _ := strconv.Unquote(s.args[0])
+		// a push of every byte and of the length, then a pack
+		return 2*len(str) + 3
+	case s.mnemonic == "pushn" && strings.HasPrefix(s.args[0], "@"):
+		return 2 + labelSize
+	case s.mnemonic == "pushn" || s.mnemonic == "push" && !strings.HasPrefix(s.args[0], "@"):
+		b, _ := intBytes(s.mnemonic, s.args[0])
+		if b == nil {
+			return 2
+		}
+		return 2 + len(b)
+	case s.isPush():
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (s *statement) isPush() bool {
+	instr, ok := core.ParseInstruction(s.mnemonic)
+	return ok && instr.IsPush()
+}
+
+func (s *statement) emit(code []byte, labels map[string]int) ([]byte, error) {
+	if s.mnemonic == ".byte" {
+		for _, arg := range s.args {
+			b, err := parseByte(arg)
+			if err != nil {
+				return nil, err
+			}
+			code = append(code, b)
+		}
+		return code, nil
+	}
+	instr, _ := core.ParseInstruction(s.mnemonic)
+	if !instr.IsPush() {
+		return append(code, byte(instr)), nil
+	}
+	arg := s.args[0]
+	switch {
+	case strings.HasPrefix(arg, `"`):
+		str, _ := strconv.Unquote(arg)
+		for i := 0; i < len(str); i++ {
+			code = append(code, str[i], byte(core.InstructionPushByte))
+		}
+		return append(code, byte(len(str)), byte(core.InstructionPushInt), byte(core.InstructionPack)), nil
+	case strings.HasPrefix(arg, "@"):
+		offset, ok := labels[arg[1:]]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", arg, ErrUnknownLabel)
+		}
+		if instr == core.InstructionPushN {
+			if offset >= 1<<(8*labelSize) {
+				return nil, fmt.Errorf("label %s at %d: %w", arg, offset, ErrOperandRange)
+			}
+			return append(code, labelSize, byte(instr), byte(offset>>8), byte(offset)), nil
+		}
+		if offset > 0xff {
+			return nil, fmt.Errorf("label %s at %d: %w", arg, offset, ErrOperandRange)
+		}
+		return append(code, byte(offset), byte(instr)), nil
+	case instr == core.InstructionPushByte:
+		b, err := parseByte(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append(code, b, byte(instr)), nil
+	default:
+		b, err := intBytes(s.mnemonic, arg)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			n, _ := parseByte(arg)
+			return append(code, n, byte(instr)), nil
+		}
+		code = append(code, byte(len(b)), byte(core.InstructionPushN))
+		return append(code, b...), nil
+	}
+}
+
+// labelSize is the size of the label offsets pushed by pushn
+const labelSize = 2
+
+var maxInt = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// intBytes returns the bytes that pushn pushes for arg, nil when push
+// pushes arg with its operand. A hex argument of pushn keeps its width.
+// Operands of 10 and 12 bytes would be read as the operand of a push, ints
+// that fit in them take one more byte.
+func intBytes(mnemonic, arg string) ([]byte, error) {
+	if strings.HasPrefix(arg, "'") && mnemonic == "push" {
+		_, err := parseByte(arg)
+		return nil, err
+	}
+	if mnemonic == "pushn" && strings.HasPrefix(arg, "0x") {
+		digits := arg[2:]
+		if len(digits)%2 == 1 {
+			digits = "0" + digits
+		}
+		b, err := hex.DecodeString(digits)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("%s: %w", arg, ErrSyntax)
+		}
+		if len(b) > 32 || len(b) == int(core.InstructionPushInt) || len(b) == int(core.InstructionPushByte) {
+			return nil, fmt.Errorf("%s of %d bytes: %w", arg, len(b), ErrOperandRange)
+		}
+		return b, nil
+	}
+	x, ok := new(big.Int).SetString(arg, 0)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", arg, ErrSyntax)
+	}
+	if x.Sign() < 0 || x.Cmp(maxInt) > 0 {
+		return nil, fmt.Errorf("%s: %w", arg, ErrOperandRange)
+	}
+	if mnemonic == "push" && x.BitLen() <= 8 {
+		return nil, nil
+	}
+	size := max(1, (x.BitLen()+7)/8)
+	if size == int(core.InstructionPushInt) || size == int(core.InstructionPushByte) {
+		size++
+	}
+	return x.FillBytes(make([]byte, size)), nil
+}
+
+// parseByte parses a number or a character literal
+func parseByte(arg string) (byte, error) {
+	if strings.HasPrefix(arg, "'") {
+		s, err := strconv.Unquote(arg)
+		if err != nil || len(s) != 1 {
+			return 0, fmt.Errorf("%s: %w", arg, ErrSyntax)
+		}
+		return s[0], nil
+	}
+	n, err := strconv.ParseUint(arg, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", arg, ErrSyntax)
+	}
+	if n > 0xff {
+		return 0, fmt.Errorf("%s: %w", arg, ErrOperandRange)
+	}
+	return byte(n), nil
+}
+
+func parse(src string) ([]*statement, error) {
+	var stmts []*statement
+	for i, line := range strings.Split(src, "\n") {
+		fields, err := split(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		stmt := &statement{line: i + 1}
+		if label, ok := strings.CutSuffix(fields[0], ":"); ok {
+			if !isIdentifier(label) {
+				return nil, fmt.Errorf("line %d: label %s: %w", stmt.line, label, ErrSyntax)
+			}
+			stmt.label = label
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			stmt.mnemonic = strings.ToLower(fields[0])
+			stmt.args = fields[1:]
+			if err = stmt.validate(); err != nil {
+				return nil, fmt.Errorf("line %d: %w", stmt.line, err)
+			}
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// validate checks the arguments that the size of the statement depends on,
+// labels are resolved by emit
+func (s *statement) validate() error {
+	if s.mnemonic == ".byte" {
+		if len(s.args) == 0 {
+			return fmt.Errorf(".byte without bytes: %w", ErrSyntax)
+		}
+		return nil
+	}
+	instr, ok := core.ParseInstruction(s.mnemonic)
+	if !ok {
+		return fmt.Errorf("%s: %w", s.mnemonic, ErrUnknownMnemonic)
+	}
+	if !instr.IsPush() {
+		if len(s.args) > 0 {
+			return fmt.Errorf("%s takes no operand: %w", s.mnemonic, ErrSyntax)
+		}
+		return nil
+	}
+	if len(s.args) != 1 {
+		return fmt.Errorf("%s takes one operand: %w", s.mnemonic, ErrSyntax)
+	}
+	arg := s.args[0]
+	switch {
+	case strings.HasPrefix(arg, `"`):
+		if instr != core.InstructionPushInt {
+			return fmt.Errorf("%s %s: %w", s.mnemonic, arg, ErrSyntax)
+		}
+		str, err := strconv.Unquote(arg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, ErrSyntax)
+		}
+		if len(str) > 0xff {
+			return fmt.Errorf("string of %d bytes: %w", len(str), ErrOperandRange)
+		}
+	case strings.HasPrefix(arg, "@"):
+		if !isIdentifier(arg[1:]) || instr == core.InstructionPushByte {
+			return fmt.Errorf("%s: %w", arg, ErrSyntax)
+		}
+	case instr == core.InstructionPushByte:
+		_, err := parseByte(arg)
+		return err
+	default:
+		_, err := intBytes(s.mnemonic, arg)
+		return err
+	}
+	return nil
+}
+
+// split returns the fields of a line without its comment, quoted literals
+// are kept whole
+func split(line string) ([]string, error) {
+	var fields []string
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ';':
+			return fields, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(line) && line[end] != c {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated literal %s: %w", line[i:], ErrSyntax)
+			}
+			fields = append(fields, line[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) && !strings.ContainsRune(" \t\r;", rune(line[end])) {
+				end++
+			}
+			fields = append(fields, line[i:end])
+			i = end
+		}
+	}
+	return fields, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	ErrSyntax           = errors.New("syntax error")
+	ErrUnknownMnemonic  = errors.New("unknown mnemonic")
+	ErrUnknownLabel     = errors.New("unknown label")
+	ErrDuplicateLabel   = errors.New("duplicate label")
+	ErrOperandRange     = errors.New("operand out of range")
+	ErrAmbiguousOperand = errors.New("operand read as a push")
+)
@@ -0,0 +1,149 @@
+package asm
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matrix-go/block/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssemble(t *testing.T) {
+	code, err := Assemble(`
+		push 3   ; comment
+		push 0x02
+		sub
+		push "FOO"
+		store
+	`)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x03, 0x0a, 0x02, 0x0a, 0x0e,
+		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d,
+		0x0f,
+	}, code)
+
+	code, err = Assemble("pushb 'F'\npushb 0b1\n.byte 0xff 7")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{'F', 0x0c, 0x01, 0x0c, 0xff, 0x07}, code)
+
+	// ints above 255 are pushed with pushn
+	code, err = Assemble("push 300\npushn 0x0001\npushn 1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x02, 0x27, 0x01, 0x2c, 0x02, 0x27, 0x00, 0x01, 0x01, 0x27, 0x01}, code)
+
+	// 10 bytes would make the length the operand of a push
+	code, err = Assemble("add\npush 0x" + strings.Repeat("ff", 10))
+	require.NoError(t, err)
+	assert.Equal(t, slices.Concat([]byte{0x0b, 0x0b, 0x27, 0x00}, bytes.Repeat([]byte{0xff}, 10)), code)
+}
+
+func TestAssemble_WideInts(t *testing.T) {
+	// 2^200 / 2^186 + 1000, every result is used by the next instruction
+	code, err := Assemble(`
+		push 0x1` + strings.Repeat("00", 25) + `
+		push 0x4` + strings.Repeat("00", 23) + `
+		div
+		push 1000
+		add
+		return
+	`)
+	require.NoError(t, err)
+	vm := core.NewVM(code, core.NewState(), 10_000, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, big.NewInt(1<<14+1000), vm.ReturnValue())
+}
+
+func TestAssemble_Labels(t *testing.T) {
+	code, err := Assemble(`
+		push 1
+		push @skip
+		jumpi
+		push 7
+		return
+	skip:
+		jumpdest
+		push 9
+		return
+	`)
+	require.NoError(t, err)
+	assert.Equal(t, byte(8), code[2])
+	vm := core.NewVM(code, core.NewState(), 10_000, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, big.NewInt(9), vm.ReturnValue())
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  error
+	}{
+		{name: "unknown mnemonic", src: "push 1\nfoo", err: ErrUnknownMnemonic},
+		{name: "missing operand", src: "push", err: ErrSyntax},
+		{name: "extra operand", src: "add 1", err: ErrSyntax},
+		{name: "bad number", src: "push x1", err: ErrSyntax},
+		{name: "unterminated string", src: `push "FOO`, err: ErrSyntax},
+		{name: "byte string", src: `pushb "FOO"`, err: ErrSyntax},
+		{name: "operand too big", src: "pushb 256", err: ErrOperandRange},
+		{name: "int too big", src: "push 0x1" + strings.Repeat("00", 32), err: ErrOperandRange},
+		{name: "negative int", src: "push -1", err: ErrOperandRange},
+		{name: "pushn of 10 bytes", src: "pushn 0x" + strings.Repeat("01", 10), err: ErrOperandRange},
+		{name: "unknown label", src: "push @end\njump", err: ErrUnknownLabel},
+		{name: "duplicate label", src: "a: jumpdest\na: jumpdest", err: ErrDuplicateLabel},
+		// the operand 10 makes add the operand of a push
+		{name: "ambiguous operand", src: "add\npush 10", err: ErrAmbiguousOperand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.src)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	src := "\tpush 3\n" +
+		"dest_2:\n" +
+		"\tjumpdest\n" +
+		"\tpushb 'F'\n" +
+		"\tpushb 0x00\n" +
+		"\tpush \"FOO\"\n" +
+		"\tpush @dest_2\n" +
+		"\tjumpi\n" +
+		"\tpushn 0x012c\n" +
+		"\tpushn @dest_2\n" +
+		"\tjumpi\n" +
+		"\t.byte 0xff\n" +
+		"\treturn\n"
+	code, err := Assemble(src)
+	require.NoError(t, err)
+	assert.Equal(t, src, Disassemble(code))
+}
+
+func TestDisassemble_RoundTrip(t *testing.T) {
+	codes := [][]byte{
+		{},
+		{0x0a},             // push without operand
+		{0x0a, 0x0a, 0x0a}, // operand that is a push opcode
+		{0x02, 0x0a, 0x0d}, // pack without bytes
+		{0x00, 0x0a, 0x0d}, // empty string
+		{0x03, 0x0a, 0x13, 0x15, 0xee, 0x01},
+		{0x0a, 0x0c, 0x22, 0x0c, 0x5c, 0x0c, 0x03, 0x0a, 0x0d},
+		{0x02, 0x27, 0x01, 0x2c, 0x0b},
+		{0x02, 0x27, 0x00, 0x0a, 0x13},
+		{0x21, 0x27, 0x01},       // pushn of 33 bytes
+		{0x03, 0x27, 0x01, 0x02}, // pushn without its bytes
+		{0x01, 0x27, 0x15, 0x15}, // pushn of a jumpdest
+	}
+	for _, code := range codes {
+		src := Disassemble(code)
+		assembled, err := Assemble(src)
+		require.NoError(t, err, src)
+		assert.Equal(t, code, append([]byte{}, assembled...), src)
+	}
+}
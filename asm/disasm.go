@@ -0,0 +1,141 @@
+package asm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/matrix-go/block/core"
+)
+
+// instruction is an instruction of the bytecode as the VM reads it
+type instruction struct {
+	offset  int // of the operand for a push
+	op      core.Instruction
+	operand byte
+	imm     []byte // bytes pushed by core.InstructionPushN
+	raw     bool   // a byte that the VM cannot run
+}
+
+// decode walks code the way core.VM.Run does
+func decode(code []byte) []instruction {
+	var instrs []instruction
+	for ip := 0; ip < len(code); ip++ {
+		if ip+1 < len(code) && core.Instruction(code[ip+1]) == core.InstructionPushN {
+			n := int(code[ip])
+			if n == 0 || n > 32 || ip+1+n >= len(code) {
+				instrs = append(instrs, instruction{offset: ip, op: core.Instruction(code[ip]), raw: true})
+				continue
+			}
+			instrs = append(instrs, instruction{offset: ip, op: core.InstructionPushN, operand: code[ip], imm: code[ip+2 : ip+2+n]})
+			ip += 1 + n
+			continue
+		}
+		if ip+1 < len(code) && core.Instruction(code[ip+1]).IsPush() {
+			instrs = append(instrs, instruction{offset: ip, op: core.Instruction(code[ip+1]), operand: code[ip]})
+			ip++
+			continue
+		}
+		op := core.Instruction(code[ip])
+		_, known := op.Mnemonic()
+		instrs = append(instrs, instruction{offset: ip, op: op, raw: !known || op.IsPush()})
+	}
+	return instrs
+}
+
+// Disassemble returns the assembly of code, assembling it gives code back.
+// Jump destinations are labelled and packed pushes are shown as strings.
+func Disassemble(code []byte) string {
+	instrs := decode(code)
+	dests := make(map[int]struct{})
+	for _, instr := range instrs {
+		if instr.op == core.InstructionJumpDest && !instr.raw {
+			dests[instr.offset] = struct{}{}
+		}
+	}
+
+	var lines []string
+	for i, instr := range instrs {
+		switch {
+		case instr.raw:
+			lines = append(lines, fmt.Sprintf(".byte 0x%02x", byte(instr.op)))
+		case instr.op == core.InstructionPack && packsString(instrs[:i]):
+			n := int(instrs[i-1].operand)
+			var str []byte
+			for _, pushed := range instrs[i-1-n : i-1] {
+				str = append(str, pushed.operand)
+			}
+			// replace the pushes of the bytes and of the length
+			lines = append(lines[:len(lines)-n-1], "push "+strconv.Quote(string(str)))
+		case instr.op == core.InstructionPushInt:
+			_, isDest := dests[int(instr.operand)]
+			if isDest && jumps(instrs, i) {
+				lines = append(lines, fmt.Sprintf("push @%s", label(int(instr.operand))))
+			} else {
+				lines = append(lines, fmt.Sprintf("push %d", instr.operand))
+			}
+		case instr.op == core.InstructionPushN:
+			dest := new(big.Int).SetBytes(instr.imm)
+			_, isDest := dests[int(dest.Int64())]
+			if len(instr.imm) == labelSize && isDest && jumps(instrs, i) {
+				lines = append(lines, fmt.Sprintf("pushn @%s", label(int(dest.Int64()))))
+			} else {
+				lines = append(lines, "pushn 0x"+hex.EncodeToString(instr.imm))
+			}
+		case instr.op == core.InstructionPushByte:
+			lines = append(lines, "pushb "+byteLiteral(instr.operand))
+		case instr.op == core.InstructionJumpDest:
+			lines = append(lines, label(instr.offset)+":", instr.op.String())
+		default:
+			lines = append(lines, instr.op.String())
+		}
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, ":") {
+			lines[i] = "\t" + line
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// packsString reports whether the instructions before a pack push bytes
+// and then their number
+func packsString(before []instruction) bool {
+	if len(before) == 0 {
+		return false
+	}
+	length := before[len(before)-1]
+	if length.raw || length.op != core.InstructionPushInt || int(length.operand) > len(before)-1 {
+		return false
+	}
+	for _, instr := range before[len(before)-1-int(length.operand) : len(before)-1] {
+		if instr.raw || instr.op != core.InstructionPushByte {
+			return false
+		}
+	}
+	return true
+}
+
+// jumps reports whether the instruction after i is a jump
+func jumps(instrs []instruction, i int) bool {
+	if i+1 == len(instrs) || instrs[i+1].raw {
+		return false
+	}
+	return instrs[i+1].op == core.InstructionJump || instrs[i+1].op == core.InstructionJumpIf
+}
+
+func label(offset int) string {
+	return fmt.Sprintf("dest_%d", offset)
+}
+
+func byteLiteral(b byte) string {
+	if b > ' ' && b < 0x7f && b != '\'' && b != '\\' {
+		return strconv.QuoteRune(rune(b))
+	}
+	return fmt.Sprintf("0x%02x", b)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/matrix-go/block/asm"
+)
+
+const commandUsage = `usage:
+  block asm [file]               assemble a file, or stdin, to hex bytecode
+  block disasm [hex]             disassemble hex bytecode, read from stdin if empty
+  block disasm -tx hash [-api]   disassemble the data of a transaction of a node`
+
+// runCommand runs the command of args instead of a node
+func runCommand(args []string) error {
+	switch args[0] {
+	case "asm":
+		return assembleCommand(args[1:])
+	case "disasm":
+		return disassembleCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandUsage)
+	}
+}
+
+func assembleCommand(args []string) error {
+	var (
+		src []byte
+		err error
+	)
+	if len(args) > 0 {
+		src, err = os.ReadFile(args[0])
+	} else {
+		src, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	code, err := asm.Assemble(string(src))
+	if err != nil {
+		return err
+	}
+	fmt.Println(hex.EncodeToString(code))
+	return nil
+}
+
+func disassembleCommand(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	txHash := fs.String("tx", "", "hash of the transaction to disassemble")
+	apiAddr := fs.String("api", "http://localhost:9000", "api of the node that has the transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var code []byte
+	if *txHash != "" {
+		data, err := fetchTransactionData(*apiAddr, *txHash)
+		if err != nil {
+			return err
+		}
+		code = data
+	} else {
+		input := fs.Arg(0)
+		if input == "" {
+			stdin, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			input = string(stdin)
+		}
+		var err error
+		code, err = hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(input), "0x"))
+		if err != nil {
+			return fmt.Errorf("failed to decode bytecode: %s", err)
+		}
+	}
+	fmt.Print(asm.Disassemble(code))
+	return nil
+}
+
+func fetchTransactionData(apiAddr, hash string) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/tx/%s", strings.TrimSuffix(apiAddr, "/"), hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get tx: %s", resp.Status)
+	}
+	var body struct {
+		Transactions []struct {
+			Data []byte
+		} `json:"transactions"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode tx: %s", err)
+	}
+	if len(body.Transactions) == 0 {
+		return nil, fmt.Errorf("transaction %s not found", hash)
+	}
+	return body.Transactions[0].Data, nil
+}
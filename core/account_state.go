@@ -1,8 +1,10 @@
 package core
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"github.com/matrix-go/block/trie"
 	"github.com/matrix-go/block/types"
 	"sync"
 )
@@ -10,45 +12,155 @@ import (
 type Account struct {
 	Address types.Address
 	Balance uint64
+	// Nonce is the nonce the next transaction of the account must carry
+	Nonce uint64
 }
 
 func (a Account) String() string {
-	return fmt.Sprintf("address=%+v, balance=%d", a.Address, a.Balance)
+	return fmt.Sprintf("address=%+v, balance=%d, nonce=%d", a.Address, a.Balance, a.Nonce)
+}
+
+// Bytes is the encoding of the account kept in the state trie
+func (a Account) Bytes() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, a.Balance)
+	binary.BigEndian.PutUint64(b[8:], a.Nonce)
+	return b
+}
+
+func accountFromBytes(addr types.Address, b []byte) (*Account, error) {
+	if len(b) != 16 {
+		return nil, fmt.Errorf("invalid account encoding of %s", addr)
+	}
+	return &Account{
+		Address: addr,
+		Balance: binary.BigEndian.Uint64(b),
+		Nonce:   binary.BigEndian.Uint64(b[8:]),
+	}, nil
 }
 
 type AccountState struct {
-	lock  sync.RWMutex
-	state map[types.Address]*Account
+	lock    sync.RWMutex
+	trie    *trie.Trie
+	journal *Journal
 }
 
 func NewAccountState() *AccountState {
 	return &AccountState{
-		state: make(map[types.Address]*Account),
+		trie: trie.New(),
 	}
 }
 
-func (s *AccountState) CreateAccount(addr types.Address) error {
-	_, err := s.GetAccount(addr)
-	if err == nil {
-		return ErrAlreadyExists
+// setJournal makes every later change of the state revertible through journal
+func (s *AccountState) setJournal(journal *Journal) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.journal = journal
+}
+
+// record keeps the current trie in the journal,
+// it must be called with the lock held.
+func (s *AccountState) record() {
+	if s.journal == nil {
+		return
 	}
+	prev := s.trie.Copy()
+	s.journal.record(func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.trie = prev
+	})
+}
+
+// copy returns an account state with the content of s whose changes are
+// recorded in journal
+func (s *AccountState) copy(journal *Journal) *AccountState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &AccountState{trie: s.trie.Copy(), journal: journal}
+}
+
+// iterate visits every account in ascending address order until fn
+// returns false
+func (s *AccountState) iterate(fn func(account *Account) bool) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var err error
+	s.trie.Iterate(func(k, v []byte) bool {
+		var account *Account
+		if account, err = accountFromBytes(types.AddressFromBytes(k), v); err != nil {
+			return false
+		}
+		return fn(account)
+	})
+	return err
+}
+
+// diff calls fn in ascending address order with every account that differs
+// between before and s, a missing account is nil
+func (s *AccountState) diff(before *AccountState, fn func(addr types.Address, prev, next *Account)) error {
+	before.lock.RLock()
+	defer before.lock.RUnlock()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var err error
+	trie.Diff(before.trie, s.trie, func(k, prev, next []byte) {
+		if err != nil {
+			return
+		}
+		addr := types.AddressFromBytes(k)
+		var accounts [2]*Account
+		for i, v := range [][]byte{prev, next} {
+			if v == nil {
+				continue
+			}
+			if accounts[i], err = accountFromBytes(addr, v); err != nil {
+				return
+			}
+		}
+		fn(addr, accounts[0], accounts[1])
+	})
+	return err
+}
+
+// Root returns the root hash of the account trie
+func (s *AccountState) Root() types.Hash {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.trie.Hash()
+}
+
+// get must be called with the lock held
+func (s *AccountState) get(addr types.Address) (*Account, error) {
+	b, ok := s.trie.Get(addr.Bytes())
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return accountFromBytes(addr, b)
+}
+
+// put must be called with the lock held
+func (s *AccountState) put(account *Account) {
+	s.record()
+	s.trie.Put(account.Address.Bytes(), account.Bytes())
+}
+
+func (s *AccountState) CreateAccount(addr types.Address) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.state[addr] = &Account{
-		Address: addr,
+	if _, err := s.get(addr); err == nil {
+		return ErrAlreadyExists
 	}
+	s.put(&Account{
+		Address: addr,
+	})
 	return nil
 }
 
 func (s *AccountState) GetAccount(addr types.Address) (account *Account, err error) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	var exists bool
-	account, exists = s.state[addr]
-	if !exists {
-		err = ErrAccountNotFound
-	}
-	return account, err
+	return s.get(addr)
 }
 
 func (s *AccountState) GetBalance(addr types.Address) (balance uint64, err error) {
@@ -59,51 +171,92 @@ func (s *AccountState) GetBalance(addr types.Address) (balance uint64, err error
 	return account.Balance, nil
 }
 
+// GetNonce returns the nonce the next transaction of addr must carry
+func (s *AccountState) GetNonce(addr types.Address) (uint64, error) {
+	account, err := s.GetAccount(addr)
+	if errors.Is(err, ErrAccountNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return account.Nonce, nil
+}
+
+// UseNonce checks that nonce is the next one of addr and moves past it
+func (s *AccountState) UseNonce(addr types.Address, nonce uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	account, err := s.get(addr)
+	if errors.Is(err, ErrAccountNotFound) {
+		account, err = &Account{Address: addr}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if nonce < account.Nonce {
+		return fmt.Errorf("%s nonce %d, expected %d: %w", addr, nonce, account.Nonce, ErrNonceTooLow)
+	}
+	if nonce > account.Nonce {
+		return fmt.Errorf("%s nonce %d, expected %d: %w", addr, nonce, account.Nonce, ErrNonceTooHigh)
+	}
+	account.Nonce++
+	s.put(account)
+	return nil
+}
+
 func (s *AccountState) AddBalance(to types.Address, amount uint64) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if _, ok := s.state[to]; ok {
-		s.state[to].Balance += amount
-	} else {
-		s.state[to] = &Account{
-			Address: to,
-			Balance: amount,
-		}
+	account, err := s.get(to)
+	if errors.Is(err, ErrAccountNotFound) {
+		account, err = &Account{Address: to}, nil
+	}
+	if err != nil {
+		return err
 	}
+	account.Balance += amount
+	s.put(account)
 	return nil
 }
 
 func (s *AccountState) SubBalance(from types.Address, amount uint64) error {
-	account, err := s.GetAccount(from)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	account, err := s.get(from)
 	if err != nil {
 		return err
 	}
 	if account.Balance < amount {
 		return ErrInsufficientBalance
 	}
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	s.state[from].Balance -= amount
+	account.Balance -= amount
+	s.put(account)
 	return nil
 }
 
 func (s *AccountState) Transfer(from types.Address, to types.Address, amount uint64) error {
-	account, err := s.GetAccount(from)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fromAccount, err := s.get(from)
 	if err != nil {
 		return err
 	}
-	if account.Balance < amount {
+	if fromAccount.Balance < amount {
 		return ErrInsufficientBalance
 	}
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	s.state[from].Balance -= amount
-	if _, exists := s.state[to]; !exists {
-		s.state[to] = &Account{
-			Address: to,
-		}
+	fromAccount.Balance -= amount
+	s.put(fromAccount)
+
+	toAccount, err := s.get(to)
+	if errors.Is(err, ErrAccountNotFound) {
+		toAccount, err = &Account{Address: to}, nil
+	}
+	if err != nil {
+		return err
 	}
-	s.state[to].Balance += amount
+	toAccount.Balance += amount
+	s.put(toAccount)
 	return nil
 }
 
@@ -111,4 +264,6 @@ var (
 	ErrInsufficientBalance = errors.New("insufficient balance")
 	ErrAccountNotFound     = errors.New("account not found")
 	ErrAlreadyExists       = errors.New("account already exists")
+	ErrNonceTooLow         = errors.New("nonce too low")
+	ErrNonceTooHigh        = errors.New("nonce too high")
 )
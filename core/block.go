@@ -2,7 +2,6 @@ package core
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
 	"encoding/gob"
 	"errors"
@@ -15,10 +14,14 @@ import (
 
 type Header struct {
 	Version   uint32
+	ChainID   uint64 // set by the genesis block, signed with the header
 	DataHash  types.Hash
+	StateRoot types.Hash // state after executing the block
 	PrevHash  types.Hash
 	Timestamp uint64
 	Height    uint64
+	GasLimit  uint64 // gas that the transactions of the block may use
+	GasUsed   uint64
 	Nonce     uint64
 }
 
@@ -33,6 +36,12 @@ func (h *Header) EncodeBinary(w io.Writer) error {
 	if err := binary.Write(w, binary.LittleEndian, &h.Version); err != nil {
 		return err
 	}
+	if err := binary.Write(w, binary.LittleEndian, &h.ChainID); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, &h.StateRoot); err != nil {
+		return err
+	}
 	if err := binary.Write(w, binary.LittleEndian, &h.PrevHash); err != nil {
 		return err
 	}
@@ -42,12 +51,24 @@ func (h *Header) EncodeBinary(w io.Writer) error {
 	if err := binary.Write(w, binary.LittleEndian, &h.Height); err != nil {
 		return err
 	}
+	if err := binary.Write(w, binary.LittleEndian, &h.GasLimit); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, &h.GasUsed); err != nil {
+		return err
+	}
 	return binary.Write(w, binary.LittleEndian, &h.Nonce)
 }
 func (h *Header) DecodeBinary(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &h.Version); err != nil {
 		return err
 	}
+	if err := binary.Read(r, binary.LittleEndian, &h.ChainID); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &h.StateRoot); err != nil {
+		return err
+	}
 	if err := binary.Read(r, binary.LittleEndian, &h.PrevHash); err != nil {
 		return err
 	}
@@ -57,6 +78,12 @@ func (h *Header) DecodeBinary(r io.Reader) error {
 	if err := binary.Read(r, binary.LittleEndian, &h.Height); err != nil {
 		return err
 	}
+	if err := binary.Read(r, binary.LittleEndian, &h.GasLimit); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &h.GasUsed); err != nil {
+		return err
+	}
 	return binary.Read(r, binary.LittleEndian, &h.Nonce)
 }
 
@@ -86,10 +113,12 @@ func NewBlockWithPrevHeader(prevHeader *Header, txs []*Transaction) (*Block, err
 	}
 	header := &Header{
 		Version:   prevHeader.Version,
+		ChainID:   prevHeader.ChainID,
 		DataHash:  dataHash,
 		PrevHash:  NewHeaderHasher().Hash(prevHeader),
 		Timestamp: uint64(time.Now().UnixNano()),
 		Height:    prevHeader.Height + 1,
+		GasLimit:  prevHeader.GasLimit,
 		Nonce:     prevHeader.Nonce,
 	}
 	block := NewBlock(header)
@@ -101,6 +130,14 @@ func (b *Block) AddTransaction(tx *Transaction) {
 	b.Transactions = append(b.Transactions, tx)
 }
 
+// Coinbase returns the transaction paying the block reward, if any
+func (b *Block) Coinbase() *Transaction {
+	if len(b.Transactions) == 0 || b.Transactions[0].From != nil {
+		return nil
+	}
+	return b.Transactions[0]
+}
+
 func (b *Block) Sign(privateKey *crypto.PrivateKey) error {
 	b.Signature = privateKey.Sign(b.Header.Bytes())
 	b.Validator = privateKey.PublicKey()
@@ -108,13 +145,16 @@ func (b *Block) Sign(privateKey *crypto.PrivateKey) error {
 }
 
 func (b *Block) Verify() error {
-	if b.Signature == nil {
+	if b.Signature == nil || b.Validator == nil {
 		return ErrorBlockHasNoSig
 	}
 	if !b.Signature.Verify(b.Validator, b.Header.Bytes()) {
 		return ErrBlockVerifyFailed
 	}
-	for _, tx := range b.Transactions {
+	for i, tx := range b.Transactions {
+		if i == 0 && b.Coinbase() != nil {
+			continue
+		}
 		if err := tx.Verify(); err != nil {
 			return err
 		}
@@ -145,15 +185,22 @@ func (b *Block) Decode(dec Decoder[*Block]) error {
 	return dec.Decode(b)
 }
 
+// CalculateDataHash returns the merkle root over the hashes of txs
 func CalculateDataHash(txs []*Transaction) (hash types.Hash, err error) {
-	var buf bytes.Buffer
-	for _, tx := range txs {
-		if err = tx.Encode(NewTxEncoder(&buf)); err != nil {
-			return
-		}
+	return MerkleRoot(transactionHashes(txs)), nil
+}
+
+// TransactionProof proves that the transaction at index is covered by DataHash
+func (b *Block) TransactionProof(index int) (*MerkleProof, error) {
+	return BuildMerkleProof(transactionHashes(b.Transactions), index)
+}
+
+func transactionHashes(txs []*Transaction) []types.Hash {
+	hashes := make([]types.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.GetHash(NewTransactionHasher())
 	}
-	hash = sha256.Sum256(buf.Bytes())
-	return
+	return hashes
 }
 
 var (
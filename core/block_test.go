@@ -17,6 +17,7 @@ func randomBlock(height uint64, prevHash types.Hash) *Block {
 		PrevHash:  prevHash,
 		Height:    height,
 		Timestamp: uint64(time.Now().UnixNano()),
+		GasLimit:  defaultBlockGasLimit,
 	}
 	return NewBlock(header)
 }
@@ -59,9 +60,13 @@ func TestBlockSignAndVerify(t *testing.T) {
 func TestBlockHeaderEncodeAndDecode(t *testing.T) {
 	h := &Header{
 		Version:   1,
+		ChainID:   7,
+		StateRoot: types.RandomHash(),
 		PrevHash:  types.RandomHash(),
 		Timestamp: uint64(time.Now().UnixMilli()),
 		Height:    1,
+		GasLimit:  1000,
+		GasUsed:   300,
 		Nonce:     15,
 	}
 	buf := &bytes.Buffer{}
@@ -72,6 +77,10 @@ func TestBlockHeaderEncodeAndDecode(t *testing.T) {
 	err = hDecode.DecodeBinary(buf)
 	require.NoError(t, err)
 	assert.Equal(t, h.Version, hDecode.Version)
+	assert.Equal(t, h.ChainID, hDecode.ChainID)
+	assert.Equal(t, h.GasLimit, hDecode.GasLimit)
+	assert.Equal(t, h.GasUsed, hDecode.GasUsed)
+	assert.Equal(t, h.StateRoot, hDecode.StateRoot)
 	assert.Equal(t, h.PrevHash, hDecode.PrevHash)
 	assert.Equal(t, h.Timestamp, hDecode.Timestamp)
 	assert.Equal(t, h.Height, hDecode.Height)
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/matrix-go/block/types"
+)
+
+// ForkChoice decides which branch of the block tree is the canonical chain,
+// the branch with the biggest cumulative weight wins.
+type ForkChoice interface {
+	// Weight is what block adds to the weight of its branch
+	Weight(block *Block) uint64
+}
+
+// LongestChain prefers the branch with the most blocks
+type LongestChain struct{}
+
+func (LongestChain) Weight(*Block) uint64 {
+	return 1
+}
+
+// HeaviestChain prefers the branch that used the most gas, every block
+// weighing one more so that empty blocks still count
+type HeaviestChain struct{}
+
+func (HeaviestChain) Weight(block *Block) uint64 {
+	return saturatingAdd(block.GasUsed, 1)
+}
+
+// saturatingAdd adds weights, stopping at the biggest weight instead of
+// wrapping around
+func saturatingAdd(a, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+var (
+	_ ForkChoice = LongestChain{}
+	_ ForkChoice = HeaviestChain{}
+)
+
+// blockNode is a block of the block tree
+type blockNode struct {
+	block  *Block
+	hash   types.Hash
+	parent *blockNode
+	// weight of the branch ending with this block
+	weight uint64
+	// undo reverts the state changes of the block, it is only kept
+	// for the recent blocks of the canonical chain
+	undo []func()
+	// receipts of the transactions, set once the block has been executed
+	receipts []*Receipt
+	// state after the block, only kept for the recent blocks of the
+	// canonical chain and for checkpoints. It shares the unchanged parts
+	// of the chain state, copying it is cheap.
+	state *WorldState
+	// invalid is set once executing the block has failed
+	invalid bool
+}
+
+func (n *blockNode) height() uint64 {
+	return n.block.Height
+}
+
+// betterThan reports whether the branch ending at n should replace the one
+// ending at other, the current branch is kept on equal weights
+func (n *blockNode) betterThan(other *blockNode) bool {
+	return n.weight > other.weight
+}
+
+// forkPoint returns the last block that a and b have in common
+func forkPoint(a, b *blockNode) *blockNode {
+	for a != nil && b != nil && a != b {
+		if a.height() >= b.height() {
+			a = a.parent
+		} else {
+			b = b.parent
+		}
+	}
+	if a != b {
+		return nil
+	}
+	return a
+}
+
+// branch returns the blocks after ancestor up to n, oldest first
+func branch(ancestor, n *blockNode) []*blockNode {
+	nodes := make([]*blockNode, 0)
+	for ; n != ancestor; n = n.parent {
+		nodes = append(nodes, n)
+	}
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	return nodes
+}
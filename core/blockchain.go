@@ -1,10 +1,14 @@
 package core
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/matrix-go/block/crypto"
 	"github.com/matrix-go/block/types"
+	"slices"
+	"sort"
 	"sync"
 )
 
@@ -14,63 +18,148 @@ type Blockchain struct {
 	blocks           []*Block
 	blockStore       map[types.Hash][]*Block
 	transactionStore map[types.Hash][]*Transaction
-	collectionStore  map[types.Hash]*CollectionTx
-	mintStore        map[types.Hash]*MintTx
+	txLookup         map[types.Hash]txLocation
 	storage          Storage
 	validator        Validator
+	state            *WorldState
+	// chainID is taken from the genesis block
+	chainID    uint64
+	validators []*crypto.PublicKey
+	issuance   Issuance
+	// minGasPrice is the lowest gas price of a signed transaction
+	minGasPrice uint64
+	// nodes is the block tree, every known block and not only the canonical ones
+	nodes         map[types.Hash]*blockNode
+	tip           *blockNode
+	forkChoice    ForkChoice
+	maxReorgDepth uint64
+	reorgHandler  ReorgHandler
 
-	// TODO: make this an interface
-	contractState *State
-	accountState  *AccountState
-
-	lock     sync.RWMutex
-	txLock   sync.RWMutex
-	colLock  sync.RWMutex
-	mintLock sync.RWMutex
+	lock   sync.RWMutex
+	txLock sync.RWMutex
+	// stateLock serializes the execution of blocks against state
+	stateLock sync.Mutex
+	// reorgLock keeps the calls of reorgHandler in the order of the changes
+	reorgLock sync.Mutex
 }
 
-func NewBlockchain(genesis *Block, logger log.Logger) (bc *Blockchain, err error) {
-	// TODO: read state from disk db
-	accountState := NewAccountState()
-	contractState := NewState()
+// ReorgHandler is told which blocks left and which joined the canonical
+// chain, oldest first, whenever it changes. A block extending the chain
+// comes without disconnected blocks. It is called once the chain is
+// unlocked, one change at a time, and must not add blocks to the chain.
+type ReorgHandler func(disconnected, connected []*Block)
+
+const defaultMaxReorgDepth = 64
 
-	coinbase := crypto.PublicKey{}
-	if err := accountState.CreateAccount(coinbase.Address()); err != nil {
+// stateCheckpointInterval is how often a block too deep to be reorganised
+// keeps its state, tracing replays the blocks after the closest one
+const stateCheckpointInterval = 128
+
+type BlockchainOpt struct {
+	Logger log.Logger
+	// Storage defaults to an in-memory storage
+	Storage Storage
+	Genesis *Block
+	// ForkChoice defaults to LongestChain
+	ForkChoice ForkChoice
+	// MaxReorgDepth is how many blocks a reorganisation may unwind,
+	// defaults to 64
+	MaxReorgDepth uint64
+	// Validators are the keys allowed to sign blocks, anyone may when empty
+	Validators []*crypto.PublicKey
+	// Issuance is the reward validators may pay themselves, none when empty
+	Issuance Issuance
+	// MinGasPrice is the lowest gas price signed transactions may pay
+	MinGasPrice uint64
+}
+
+func NewBlockchain(opt BlockchainOpt) (bc *Blockchain, err error) {
+	if opt.Logger == nil {
+		opt.Logger = log.NewNopLogger()
+	}
+	if opt.Storage == nil {
+		opt.Storage = NewMemStorage()
+	}
+	if opt.ForkChoice == nil {
+		opt.ForkChoice = LongestChain{}
+	}
+	if err = opt.Issuance.Validate(); err != nil {
 		return nil, err
 	}
+	if opt.MaxReorgDepth == 0 {
+		opt.MaxReorgDepth = defaultMaxReorgDepth
+	}
+	state := NewWorldState()
 
 	bc = &Blockchain{
-		logger:           logger,
+		logger:           opt.Logger,
 		headers:          make([]*Header, 0),
-		storage:          NewMemStorage(),
+		storage:          opt.Storage,
 		validator:        NewBlockValidator(),
 		blockStore:       make(map[types.Hash][]*Block),
 		transactionStore: make(map[types.Hash][]*Transaction),
-		collectionStore:  make(map[types.Hash]*CollectionTx),
-		mintStore:        make(map[types.Hash]*MintTx),
-		contractState:    contractState,
-		accountState:     accountState,
-	}
-
-	if genesis != nil {
-		err = bc.addBlock(genesis)
-		if err == nil && genesis.Validator != nil {
-			coinbaseAccount, err := bc.accountState.GetAccount(coinbase.Address())
-			if err != nil {
-				return nil, err
-			}
-			if err = bc.accountState.Transfer(coinbaseAccount.Address, genesis.Validator.Address(), coinbaseAccount.Balance); err != nil {
-				return nil, err
-			}
+		txLookup:         make(map[types.Hash]txLocation),
+		state:            state,
+		nodes:            make(map[types.Hash]*blockNode),
+		forkChoice:       opt.ForkChoice,
+		maxReorgDepth:    opt.MaxReorgDepth,
+		validators:       opt.Validators,
+		issuance:         opt.Issuance,
+		minGasPrice:      opt.MinGasPrice,
+	}
+
+	reloaded, err := bc.reload(opt.Genesis)
+	if err != nil {
+		return nil, err
+	}
+	if reloaded {
+		return bc, nil
+	}
+
+	if opt.Genesis != nil {
+		if err = bc.addGenesis(opt.Genesis); err != nil {
+			return nil, err
+		}
+	}
+	return bc, nil
+}
+
+// reload rebuilds the block tree from the blocks already kept by storage,
+// it reports false when the storage is empty. Only blocks are stored: the
+// blocks are executed again, which rebuilds the state and the receipts.
+func (bc *Blockchain) reload(genesis *Block) (reloaded bool, err error) {
+	err = bc.storage.Iterate(func(b *Block) error {
+		if reloaded {
+			return bc.addBlock(b, true)
 		}
+		if genesis != nil && NewHeaderHasher().Hash(genesis.Header) != NewHeaderHasher().Hash(b.Header) {
+			return ErrGenesisMismatch
+		}
+		reloaded = true
+		return bc.addGenesis(b)
+	})
+	if reloaded && err == nil {
+		bc.logger.Log("msg", "reloaded blockchain from storage", "height", bc.Height())
 	}
-	return bc, err
+	return reloaded, err
+}
+
+// addGenesis makes genesis the root of the block tree
+func (bc *Blockchain) addGenesis(genesis *Block) error {
+	bc.chainID = genesis.ChainID
+	return bc.addBlock(genesis, false)
 }
 
 func (bc *Blockchain) SetValidator(validator Validator) {
 	bc.validator = validator
 }
 
+func (bc *Blockchain) SetReorgHandler(handler ReorgHandler) {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+	bc.reorgHandler = handler
+}
+
 func (bc *Blockchain) AddBlock(block *Block) error {
 
 	// validate
@@ -79,101 +168,509 @@ func (bc *Blockchain) AddBlock(block *Block) error {
 	}
 
 	// add block
-	if err := bc.addBlock(block); err != nil {
+	if err := bc.addBlock(block, true); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (bc *Blockchain) handleNativeTransaction(tx *Transaction) error {
-	fmt.Printf("======> %s is going to send %d coin to %s\n", tx.From, tx.Value, tx.To)
-	if tx.From.String() == "0x996fb92427ae41e4649b934ca495991b7852b855" {
-		return bc.accountState.AddBalance(tx.To.Address(), tx.Value)
+func (bc *Blockchain) handleNativeTransaction(state *WorldState, tx *Transaction) error {
+	// unsigned transactions are the genesis allocations and the coinbase
+	// transactions, they create money
+	if tx.From == nil {
+		return state.accounts.AddBalance(tx.To.Address(), tx.Value)
 	}
-	return bc.accountState.Transfer(tx.From.Address(), tx.To.Address(), tx.Value)
+	// the value of an invocation goes to the contract
+	var to types.Address
+	switch innerTx := tx.InnerTx.(type) {
+	case *InvokeTx:
+		to = innerTx.Contract
+	default:
+		if tx.To == nil {
+			return ErrTransactionNoRecipient
+		}
+		to = tx.To.Address()
+	}
+	return state.accounts.Transfer(tx.From.Address(), to, tx.Value)
+}
+
+// chargeFee takes the fee and the gas limit at the gas price of tx from the
+// sender, the fee goes to the validator
+func (bc *Blockchain) chargeFee(state *WorldState, tx *Transaction, validator *crypto.PublicKey) error {
+	gasCost, ok := tx.GasCost()
+	if !ok || tx.Fee+gasCost < gasCost {
+		return fmt.Errorf("%s cost overflows: %w", tx.From.Address(), ErrInsufficientBalance)
+	}
+	if tx.Fee+gasCost == 0 {
+		return nil
+	}
+	if validator == nil {
+		return ErrBlockHasNoValidator
+	}
+	if err := state.accounts.SubBalance(tx.From.Address(), tx.Fee+gasCost); err != nil {
+		return err
+	}
+	return state.accounts.AddBalance(validator.Address(), tx.Fee)
+}
+
+// payGas pays the validator for the gasUsed by tx and gives the sender back
+// the gas left
+func (bc *Blockchain) payGas(state *WorldState, tx *Transaction, gasUsed uint64, validator *crypto.PublicKey) error {
+	if tx.GasPrice == 0 {
+		return nil
+	}
+	if err := state.accounts.AddBalance(validator.Address(), gasUsed*tx.GasPrice); err != nil {
+		return err
+	}
+	return state.accounts.AddBalance(tx.From.Address(), (tx.GasLimit-gasUsed)*tx.GasPrice)
 }
 
-func (bc *Blockchain) handleNativeNFT(tx *Transaction) error {
+func (bc *Blockchain) handleNativeNFT(state *WorldState, tx *Transaction) error {
 	switch innerTx := tx.InnerTx.(type) {
 	case *CollectionTx:
-		fmt.Printf("tx.InnerTx ======> %+v\n", *innerTx)
 		hash := tx.GetHash(NewTransactionHasher())
-		bc.colLock.RLock()
-		_, exists := bc.collectionStore[hash]
-		bc.colLock.RUnlock()
-		if exists {
+		if state.collections.Has(hash) {
 			return fmt.Errorf("collection already exists")
 		}
-		bc.colLock.Lock()
-		bc.collectionStore[hash] = innerTx
-		bc.colLock.Unlock()
+		return state.collections.Put(hash, innerTx)
 	case *MintTx:
-		bc.colLock.RLock()
-		collection, exists := bc.collectionStore[innerTx.Collection]
-		bc.colLock.RUnlock()
-		if !exists {
+		if !state.collections.Has(innerTx.Collection) {
 			return fmt.Errorf("collection does not exist")
 		}
-		_ = collection
 		hash := tx.GetHash(NewTransactionHasher())
-		bc.mintLock.Lock()
-		bc.mintStore[hash] = innerTx
-		bc.mintLock.Unlock()
-		fmt.Printf("tx.InnerTx mint collection ======> %+v\n", *innerTx)
+		return state.mints.Put(hash, innerTx)
+	case *DeployTx, *InvokeTx:
+		// run by runContract
 	default:
 		return fmt.Errorf("invalid transaction type: %v", innerTx)
 	}
 	return nil
 }
 
-// addBlock
-// addBlock without validation
-func (bc *Blockchain) addBlock(block *Block) error {
+// ProposeBlock builds and signs the next block on top of the chain from txs,
+// leaving out the transactions that fail to execute. The chain state is
+// left untouched.
+func (bc *Blockchain) ProposeBlock(privateKey *crypto.PrivateKey, txs []*Transaction) (*Block, error) {
+	header, err := bc.GetHeader(bc.Height())
+	if err != nil {
+		return nil, err
+	}
+	block, err := NewBlockWithPrevHeader(header, nil)
+	if err != nil {
+		return nil, err
+	}
+	block.Validator = privateKey.PublicKey()
 
-	// run transaction code
-	for _, tx := range block.Transactions {
-		// handle contract with vm
-		if len(tx.Data) > 0 {
-			bc.logger.Log("msg", "executing code", "len", len(tx.Data), "Hash", tx.GetHash(NewTransactionHasher()))
-			vm := NewVM(tx.Data, bc.contractState)
-			if err := vm.Run(); err != nil {
-				return err
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+	snapshot := bc.state.Snapshot()
+	defer bc.state.RevertToSnapshot(snapshot)
+
+	if reward := bc.BlockReward(block.Height); reward > 0 {
+		coinbase := NewCoinbaseTransaction(block.Validator, block.Height, reward, bc.chainID)
+		if _, err = bc.applyTransaction(bc.state, coinbase, block, nil); err != nil {
+			return nil, err
+		}
+		block.AddTransaction(coinbase)
+	}
+
+	// the transactions of a sender only apply in nonce order
+	txs = slices.Clone(txs)
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+	for _, tx := range txs {
+		if tx.From == nil {
+			bc.logger.Log("msg", "leave out unsigned transaction", "hash", tx.GetHash(NewTransactionHasher()))
+			continue
+		}
+		if tx.GasLimit > block.GasLimit-block.GasUsed {
+			bc.logger.Log("msg", "leave out transaction", "hash", tx.GetHash(NewTransactionHasher()), "err", ErrBlockGasLimitReached)
+			continue
+		}
+		txSnapshot := bc.state.Snapshot()
+		receipt, err := bc.applyTransaction(bc.state, tx, block, nil)
+		if err != nil {
+			bc.state.RevertToSnapshot(txSnapshot)
+			bc.logger.Log("msg", "leave out transaction", "hash", tx.GetHash(NewTransactionHasher()), "err", err)
+			continue
+		}
+		block.GasUsed += receipt.GasUsed
+		block.AddTransaction(tx)
+	}
+	if block.DataHash, err = CalculateDataHash(block.Transactions); err != nil {
+		return nil, err
+	}
+	block.StateRoot = bc.state.Root()
+	if err = block.Sign(privateKey); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// addBlock inserts block into the block tree and executes it when it
+// extends the canonical chain, or reorganises the chain when its branch
+// becomes the better one. Execution is checked against the block unless
+// validate is unset, either every state change of the block is kept or
+// none of them is. The reorg handler is told about the change of the
+// canonical chain once the state lock is released.
+func (bc *Blockchain) addBlock(block *Block, validate bool) error {
+	bc.stateLock.Lock()
+	disconnected, connected, err := bc.insertBlock(block, validate)
+	handler := bc.reorgHandler
+	if handler == nil || len(disconnected)+len(connected) == 0 {
+		bc.stateLock.Unlock()
+		return err
+	}
+	bc.reorgLock.Lock()
+	bc.stateLock.Unlock()
+	defer bc.reorgLock.Unlock()
+	handler(nodeBlocks(disconnected), nodeBlocks(connected))
+	return err
+}
+
+// insertBlock adds block to the block tree and returns the nodes that left
+// and joined the canonical chain, it must be called with the state lock held
+func (bc *Blockchain) insertBlock(block *Block, validate bool) (disconnected, connected []*blockNode, err error) {
+	node := &blockNode{
+		block:  block,
+		hash:   NewHeaderHasher().Hash(block.Header),
+		weight: bc.forkChoice.Weight(block),
+	}
+	bc.lock.RLock()
+	if parent, ok := bc.nodes[block.PrevHash]; ok {
+		node.parent = parent
+		node.weight = saturatingAdd(node.weight, parent.weight)
+	}
+	tip := bc.tip
+	bc.lock.RUnlock()
+
+	if node.parent == tip {
+		if err := bc.connectBlock(node, validate); err != nil {
+			return nil, nil, err
+		}
+		bc.insertNode(node)
+		return nil, []*blockNode{node}, nil
+	}
+
+	bc.insertNode(node)
+	if !node.betterThan(tip) {
+		bc.logger.Log("msg", "add side block", "height", block.Height, "Hash", node.hash, "tip", tip.hash)
+		return nil, nil, nil
+	}
+	return bc.reorg(node)
+}
+
+// insertNode adds node to the block tree
+func (bc *Blockchain) insertNode(node *blockNode) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.nodes[node.hash] = node
+	bc.blockStore[node.hash] = append(bc.blockStore[node.hash], node.block)
+}
+
+// connectBlock executes node on top of the canonical chain and makes it the
+// new tip, the state is left untouched on error.
+func (bc *Blockchain) connectBlock(node *blockNode, validate bool) error {
+	snapshot := bc.state.Snapshot()
+	receipts, err := bc.applyBlock(node.block)
+	if err != nil {
+		bc.state.RevertToSnapshot(snapshot)
+		return err
+	}
+	if validate {
+		if err := bc.validator.ValidateExecution(bc, node.block); err != nil {
+			bc.state.RevertToSnapshot(snapshot)
+			return err
+		}
+	}
+	// blocks of a side branch are only stored once they become canonical,
+	// storing a block again makes it the stored block at its height
+	if err := bc.storage.Put(node.block); err != nil {
+		bc.state.RevertToSnapshot(snapshot)
+		return err
+	}
+	node.undo = bc.state.Commit()
+	node.receipts = receipts
+	bc.indexBlock(node)
+	return nil
+}
+
+// disconnectBlock reverts the state changes of the tip node and makes its
+// parent the new tip
+func (bc *Blockchain) disconnectBlock(node *blockNode) {
+	undo(node.undo)
+	node.undo = nil
+	bc.unindexBlock(node)
+}
+
+// reorg switches the canonical chain over to the branch ending at newTip
+// and returns the nodes that left and joined it. When a block of the new
+// branch fails to execute the branch is marked invalid and the previous
+// chain is restored, as far as its blocks can be connected again.
+func (bc *Blockchain) reorg(newTip *blockNode) (disconnected, connected []*blockNode, err error) {
+	oldTip := bc.tip
+	fork := forkPoint(oldTip, newTip)
+	if fork == nil {
+		return nil, nil, ErrBlockUnknownParent
+	}
+	if oldTip.height()-fork.height() > bc.maxReorgDepth {
+		return nil, nil, fmt.Errorf("reorg from %s to %s: %w", oldTip.hash, newTip.hash, ErrReorgTooDeep)
+	}
+	disconnected = branch(fork, oldTip)
+	connected = branch(fork, newTip)
+	for _, node := range connected {
+		if node.invalid {
+			newTip.invalid = true
+			return nil, nil, fmt.Errorf("block %s: %w", node.hash, ErrBlockInvalidParent)
+		}
+	}
+
+	bc.logger.Log("msg", "reorganise chain", "fork", fork.hash, "height", fork.height(), "from", oldTip.hash, "to", newTip.hash)
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		bc.disconnectBlock(disconnected[i])
+	}
+	for i, node := range connected {
+		if err := bc.connectBlock(node, true); err != nil {
+			node.invalid = true
+			for j := i - 1; j >= 0; j-- {
+				bc.disconnectBlock(connected[j])
+			}
+			for k, old := range disconnected {
+				if restoreErr := bc.connectBlock(old, false); restoreErr != nil {
+					// the chain ends before the blocks that could not be restored
+					return disconnected[k:], nil, errors.Join(err, fmt.Errorf("restore block %s: %w", old.hash, restoreErr))
+				}
 			}
-			fmt.Printf("vm state ======> %+v\n", vm.contractState)
-			res := vm.stack.Shift()
-			fmt.Printf("vm result ======> %+v\n", res)
+			return nil, nil, err
+		}
+	}
+	return disconnected, connected, nil
+}
+
+func nodeBlocks(nodes []*blockNode) []*Block {
+	blocks := make([]*Block, len(nodes))
+	for i, node := range nodes {
+		blocks[i] = node.block
+	}
+	return blocks
+}
+
+// applyBlock runs the transactions of block against the chain state, the
+// gas limits of the transactions must fit in the gas limit of the block
+func (bc *Blockchain) applyBlock(block *Block) ([]*Receipt, error) {
+	var gasUsed uint64
+	receipts := make([]*Receipt, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		if tx.GasLimit > block.GasLimit-gasUsed {
+			return nil, fmt.Errorf("block %s, %w", block.GetHash(NewHeaderHasher()), ErrBlockGasLimitReached)
+		}
+		receipt, err := bc.applyTransaction(bc.state, tx, block, nil)
+		if err != nil {
+			return nil, err
+		}
+		receipt.BlockHash = block.GetHash(NewHeaderHasher())
+		receipt.Height = block.Height
+		gasUsed += receipt.GasUsed
+		receipts = append(receipts, receipt)
+	}
+	if gasUsed != block.GasUsed {
+		return nil, fmt.Errorf("block %s gas used %d, got %d: %w", block.GetHash(NewHeaderHasher()), block.GasUsed, gasUsed, ErrBlockGasUsedInvalid)
+	}
+	return receipts, nil
+}
+
+// applyTransaction runs tx of block against state, its fee goes
+// to the validator of the block. It returns an error when tx cannot be part of
+// the block, a failing contract only makes the receipt failed: its changes
+// are reverted but the sender still pays the fee and uses the nonce. A
+// non-nil tracer follows the contract run by tx.
+func (bc *Blockchain) applyTransaction(state *WorldState, tx *Transaction, block *Block, tracer Tracer) (*Receipt, error) {
+	if tx.ChainID != bc.chainID {
+		return nil, fmt.Errorf("transaction chain id %d, expected %d: %w", tx.ChainID, bc.chainID, ErrChainIDMismatch)
+	}
+	if tx.From != nil {
+		if tx.GasPrice < bc.minGasPrice {
+			return nil, fmt.Errorf("transaction gas price %d, minimum %d: %w", tx.GasPrice, bc.minGasPrice, ErrGasPriceTooLow)
+		}
+		if err := state.accounts.UseNonce(tx.From.Address(), tx.Nonce); err != nil {
+			return nil, err
+		}
+		if err := bc.chargeFee(state, tx, block.Validator); err != nil {
+			return nil, err
+		}
+	}
+	receipt, err := bc.executeTransaction(state, tx, block, tracer)
+	if err != nil {
+		return nil, err
+	}
+	if tx.From != nil {
+		if err := bc.payGas(state, tx, receipt.GasUsed, block.Validator); err != nil {
+			return nil, err
 		}
+	}
+	return receipt, nil
+}
 
+// executeTransaction sends the value of tx and runs its contract against
+// state, the sender is neither checked nor charged
+func (bc *Blockchain) executeTransaction(state *WorldState, tx *Transaction, block *Block, tracer Tracer) (*Receipt, error) {
+	receipt := &Receipt{
+		TxHash: tx.GetHash(NewTransactionHasher()),
+		Status: ReceiptStatusSuccess,
+		Logs:   make([]*Log, 0),
+	}
+	snapshot := state.Snapshot()
+	// the value is sent first so that an invoked contract can spend it, a
+	// failed contract gives it back
+	if tx.Value > 0 {
+		if err := bc.handleNativeTransaction(state, tx); err != nil {
+			return nil, err
+		}
+	}
+	if err := bc.runContract(state, tx, block, receipt, tracer); err != nil {
+		state.RevertToSnapshot(snapshot)
+		bc.logger.Log("msg", "transaction failed", "Hash", receipt.TxHash, "err", err)
+		receipt.Status = ReceiptStatusFailed
+		receipt.Return = nil
+		receipt.Logs = receipt.Logs[:0]
+		receipt.Err = err.Error()
+	} else if tx.InnerTx != nil {
 		// handle inner transaction
-		if tx.InnerTx != nil {
-			if err := bc.handleNativeNFT(tx); err != nil {
-				return err
-			}
+		if err := bc.handleNativeNFT(state, tx); err != nil {
+			return nil, err
 		}
-		// handle native transaction
-		if tx.Value > 0 {
-			if err := bc.handleNativeTransaction(tx); err != nil {
-				return err
-			}
-			fmt.Printf("====== ACCOUNT STATE ====== \n")
-			fmt.Printf("%+v \n", bc.accountState.state)
-			fmt.Printf("====== ACCOUNT STATE ====== \n")
+	}
+	return receipt, nil
+}
+
+// runContract deploys or runs the contract of tx, if any. Code sent in the
+// Data of tx runs against the storage of the sender.
+func (bc *Blockchain) runContract(state *WorldState, tx *Transaction, block *Block, receipt *Receipt, tracer Tracer) error {
+	var (
+		code     []byte
+		contract types.Address
+		input    []byte
+	)
+	switch innerTx := tx.InnerTx.(type) {
+	case *DeployTx:
+		return bc.deployContract(state, tx, innerTx, receipt)
+	case *InvokeTx:
+		var err error
+		if code, err = state.code.Get(innerTx.Contract.Bytes()); err != nil {
+			return fmt.Errorf("%w: %s", ErrContractNotFound, innerTx.Contract)
 		}
+		contract = innerTx.Contract
+		input = tx.Data
+	default:
+		if len(tx.Data) == 0 || tx.From == nil {
+			return nil
+		}
+		code = tx.Data
+		contract = tx.From.Address()
+	}
+
+	// handle contract with vm
+	bc.logger.Log("msg", "executing code", "len", len(code), "Hash", tx.GetHash(NewTransactionHasher()))
+	ctx := &VMContext{
+		Caller:    tx.From.Address(),
+		Contract:  contract,
+		Value:     tx.Value,
+		Height:    block.Height,
+		Timestamp: block.Timestamp,
+		State:     state,
+		Input:     input,
+	}
+	vm := NewVM(code, state.contractStorage(contract), tx.GasLimit, ctx)
+	if tracer != nil {
+		vm.SetTracer(tracer)
+	}
+	err := vm.Run()
+	receipt.GasUsed = tx.GasLimit - vm.GasLeft()
+	if err != nil {
+		return err
 	}
+	receipt.Return = valueBytes(vm.ReturnValue())
+	receipt.Logs = append(receipt.Logs, vm.Logs()...)
+	return nil
+}
 
-	hash := NewHeaderHasher().Hash(block.Header)
+// deployContract stores the code of deploy, paying GasCodeByte for every byte
+func (bc *Blockchain) deployContract(state *WorldState, tx *Transaction, deploy *DeployTx, receipt *Receipt) error {
+	if tx.From == nil {
+		return ErrTransactionNotSigned
+	}
+	gas := uint64(len(deploy.Code)) * GasCodeByte
+	if gas > tx.GasLimit {
+		receipt.GasUsed = tx.GasLimit
+		return ErrOutOfGas
+	}
+	receipt.GasUsed = gas
+	addr := ContractAddress(tx.From.Address(), tx.Nonce)
+	if _, err := state.code.Get(addr.Bytes()); err == nil {
+		return fmt.Errorf("%w: %s", ErrContractExists, addr)
+	}
+	if err := state.code.Put(addr.Bytes(), deploy.Code); err != nil {
+		return err
+	}
+	receipt.Contract = &addr
+	return nil
+}
+
+// indexBlock appends the block of node to the canonical chain, with the
+// state it ends in
+func (bc *Blockchain) indexBlock(node *blockNode) {
+	block := node.block
 	bc.lock.Lock()
 	bc.headers = append(bc.headers, block.Header)
 	bc.blocks = append(bc.blocks, block)
-	bc.blockStore[hash] = append(bc.blockStore[hash], block)
+	bc.tip = node
+	node.state = bc.state.Copy()
+	// forget how to undo the blocks too deep to be reorganised, and their
+	// state but at checkpoints
+	for n := node; n != nil; n = n.parent {
+		if node.height()-n.height() >= bc.maxReorgDepth {
+			n.undo = nil
+			if n.height()%stateCheckpointInterval != 0 {
+				n.state = nil
+			}
+			break
+		}
+	}
 	bc.lock.Unlock()
 	bc.txLock.Lock()
 	defer bc.txLock.Unlock()
-	for _, tx := range block.Transactions {
-		bc.transactionStore[tx.Hash] = append(bc.transactionStore[tx.Hash], tx)
+	for i, tx := range block.Transactions {
+		txHash := tx.GetHash(NewTransactionHasher())
+		bc.transactionStore[txHash] = append(bc.transactionStore[txHash], tx)
+		bc.txLookup[txHash] = txLocation{BlockHash: node.hash, Height: block.Height, Index: i, Receipt: node.receipts[i]}
 	}
-	bc.logger.Log("msg", "add new block", "height", block.Height, "Hash", block.GetHash(NewHeaderHasher()), "txLen", len(block.Transactions))
-	return bc.storage.Put(block)
+	bc.logger.Log("msg", "add new block", "height", block.Height, "Hash", node.hash, "txLen", len(block.Transactions))
+}
+
+// unindexBlock removes the tip node from the canonical chain
+func (bc *Blockchain) unindexBlock(node *blockNode) {
+	block := node.block
+	bc.lock.Lock()
+	bc.headers = bc.headers[:len(bc.headers)-1]
+	bc.blocks = bc.blocks[:len(bc.blocks)-1]
+	bc.tip = node.parent
+	node.state = nil
+	bc.lock.Unlock()
+	bc.txLock.Lock()
+	defer bc.txLock.Unlock()
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		txHash := block.Transactions[i].GetHash(NewTransactionHasher())
+		if txs := bc.transactionStore[txHash]; len(txs) > 1 {
+			bc.transactionStore[txHash] = txs[:len(txs)-1]
+		} else {
+			delete(bc.transactionStore, txHash)
+		}
+		if location, ok := bc.txLookup[txHash]; ok && location.BlockHash == node.hash {
+			delete(bc.txLookup, txHash)
+		}
+	}
+	bc.logger.Log("msg", "remove block", "height", block.Height, "Hash", node.hash)
 }
 
 func (bc *Blockchain) Height() uint64 {
@@ -205,6 +702,23 @@ func (bc *Blockchain) GetBlock(height uint64) (*Block, error) {
 	return bc.blocks[height], nil
 }
 
+// HasBlockHash reports whether the block is part of the block tree,
+// canonical or not
+func (bc *Blockchain) HasBlockHash(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+	_, ok := bc.nodes[hash]
+	return ok
+}
+
+// getNode must not be called with the lock held
+func (bc *Blockchain) getNode(hash types.Hash) (*blockNode, bool) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+	node, ok := bc.nodes[hash]
+	return node, ok
+}
+
 func (bc *Blockchain) GetBlockByHash(hash types.Hash) ([]*Block, error) {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
@@ -214,6 +728,40 @@ func (bc *Blockchain) GetBlockByHash(hash types.Hash) ([]*Block, error) {
 	return nil, fmt.Errorf("block not found")
 }
 
+// ChainID is the chain that transactions and blocks must be signed for
+func (bc *Blockchain) ChainID() uint64 {
+	return bc.chainID
+}
+
+// IsValidator reports whether key may sign blocks
+func (bc *Blockchain) IsValidator(key *crypto.PublicKey) bool {
+	if len(bc.validators) == 0 {
+		return true
+	}
+	for _, validator := range bc.validators {
+		if key != nil && bytes.Equal(validator.Key, key.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// BlockReward is the most the validator of the block at height may pay itself
+func (bc *Blockchain) BlockReward(height uint64) uint64 {
+	return bc.issuance.BlockReward(height)
+}
+
+// MinGasPrice is the lowest gas price a transaction may pay
+func (bc *Blockchain) MinGasPrice() uint64 {
+	return bc.minGasPrice
+}
+
+// GasLimit is the gas limit of the next block
+func (bc *Blockchain) GasLimit() uint64 {
+	header, _ := bc.GetHeader(bc.Height())
+	return header.GasLimit
+}
+
 func (bc *Blockchain) Version() uint32 {
 	header, _ := bc.GetHeader(bc.Height())
 	return header.Version
@@ -228,6 +776,118 @@ func (bc *Blockchain) GetTransactionByHash(hash types.Hash) ([]*Transaction, err
 	return nil, fmt.Errorf("transaction not found")
 }
 
+// StateRoot returns the root of the current chain state
+func (bc *Blockchain) StateRoot() types.Hash {
+	return bc.state.Root()
+}
+
+// txLocation is where a transaction was included in the chain
+type txLocation struct {
+	BlockHash types.Hash
+	Height    uint64
+	Index     int
+	Receipt   *Receipt
+}
+
+// TransactionProof lets a client holding only headers check that a
+// transaction is part of the block at Height.
+type TransactionProof struct {
+	TxHash    types.Hash
+	BlockHash types.Hash
+	Height    uint64
+	DataHash  types.Hash
+	Proof     *MerkleProof
+}
+
+func (p *TransactionProof) Verify() bool {
+	return VerifyMerkleProof(p.DataHash, p.TxHash, p.Proof)
+}
+
+func (bc *Blockchain) GetTransactionProof(hash types.Hash) (*TransactionProof, error) {
+	bc.txLock.RLock()
+	location, ok := bc.txLookup[hash]
+	bc.txLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("transaction not found")
+	}
+	block, err := bc.GetBlock(location.Height)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := block.TransactionProof(location.Index)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionProof{
+		TxHash:    hash,
+		BlockHash: location.BlockHash,
+		Height:    location.Height,
+		DataHash:  block.DataHash,
+		Proof:     proof,
+	}, nil
+}
+
+// GetReceipt returns the receipt of a transaction of the canonical chain.
+// Receipts are kept in memory, they are rebuilt by executing the stored
+// blocks again when the chain is reloaded.
+func (bc *Blockchain) GetReceipt(hash types.Hash) (*Receipt, error) {
+	bc.txLock.RLock()
+	defer bc.txLock.RUnlock()
+	location, ok := bc.txLookup[hash]
+	if !ok {
+		return nil, fmt.Errorf("transaction not found")
+	}
+	return location.Receipt, nil
+}
+
+// GetContractCode returns the code of the contract deployed at addr
+func (bc *Blockchain) GetContractCode(addr types.Address) ([]byte, error) {
+	code, err := bc.state.code.Get(addr.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrContractNotFound, addr)
+	}
+	return code, nil
+}
+
+// GetBlockReceipts returns the receipts of the transactions of a block of
+// the canonical chain, in the order of the transactions
+func (bc *Blockchain) GetBlockReceipts(hash types.Hash) ([]*Receipt, error) {
+	blocks, err := bc.GetBlockByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	block := blocks[0]
+	if header, err := bc.GetHeader(block.Height); err != nil || NewHeaderHasher().Hash(header) != hash {
+		return nil, fmt.Errorf("block %s is not in the canonical chain", hash)
+	}
+	bc.txLock.RLock()
+	defer bc.txLock.RUnlock()
+	receipts := make([]*Receipt, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		location, ok := bc.txLookup[tx.GetHash(NewTransactionHasher())]
+		if !ok || location.BlockHash != hash {
+			return nil, fmt.Errorf("block %s is not in the canonical chain", hash)
+		}
+		receipts = append(receipts, location.Receipt)
+	}
+	return receipts, nil
+}
+
 func (bc *Blockchain) GetBalance(addr types.Address) (uint64, error) {
-	return bc.accountState.GetBalance(addr)
+	return bc.state.accounts.GetBalance(addr)
 }
+
+// GetNonce returns the nonce the next transaction of addr must carry
+func (bc *Blockchain) GetNonce(addr types.Address) (uint64, error) {
+	return bc.state.accounts.GetNonce(addr)
+}
+
+func (bc *Blockchain) Close() error {
+	return bc.storage.Close()
+}
+
+var (
+	ErrGenesisMismatch     = errors.New("stored genesis block does not match the given one")
+	ErrReorgTooDeep        = errors.New("reorg too deep")
+	ErrBlockHasNoValidator = errors.New("block has no validator to pay fees to")
+)
@@ -1,9 +1,13 @@
 package core
 
 import (
+	"errors"
 	"github.com/go-kit/log"
 	"github.com/matrix-go/block/crypto"
+	"math"
+	"math/big"
 	"os"
+	"slices"
 	"testing"
 
 	"github.com/matrix-go/block/types"
@@ -14,11 +18,20 @@ import (
 func newBlockChainWithGenesisBlock(t *testing.T) (bc *Blockchain) {
 	genesis := randomBlockWithSignature(0, types.Hash{})
 	logger := log.NewLogfmtLogger(os.Stderr)
-	bc, err := NewBlockchain(genesis, logger)
+	bc, err := NewBlockchain(BlockchainOpt{Logger: logger, Genesis: genesis})
 	require.NoError(t, err)
 	return bc
 }
 
+// proposeRandomBlock builds the next block of bc with one random transaction
+func proposeRandomBlock(t *testing.T, bc *Blockchain) *Block {
+	privKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	b, err := bc.ProposeBlock(privKey, []*Transaction{randomTxWithSignature()})
+	require.NoError(t, err)
+	return b
+}
+
 func getPreviousBlockHash(t *testing.T, bc *Blockchain, height uint64) types.Hash {
 	header, err := bc.GetHeader(height)
 	require.NoError(t, err)
@@ -29,8 +42,8 @@ func TestBlockchain_AddBlock(t *testing.T) {
 	bc := newBlockChainWithGenesisBlock(t)
 
 	for i := 0; i < 100; i++ {
-		prevHash := getPreviousBlockHash(t, bc, bc.Height())
-		b := randomBlockWithSignature(uint64(i+1), prevHash)
+		b := proposeRandomBlock(t, bc)
+		assert.Equal(t, uint64(i+1), b.Height)
 		err := bc.AddBlock(b)
 		require.NoError(t, err)
 	}
@@ -71,9 +84,9 @@ func TestSendNativeTransfer(t *testing.T) {
 	)
 
 	// initial bob with amount
-	err = chain.accountState.CreateAccount(bobAddress)
+	err = chain.state.accounts.CreateAccount(bobAddress)
 	require.NoError(t, err)
-	err = chain.accountState.AddBalance(bobAddress, amount)
+	err = chain.state.accounts.AddBalance(bobAddress, amount)
 	require.NoError(t, err)
 
 	tx := NewTransaction(nil)
@@ -83,18 +96,12 @@ func TestSendNativeTransfer(t *testing.T) {
 	err = tx.Sign(bobPrivateKey)
 	require.NoError(t, err)
 
-	block, err := chain.GetBlock(chain.Height())
-	require.NoError(t, err)
-
-	txs := []*Transaction{tx}
-	newBlock, err := NewBlockWithPrevHeader(block.Header, txs)
-	require.NoError(t, err)
-
 	// mine the block
 	minner, err := crypto.GeneratePrivateKey()
 	require.NoError(t, err)
-	err = newBlock.Sign(minner)
+	newBlock, err := chain.ProposeBlock(minner, []*Transaction{tx})
 	require.NoError(t, err)
+	require.Len(t, newBlock.Transactions, 1)
 
 	// add block
 	err = chain.AddBlock(newBlock)
@@ -131,13 +138,13 @@ func TestSendNativeTransferWithHacker(t *testing.T) {
 	)
 
 	// initial bob with amount
-	err = chain.accountState.CreateAccount(bobAddress)
+	err = chain.state.accounts.CreateAccount(bobAddress)
 	require.NoError(t, err)
-	err = chain.accountState.AddBalance(bobAddress, amount)
+	err = chain.state.accounts.AddBalance(bobAddress, amount)
 	require.NoError(t, err)
 
 	// initial hacker
-	err = chain.accountState.CreateAccount(hackerAddress)
+	err = chain.state.accounts.CreateAccount(hackerAddress)
 	require.NoError(t, err)
 
 	tx := NewTransaction(nil)
@@ -179,3 +186,975 @@ func TestSendNativeTransferWithHacker(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, hackerBalance, uint64(0))
 }
+
+func TestBlockchain_ReloadFromStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	require.NoError(t, err)
+
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	logger := log.NewLogfmtLogger(os.Stderr)
+	bc, err := NewBlockchain(BlockchainOpt{Logger: logger, Storage: storage, Genesis: genesis})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, bc.AddBlock(proposeRandomBlock(t, bc)))
+	}
+	root := bc.StateRoot()
+	tip := getPreviousBlockHash(t, bc, bc.Height())
+	receipts, err := bc.GetBlockReceipts(tip)
+	require.NoError(t, err)
+	require.NotEmpty(t, receipts)
+	require.NoError(t, bc.Close())
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	bc, err = NewBlockchain(BlockchainOpt{Logger: logger, Storage: storage, Genesis: genesis})
+	require.NoError(t, err)
+	defer bc.Close()
+
+	assert.Equal(t, uint64(10), bc.Height())
+	assert.Equal(t, tip, getPreviousBlockHash(t, bc, bc.Height()))
+	assert.Equal(t, root, bc.StateRoot())
+	// the receipts are rebuilt by executing the blocks again
+	reloaded, err := bc.GetBlockReceipts(tip)
+	require.NoError(t, err)
+	assert.Equal(t, receipts, reloaded)
+}
+
+func TestBlockchain_ReloadGenesisMismatch(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	bc, err := NewBlockchain(BlockchainOpt{Storage: storage, Genesis: randomBlockWithSignature(0, types.Hash{})})
+	require.NoError(t, err)
+	require.NoError(t, bc.Close())
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer storage.Close()
+	_, err = NewBlockchain(BlockchainOpt{Storage: storage, Genesis: randomBlockWithSignature(0, types.Hash{})})
+	assert.ErrorIs(t, err, ErrGenesisMismatch)
+}
+
+func TestAddBlockRevertsOnFailedTransaction(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	bobPrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	var (
+		bobPubKey         = bobPrivateKey.PublicKey()
+		bobAddress        = bobPubKey.Address()
+		amount     uint64 = 1000
+	)
+	err = chain.state.accounts.AddBalance(bobAddress, amount)
+	require.NoError(t, err)
+
+	// push FOO and pack, push 1 and store [FOO, 1]
+	contract := NewTransaction([]byte{
+		0x03, 0x0a, 0x02, 0x0a, 0x0e,
+		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d,
+		0x0f,
+	})
+	contract.GasLimit = testGas
+	require.NoError(t, contract.Sign(bobPrivateKey))
+	txs := []*Transaction{contract}
+	receivers := make([]*crypto.PublicKey, 0)
+	for i := 0; i < 3; i++ {
+		receiverKey, err := crypto.GeneratePrivateKey()
+		require.NoError(t, err)
+		tx := NewTransaction(nil)
+		tx.From = bobPubKey
+		tx.To = receiverKey.PublicKey()
+		tx.Value = 400
+		tx.Nonce = uint64(i + 1)
+		require.NoError(t, tx.Sign(bobPrivateKey))
+		txs = append(txs, tx)
+		receivers = append(receivers, tx.To)
+	}
+
+	block, err := chain.GetBlock(chain.Height())
+	require.NoError(t, err)
+	newBlock, err := NewBlockWithPrevHeader(block.Header, txs)
+	require.NoError(t, err)
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	require.NoError(t, newBlock.Sign(minner))
+
+	err = chain.AddBlock(newBlock)
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+	assert.Equal(t, uint64(0), chain.Height())
+
+	bobBalance, err := chain.GetBalance(bobAddress)
+	require.NoError(t, err)
+	assert.Equal(t, amount, bobBalance)
+	for _, receiver := range receivers {
+		_, err = chain.GetBalance(receiver.Address())
+		assert.ErrorIs(t, err, ErrAccountNotFound)
+	}
+	_, err = stored(chain, contract, "FOO")
+	assert.Error(t, err)
+}
+
+func TestAddBlockWithInvalidStateRoot(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	bobPrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobAddress := bobPrivateKey.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(bobAddress, 1000))
+
+	aliceKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	tx := NewTransaction(nil)
+	tx.From = bobPrivateKey.PublicKey()
+	tx.To = aliceKey.PublicKey()
+	tx.Value = 100
+	require.NoError(t, tx.Sign(bobPrivateKey))
+
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	rootBefore := chain.StateRoot()
+	block, err := chain.ProposeBlock(minner, []*Transaction{tx})
+	require.NoError(t, err)
+	assert.Equal(t, rootBefore, chain.StateRoot())
+	assert.NotEqual(t, rootBefore, block.StateRoot)
+
+	// a validator claiming a different outcome is rejected
+	block.StateRoot = types.RandomHash()
+	require.NoError(t, block.Sign(minner))
+	err = chain.AddBlock(block)
+	assert.ErrorIs(t, err, ErrBlockStateRootInvalid)
+	assert.Equal(t, rootBefore, chain.StateRoot())
+	balance, err := chain.GetBalance(bobAddress)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), balance)
+}
+
+func TestGetTransactionProof(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	txs := []*Transaction{randomTxWithSignature(), randomTxWithSignature(), randomTxWithSignature()}
+	block, err := chain.ProposeBlock(minner, txs)
+	require.NoError(t, err)
+	require.NoError(t, chain.AddBlock(block))
+
+	header, err := chain.GetHeader(1)
+	require.NoError(t, err)
+	for _, tx := range txs {
+		hash := tx.GetHash(NewTransactionHasher())
+		proof, err := chain.GetTransactionProof(hash)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), proof.Height)
+		assert.Equal(t, header.DataHash, proof.DataHash)
+		assert.True(t, proof.Verify())
+	}
+	_, err = chain.GetTransactionProof(types.RandomHash())
+	assert.Error(t, err)
+}
+
+// storeTx stores 1 under the three bytes long key
+func storeTx(t *testing.T, key string) *Transaction {
+	privKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	tx := NewTransaction([]byte{
+		0x03, 0x0a, 0x02, 0x0a, 0x0e,
+		key[0], 0x0c, key[1], 0x0c, key[2], 0x0c, 0x03, 0x0a, 0x0d,
+		0x0f,
+	})
+	tx.GasLimit = testGas
+	require.NoError(t, tx.Sign(privKey))
+	return tx
+}
+
+// stored reads key from the storage of the sender of tx
+func stored(chain *Blockchain, tx *Transaction, key string) ([]byte, error) {
+	return chain.state.contractStorage(tx.From.Address()).Get([]byte(key))
+}
+
+// extendChain proposes and adds a block with txs on top of bc
+func extendChain(t *testing.T, bc *Blockchain, txs ...*Transaction) *Block {
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	block, err := bc.ProposeBlock(minner, txs)
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, len(txs))
+	require.NoError(t, bc.AddBlock(block))
+	return block
+}
+
+func TestBlockchain_Reorg(t *testing.T) {
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	storage := NewMemStorage()
+	chain, err := NewBlockchain(BlockchainOpt{Storage: storage, Genesis: genesis})
+	require.NoError(t, err)
+	fork, err := NewBlockchain(BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+
+	disconnected := make([]*Block, 0)
+	connected := make([]*Block, 0)
+	chain.SetReorgHandler(func(d, c []*Block) {
+		// the state lock is released before the handler is called
+		require.True(t, chain.stateLock.TryLock())
+		chain.stateLock.Unlock()
+		disconnected = append(disconnected, d...)
+		connected = append(connected, c...)
+	})
+
+	orphan := extendChain(t, chain, storeTx(t, "AAA"))
+	forkBlocks := []*Block{
+		extendChain(t, fork, storeTx(t, "BBB")),
+		extendChain(t, fork, randomTxWithSignature()),
+	}
+	for _, block := range forkBlocks {
+		require.NoError(t, chain.AddBlock(block))
+	}
+
+	assert.Equal(t, uint64(2), chain.Height())
+	assert.Equal(t, getPreviousBlockHash(t, fork, 2), getPreviousBlockHash(t, chain, 2))
+	assert.Equal(t, fork.StateRoot(), chain.StateRoot())
+	_, err = stored(chain, orphan.Transactions[0], "AAA")
+	assert.Error(t, err)
+	_, err = stored(chain, forkBlocks[0].Transactions[0], "BBB")
+	assert.NoError(t, err)
+	assert.True(t, chain.HasBlockHash(orphan.GetHash(NewHeaderHasher())))
+	// the storage returns the canonical block at a height
+	canonical, err := storage.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, forkBlocks[0], canonical)
+	_, err = chain.GetTransactionByHash(orphan.Transactions[0].GetHash(NewTransactionHasher()))
+	assert.Error(t, err)
+
+	require.Len(t, disconnected, 1)
+	assert.Equal(t, orphan, disconnected[0])
+	// the orphan was reported when it extended the chain, equal weights
+	// keep the current tip so the switch happens on the second fork block
+	assert.Equal(t, append([]*Block{orphan}, forkBlocks...), connected)
+
+	// the reorganised chain is what gets reloaded
+	reloaded, err := NewBlockchain(BlockchainOpt{Storage: storage, Genesis: genesis})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), reloaded.Height())
+	assert.Equal(t, chain.StateRoot(), reloaded.StateRoot())
+	assert.Equal(t, getPreviousBlockHash(t, chain, 2), getPreviousBlockHash(t, reloaded, 2))
+}
+
+func TestBlockchain_HeaviestChain(t *testing.T) {
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	chain, err := NewBlockchain(BlockchainOpt{Genesis: genesis, ForkChoice: HeaviestChain{}})
+	require.NoError(t, err)
+	fork, err := NewBlockchain(BlockchainOpt{Genesis: genesis, ForkChoice: HeaviestChain{}})
+	require.NoError(t, err)
+
+	extendChain(t, chain, storeTx(t, "AAA"))
+	extendChain(t, chain, storeTx(t, "BBB"))
+	heavy := extendChain(t, fork, storeTx(t, "CCC"), storeTx(t, "DDD"), storeTx(t, "EEE"))
+
+	require.NoError(t, chain.AddBlock(heavy))
+	assert.Equal(t, uint64(1), chain.Height())
+	assert.Equal(t, heavy.GetHash(NewHeaderHasher()), getPreviousBlockHash(t, chain, 1))
+}
+
+func TestHeaviestChain_WeightSaturates(t *testing.T) {
+	block := randomBlockWithSignature(1, types.Hash{})
+	block.GasUsed = math.MaxUint64
+	weight := HeaviestChain{}.Weight(block)
+	assert.Equal(t, uint64(math.MaxUint64), weight)
+	assert.Equal(t, uint64(math.MaxUint64), saturatingAdd(weight, 1))
+	assert.Equal(t, uint64(3), saturatingAdd(1, 2))
+}
+
+func TestBlockchain_EqualWeightKeepsTip(t *testing.T) {
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	for _, forkChoice := range []ForkChoice{LongestChain{}, HeaviestChain{}} {
+		chain, err := NewBlockchain(BlockchainOpt{Genesis: genesis, ForkChoice: forkChoice})
+		require.NoError(t, err)
+		fork, err := NewBlockchain(BlockchainOpt{Genesis: genesis, ForkChoice: forkChoice})
+		require.NoError(t, err)
+		minner, err := crypto.GeneratePrivateKey()
+		require.NoError(t, err)
+
+		// side blocks of the same weight, whatever their hash
+		tip := extendChain(t, chain, randomTxWithSignature())
+		for i := 0; i < 5; i++ {
+			side, err := fork.ProposeBlock(minner, []*Transaction{randomTxWithSignature()})
+			require.NoError(t, err)
+			require.NoError(t, chain.AddBlock(side))
+			assert.Equal(t, tip.GetHash(NewHeaderHasher()), getPreviousBlockHash(t, chain, 1))
+		}
+	}
+}
+
+func TestBlockchain_ReorgTooDeep(t *testing.T) {
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	chain, err := NewBlockchain(BlockchainOpt{Genesis: genesis, MaxReorgDepth: 2})
+	require.NoError(t, err)
+	fork, err := NewBlockchain(BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		extendChain(t, chain, randomTxWithSignature())
+		extendChain(t, fork, randomTxWithSignature())
+	}
+	extendChain(t, fork, randomTxWithSignature())
+	tip := getPreviousBlockHash(t, chain, chain.Height())
+
+	// the reorg is only attempted once the fork is heavier
+	for height := uint64(1); height <= fork.Height(); height++ {
+		block, err := fork.GetBlock(height)
+		require.NoError(t, err)
+		if err = chain.AddBlock(block); height == fork.Height() {
+			assert.ErrorIs(t, err, ErrReorgTooDeep)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, uint64(3), chain.Height())
+	assert.Equal(t, tip, getPreviousBlockHash(t, chain, chain.Height()))
+}
+
+func TestBlockchain_ReorgToInvalidBranch(t *testing.T) {
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	chain, err := NewBlockchain(BlockchainOpt{Genesis: genesis, ForkChoice: HeaviestChain{}})
+	require.NoError(t, err)
+	fork, err := NewBlockchain(BlockchainOpt{Genesis: genesis, ForkChoice: HeaviestChain{}})
+	require.NoError(t, err)
+
+	tip := extendChain(t, chain, storeTx(t, "AAA"), randomTxWithSignature())
+	root := chain.StateRoot()
+	side := extendChain(t, fork, storeTx(t, "BBB"))
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	invalid, err := fork.ProposeBlock(minner, []*Transaction{randomTxWithSignature()})
+	require.NoError(t, err)
+	invalid.StateRoot = types.RandomHash()
+	require.NoError(t, invalid.Sign(minner))
+
+	require.NoError(t, chain.AddBlock(side))
+	err = chain.AddBlock(invalid)
+	assert.ErrorIs(t, err, ErrBlockStateRootInvalid)
+
+	// the previous chain is restored
+	assert.Equal(t, uint64(1), chain.Height())
+	assert.Equal(t, tip.GetHash(NewHeaderHasher()), getPreviousBlockHash(t, chain, 1))
+	assert.Equal(t, root, chain.StateRoot())
+	_, err = stored(chain, side.Transactions[0], "BBB")
+	assert.Error(t, err)
+}
+
+// failingStorage fails to put blocks once fail is set
+type failingStorage struct {
+	*MemStorage
+	fail bool
+}
+
+func (s *failingStorage) Put(b *Block) error {
+	if s.fail {
+		return errors.New("storage failed")
+	}
+	return s.MemStorage.Put(b)
+}
+
+func TestBlockchain_ReorgRestoreFails(t *testing.T) {
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	storage := &failingStorage{MemStorage: NewMemStorage()}
+	chain, err := NewBlockchain(BlockchainOpt{Genesis: genesis, Storage: storage, ForkChoice: HeaviestChain{}})
+	require.NoError(t, err)
+	fork, err := NewBlockchain(BlockchainOpt{Genesis: genesis, ForkChoice: HeaviestChain{}})
+	require.NoError(t, err)
+	var disconnected, connected []*Block
+	chain.SetReorgHandler(func(d, c []*Block) {
+		disconnected, connected = d, c
+	})
+
+	tip := extendChain(t, chain, storeTx(t, "AAA"), randomTxWithSignature())
+	side := extendChain(t, fork, storeTx(t, "BBB"))
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	invalid, err := fork.ProposeBlock(minner, []*Transaction{randomTxWithSignature()})
+	require.NoError(t, err)
+	invalid.StateRoot = types.RandomHash()
+	require.NoError(t, invalid.Sign(minner))
+	require.NoError(t, chain.AddBlock(side))
+
+	// neither the new branch nor the previous tip can be stored, the chain
+	// ends before them
+	storage.fail = true
+	err = chain.AddBlock(invalid)
+	assert.ErrorContains(t, err, "restore block "+tip.GetHash(NewHeaderHasher()).String())
+	assert.Equal(t, uint64(0), chain.Height())
+	assert.Equal(t, []*Block{tip}, disconnected)
+	assert.Empty(t, connected)
+}
+
+func TestBlockchain_Nonce(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	bobPrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobAddress := bobPrivateKey.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(bobAddress, 1000))
+
+	transfer := func(nonce uint64) *Transaction {
+		aliceKey, err := crypto.GeneratePrivateKey()
+		require.NoError(t, err)
+		tx := NewTransaction(nil)
+		tx.To = aliceKey.PublicKey()
+		tx.Value = 10
+		tx.Nonce = nonce
+		require.NoError(t, tx.Sign(bobPrivateKey))
+		return tx
+	}
+
+	first := transfer(0)
+	extendChain(t, chain, first, transfer(1))
+	nonce, err := chain.GetNonce(bobAddress)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), nonce)
+
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	// a replayed transaction is left out of the block
+	block, err := chain.ProposeBlock(minner, []*Transaction{first, transfer(3), transfer(2)})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 2)
+	assert.Equal(t, uint64(2), block.Transactions[0].Nonce)
+
+	// and rejected when a block includes it anyway
+	header, err := chain.GetHeader(chain.Height())
+	require.NoError(t, err)
+	for _, txs := range [][]*Transaction{{first}, {transfer(3)}} {
+		b, err := NewBlockWithPrevHeader(header, txs)
+		require.NoError(t, err)
+		require.NoError(t, b.Sign(minner))
+		err = chain.AddBlock(b)
+		assert.True(t, errors.Is(err, ErrNonceTooLow) || errors.Is(err, ErrNonceTooHigh), err)
+	}
+
+	// the nonces inside a block must follow each other
+	b, err := NewBlockWithPrevHeader(header, []*Transaction{transfer(2), transfer(4)})
+	require.NoError(t, err)
+	require.NoError(t, b.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(b), ErrNonceTooHigh)
+	assert.Equal(t, uint64(1), chain.Height())
+}
+
+func TestBlockchain_ChainID(t *testing.T) {
+	genesis := randomBlock(0, types.Hash{})
+	genesis.ChainID = 7
+	chain, err := NewBlockchain(BlockchainOpt{Genesis: genesis})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), chain.ChainID())
+
+	privKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	staging := NewTransaction(nil)
+	require.NoError(t, staging.Sign(privKey))
+	tx := NewTransaction(nil)
+	tx.ChainID = 7
+	require.NoError(t, tx.Sign(privKey))
+	assert.NotEqual(t, staging.GetHash(NewTransactionHasher()), tx.GetHash(NewTransactionHasher()))
+
+	// a transaction signed for another chain is left out
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	block, err := chain.ProposeBlock(minner, []*Transaction{staging, tx})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 1)
+	assert.Equal(t, uint64(7), block.ChainID)
+	require.NoError(t, chain.AddBlock(block))
+
+	header, err := chain.GetHeader(chain.Height())
+	require.NoError(t, err)
+	b, err := NewBlockWithPrevHeader(header, []*Transaction{staging})
+	require.NoError(t, err)
+	require.NoError(t, b.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(b), ErrChainIDMismatch)
+
+	b, err = NewBlockWithPrevHeader(header, nil)
+	require.NoError(t, err)
+	b.ChainID = 8
+	require.NoError(t, b.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(b), ErrChainIDMismatch)
+}
+
+func TestBlockchain_Fee(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	bobPrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	aliceKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobAddress := bobPrivateKey.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(bobAddress, 1000))
+
+	transfer := func(nonce, value, fee uint64) *Transaction {
+		tx := NewTransaction(nil)
+		tx.To = aliceKey.PublicKey()
+		tx.Value = value
+		tx.Fee = fee
+		tx.Nonce = nonce
+		require.NoError(t, tx.Sign(bobPrivateKey))
+		return tx
+	}
+
+	// the second transfer cannot pay its fee and is left out
+	block, err := chain.ProposeBlock(minner, []*Transaction{transfer(0, 100, 10), transfer(1, 890, 1)})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 1)
+	require.NoError(t, chain.AddBlock(block))
+
+	for addr, expected := range map[types.Address]uint64{
+		bobAddress:                     890,
+		aliceKey.PublicKey().Address(): 100,
+		minner.PublicKey().Address():   10,
+	} {
+		balance, err := chain.GetBalance(addr)
+		require.NoError(t, err)
+		assert.Equal(t, expected, balance)
+	}
+
+	// a data transaction pays its fee as well
+	tx := NewTransaction([]byte{0x01, 0x0a})
+	tx.Fee = 891
+	tx.Nonce = 1
+	require.NoError(t, tx.Sign(bobPrivateKey))
+	block, err = chain.ProposeBlock(minner, []*Transaction{tx})
+	require.NoError(t, err)
+	assert.Empty(t, block.Transactions)
+}
+
+func TestBlockchain_GasPrice(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	// the transaction of the genesis block pays no gas
+	chain.minGasPrice = 2
+	senderKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	sender := senderKey.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(sender, 1000))
+
+	newTx := func(nonce, gasPrice uint64, code []byte) *Transaction {
+		tx := NewTransaction(code)
+		tx.GasLimit = 100
+		tx.GasPrice = gasPrice
+		tx.Fee = 1
+		tx.Nonce = nonce
+		require.NoError(t, tx.Sign(senderKey))
+		return tx
+	}
+
+	// below the minimum gas price
+	block, err := chain.ProposeBlock(minner, []*Transaction{newTx(0, 1, nil)})
+	require.NoError(t, err)
+	assert.Empty(t, block.Transactions)
+
+	// the gas used is paid, the rest refunded, a failed run pays all its gas
+	block, err = chain.ProposeBlock(minner, []*Transaction{
+		newTx(0, 2, []byte{0x01, 0x0a, 0x02, 0x0a, 0x0b}),
+		newTx(1, 3, []byte{0x15, 0x00, 0x0a, 0x13}),
+	})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 2)
+	require.NoError(t, chain.AddBlock(block))
+	receipt, err := chain.GetReceipt(block.Transactions[0].GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	gasUsed := receipt.GasUsed
+	require.NotZero(t, gasUsed)
+
+	paid := 2 + gasUsed*2 + 100*3
+	for addr, expected := range map[types.Address]uint64{
+		sender:                       1000 - paid,
+		minner.PublicKey().Address(): paid,
+	} {
+		balance, err := chain.GetBalance(addr)
+		require.NoError(t, err)
+		assert.Equal(t, expected, balance)
+	}
+}
+
+func TestBlockchain_BlockReward(t *testing.T) {
+	chain, err := NewBlockchain(BlockchainOpt{
+		Genesis:  randomBlockWithSignature(0, types.Hash{}),
+		Issuance: Issuance{Schedule: IssuanceFixed, Reward: 50},
+	})
+	require.NoError(t, err)
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	block, err := chain.ProposeBlock(minner, []*Transaction{randomTxWithSignature()})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 2)
+	require.NotNil(t, block.Coinbase())
+	assert.Equal(t, uint64(50), block.Coinbase().Value)
+
+	// a validator cannot pay itself more than the schedule
+	forged := *block
+	header := *block.Header
+	forged.Header = &header
+	forged.Transactions = append([]*Transaction{NewCoinbaseTransaction(minner.PublicKey(), 1, 51, 0)}, block.Transactions[1:]...)
+	forged.DataHash, err = CalculateDataHash(forged.Transactions)
+	require.NoError(t, err)
+	require.NoError(t, forged.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(&forged), ErrBlockRewardTooHigh)
+
+	// nor pay it to another account
+	other, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	forged.Transactions[0] = NewCoinbaseTransaction(other.PublicKey(), 1, 50, 0)
+	forged.DataHash, err = CalculateDataHash(forged.Transactions)
+	require.NoError(t, err)
+	require.NoError(t, forged.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(&forged), ErrBlockCoinbaseInvalid)
+
+	// only the first transaction may be unsigned
+	forged.Transactions = append(slices.Clone(block.Transactions), NewCoinbaseTransaction(minner.PublicKey(), 1, 50, 0))
+	forged.DataHash, err = CalculateDataHash(forged.Transactions)
+	require.NoError(t, err)
+	require.NoError(t, forged.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(&forged), ErrTransactionNotSigned)
+
+	require.NoError(t, chain.AddBlock(block))
+	balance, err := chain.GetBalance(minner.PublicKey().Address())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(50), balance)
+}
+
+func TestBlockchain_Gas(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	bobPrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobAddress := bobPrivateKey.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(bobAddress, 1000))
+
+	store := func(nonce, gas uint64) *Transaction {
+		tx := NewTransaction([]byte{
+			0x03, 0x0a, 0x02, 0x0a, 0x0e,
+			0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d,
+			0x0f,
+		})
+		tx.Fee = 10
+		tx.Nonce = nonce
+		tx.GasLimit = gas
+		require.NoError(t, tx.Sign(bobPrivateKey))
+		return tx
+	}
+
+	// running out of gas reverts the store, the fee and the nonce are used
+	// anyway, the transaction above the block gas limit is left out
+	block, err := chain.ProposeBlock(minner, []*Transaction{store(0, 5), store(1, chain.GasLimit()+1)})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 1)
+	assert.Equal(t, uint64(5), block.GasUsed)
+	require.NoError(t, chain.AddBlock(block))
+	_, err = chain.state.contractStorage(bobAddress).Get([]byte("FOO"))
+	assert.Error(t, err)
+	nonce, err := chain.GetNonce(bobAddress)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), nonce)
+	balance, err := chain.GetBalance(bobAddress)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(990), balance)
+
+	block, err = chain.ProposeBlock(minner, []*Transaction{store(1, testGas)})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 1)
+	assert.Greater(t, block.GasUsed, uint64(5))
+
+	// gas used is checked on execution
+	forged := *block
+	header := *block.Header
+	forged.Header = &header
+	forged.GasUsed--
+	require.NoError(t, forged.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(&forged), ErrBlockGasUsedInvalid)
+
+	// a block cannot raise its gas limit
+	forged.GasUsed++
+	forged.GasLimit++
+	require.NoError(t, forged.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(&forged), ErrBlockGasLimitInvalid)
+
+	// nor claim more gas than its transactions may use
+	forged.GasLimit--
+	forged.GasUsed = forged.Transactions[0].GasLimit + 1
+	require.NoError(t, forged.Sign(minner))
+	assert.ErrorIs(t, chain.AddBlock(&forged), ErrBlockGasUsedInvalid)
+
+	require.NoError(t, chain.AddBlock(block))
+	value, err := chain.state.contractStorage(bobAddress).Get([]byte("FOO"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, value)
+}
+
+func TestBlockchain_FailedTransaction(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	bobPrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	minner, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	bobAddress := bobPrivateKey.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(bobAddress, 1000))
+
+	// store [FOO, 1], then divide by zero
+	tx := NewTransaction([]byte{
+		0x03, 0x0a, 0x02, 0x0a, 0x0e,
+		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d,
+		0x0f,
+		0x01, 0x0a, 0x00, 0x0a, 0x12,
+	})
+	tx.Fee = 10
+	tx.GasLimit = testGas
+	require.NoError(t, tx.Sign(bobPrivateKey))
+	ok := storeTx(t, "BAR")
+
+	block, err := chain.ProposeBlock(minner, []*Transaction{tx, ok})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 2)
+	require.NoError(t, chain.AddBlock(block))
+
+	receipt, err := chain.GetReceipt(tx.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.True(t, receipt.Failed())
+	assert.Contains(t, receipt.Err, ErrDivisionByZero.Error())
+	assert.NotZero(t, receipt.GasUsed)
+	receipt, err = chain.GetReceipt(ok.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.False(t, receipt.Failed())
+
+	// the store is reverted, the fee and the nonce are not
+	_, err = stored(chain, tx, "FOO")
+	assert.Error(t, err)
+	_, err = stored(chain, ok, "BAR")
+	assert.NoError(t, err)
+	balance, err := chain.GetBalance(bobAddress)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(990), balance)
+	nonce, err := chain.GetNonce(bobAddress)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), nonce)
+}
+
+func TestBlockchain_Contract(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	bobPrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	alicePrivateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// store [FOO, 1]
+	code := []byte{
+		0x03, 0x0a, 0x02, 0x0a, 0x0e,
+		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d,
+		0x0f,
+	}
+	deploy := NewTransaction(nil)
+	deploy.InnerTx = &DeployTx{Code: code}
+	deploy.GasLimit = testGas
+	require.NoError(t, deploy.Sign(bobPrivateKey))
+	contract := ContractAddress(bobPrivateKey.PublicKey().Address(), 0)
+	invoke := NewTransaction(nil)
+	invoke.InnerTx = &InvokeTx{Contract: contract}
+	invoke.GasLimit = testGas
+	require.NoError(t, invoke.Sign(alicePrivateKey))
+	extendChain(t, chain, deploy)
+	extendChain(t, chain, invoke)
+
+	receipt, err := chain.GetReceipt(deploy.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	require.False(t, receipt.Failed())
+	assert.Equal(t, contract, *receipt.Contract)
+	assert.Equal(t, uint64(len(code))*GasCodeByte, receipt.GasUsed)
+	deployed, err := chain.GetContractCode(contract)
+	require.NoError(t, err)
+	assert.Equal(t, code, deployed)
+
+	// the invoke writes to the storage of the contract only
+	receipt, err = chain.GetReceipt(invoke.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	require.False(t, receipt.Failed())
+	value, err := chain.state.contractStorage(contract).Get([]byte("FOO"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), new(big.Int).SetBytes(value).Int64())
+	_, err = stored(chain, invoke, "FOO")
+	assert.Error(t, err)
+
+	// store [FOO, 5] from the sender storage
+	raw := NewTransaction([]byte{
+		0x03, 0x0a, 0x02, 0x0a, 0x0b,
+		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d,
+		0x0f,
+	})
+	raw.GasLimit = testGas
+	raw.Nonce = 1
+	require.NoError(t, raw.Sign(alicePrivateKey))
+	unknown := NewTransaction(nil)
+	unknown.InnerTx = &InvokeTx{Contract: types.Address{}}
+	unknown.GasLimit = testGas
+	unknown.Nonce = 1
+	require.NoError(t, unknown.Sign(bobPrivateKey))
+	extendChain(t, chain, raw, unknown)
+
+	value, err = stored(chain, raw, "FOO")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), new(big.Int).SetBytes(value).Int64())
+	value, err = chain.state.contractStorage(contract).Get([]byte("FOO"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), new(big.Int).SetBytes(value).Int64())
+	receipt, err = chain.GetReceipt(unknown.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.Contains(t, receipt.Err, ErrContractNotFound.Error())
+}
+
+func TestContractAddress(t *testing.T) {
+	from := types.Address{1}
+	assert.Equal(t, ContractAddress(from, 3), ContractAddress(from, 3))
+	assert.NotEqual(t, ContractAddress(from, 3), ContractAddress(from, 4))
+	assert.NotEqual(t, ContractAddress(from, 3), ContractAddress(types.Address{2}, 3))
+}
+
+func TestBlockchain_ContractContext(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	privateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// fails with an unknown opcode unless the height is above 2
+	timelock := func(nonce uint64) *Transaction {
+		tx := NewTransaction([]byte{
+			0x23, 0x02, 0x0a, 0x18, // height > 2
+			0x08, 0x0a, 0x14, // jumpi to 8
+			0xff, // unknown
+			0x15, // jumpdest
+		})
+		tx.GasLimit = testGas
+		tx.Nonce = nonce
+		require.NoError(t, tx.Sign(privateKey))
+		return tx
+	}
+	early := timelock(0)
+	extendChain(t, chain, early)
+	extendChain(t, chain)
+	late := timelock(1)
+	extendChain(t, chain, late)
+
+	receipt, err := chain.GetReceipt(early.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.Contains(t, receipt.Err, ErrUnknownOpcode.Error())
+	receipt, err = chain.GetReceipt(late.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.False(t, receipt.Failed())
+}
+
+func TestBlockchain_Receipts(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	privateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// log "hi" with topic "ev" and return 7
+	logged := []byte{
+		0x68, 0x0c, 0x69, 0x0c, 0x02, 0x0a, 0x0d,
+		0x65, 0x0c, 0x76, 0x0c, 0x02, 0x0a, 0x0d,
+		0x26,
+		0x07, 0x0a, 0x20,
+	}
+	tx := NewTransaction(logged)
+	tx.GasLimit = testGas
+	require.NoError(t, tx.Sign(privateKey))
+	// the same, then divide by zero
+	failed := NewTransaction(append(slices.Clone(logged[:15]), 0x01, 0x0a, 0x00, 0x0a, 0x12))
+	failed.GasLimit = testGas
+	failed.Nonce = 1
+	require.NoError(t, failed.Sign(privateKey))
+	block := extendChain(t, chain, tx, failed)
+	blockHash := block.GetHash(NewHeaderHasher())
+
+	receipt, err := chain.GetReceipt(tx.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.Equal(t, ReceiptStatusSuccess, receipt.Status)
+	assert.Equal(t, blockHash, receipt.BlockHash)
+	assert.Equal(t, uint64(1), receipt.Height)
+	assert.Equal(t, int64(7), new(big.Int).SetBytes(receipt.Return).Int64())
+	require.Len(t, receipt.Logs, 1)
+	assert.Equal(t, privateKey.PublicKey().Address(), receipt.Logs[0].Contract)
+	assert.Equal(t, []byte("ev"), receipt.Logs[0].Topic)
+
+	// a failed transaction emits nothing
+	receipt, err = chain.GetReceipt(failed.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.Equal(t, ReceiptStatusFailed, receipt.Status)
+	assert.Empty(t, receipt.Logs)
+
+	receipts, err := chain.GetBlockReceipts(blockHash)
+	require.NoError(t, err)
+	require.Len(t, receipts, 2)
+	assert.Equal(t, tx.GetHash(NewTransactionHasher()), receipts[0].TxHash)
+	assert.Equal(t, block.GasUsed, receipts[0].GasUsed+receipts[1].GasUsed)
+	_, err = chain.GetBlockReceipts(types.RandomHash())
+	assert.Error(t, err)
+}
+
+func TestBlockchain_ContractCalls(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	privateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	alice := privateKey.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(alice, 100))
+	newTx := func(nonce, value uint64, innerTx any) *Transaction {
+		tx := NewTransaction(nil)
+		tx.InnerTx = innerTx
+		tx.Value = value
+		tx.Nonce = nonce
+		tx.GasLimit = testGas
+		require.NoError(t, tx.Sign(privateKey))
+		return tx
+	}
+	balance := func(addr types.Address) uint64 {
+		balance, err := chain.GetBalance(addr)
+		if errors.Is(err, ErrAccountNotFound) {
+			return 0
+		}
+		require.NoError(t, err)
+		return balance
+	}
+
+	// the vault sends 3 to its caller, the relays call the vault and the
+	// bad one then divides by zero
+	vault := ContractAddress(alice, 0)
+	relay := slices.Concat([]byte{0x00, 0x0a, 0x0d, 0xff, 0x0a, 0x00, 0x0a}, pushBytes(vault.Bytes()), []byte{0x2c})
+	good, bad := ContractAddress(alice, 1), ContractAddress(alice, 2)
+	extendChain(t, chain,
+		newTx(0, 0, &DeployTx{Code: []byte{0x03, 0x0a, 0x21, 0x2d}}),
+		newTx(1, 0, &DeployTx{Code: relay}),
+		newTx(2, 0, &DeployTx{Code: slices.Concat(relay, []byte{0x01, 0x0a, 0x00, 0x0a, 0x12})}),
+	)
+
+	// the value of an invocation goes to the contract before it runs
+	extendChain(t, chain, newTx(3, 10, &InvokeTx{Contract: vault}))
+	assert.Equal(t, uint64(7), balance(vault))
+	assert.Equal(t, uint64(93), balance(alice))
+
+	invokeBad := newTx(5, 0, &InvokeTx{Contract: bad})
+	extendChain(t, chain, newTx(4, 0, &InvokeTx{Contract: good}), invokeBad)
+	assert.Equal(t, uint64(4), balance(vault))
+	assert.Equal(t, uint64(3), balance(good))
+	assert.Zero(t, balance(bad))
+	receipt, err := chain.GetReceipt(invokeBad.GetHash(NewTransactionHasher()))
+	require.NoError(t, err)
+	assert.Contains(t, receipt.Err, ErrDivisionByZero.Error())
+
+	// a failed invocation gives the value back
+	failed := newTx(6, 5, &InvokeTx{Contract: bad})
+	extendChain(t, chain, failed)
+	assert.Zero(t, balance(bad))
+	assert.Equal(t, uint64(93), balance(alice))
+
+	// a transfer needs a recipient
+	noRecipient := NewTransaction(nil)
+	noRecipient.Value = 1
+	noRecipient.Nonce = 7
+	require.NoError(t, noRecipient.Sign(privateKey))
+	_, err = chain.Simulate(noRecipient)
+	assert.ErrorIs(t, err, ErrTransactionNoRecipient)
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"slices"
+
+	"github.com/matrix-go/block/types"
+)
+
+// ContractStorage is the storage a contract reads and writes from the VM
+type ContractStorage interface {
+	Get(k []byte) ([]byte, error)
+	Put(k, v []byte) error
+}
+
+// DeployTx stores Code as a new contract, its address is ContractAddress
+// of the sender and the nonce of the transaction
+type DeployTx struct {
+	Code []byte
+}
+
+// InvokeTx runs the code of Contract against the storage of Contract, the
+// Data of the transaction is not run but is the call data of the contract
+type InvokeTx struct {
+	Contract types.Address
+}
+
+// ContractAddress is the address of the contract deployed by the
+// transaction of from with nonce
+func ContractAddress(from types.Address, nonce uint64) types.Address {
+	buf := binary.BigEndian.AppendUint64(from.Bytes(), nonce)
+	h := sha256.Sum256(buf)
+	return types.AddressFromBytes(h[len(h)-20:])
+}
+
+// contractStorage is the namespace of one contract in the contract state,
+// every key is prefixed with the address of the contract
+type contractStorage struct {
+	state  *State
+	prefix []byte
+}
+
+func newContractStorage(state *State, addr types.Address) *contractStorage {
+	return &contractStorage{
+		state:  state,
+		prefix: addr.Bytes(),
+	}
+}
+
+func (s *contractStorage) key(k []byte) []byte {
+	return append(slices.Clone(s.prefix), k...)
+}
+
+func (s *contractStorage) Get(k []byte) ([]byte, error) {
+	return s.state.Get(s.key(k))
+}
+
+func (s *contractStorage) Put(k, v []byte) error {
+	return s.state.Put(s.key(k), v)
+}
+
+var (
+	_ ContractStorage = (*State)(nil)
+	_ ContractStorage = (*contractStorage)(nil)
+)
+
+var (
+	ErrContractNotFound = errors.New("contract not found")
+	ErrContractExists   = errors.New("contract already exists")
+)
@@ -0,0 +1,527 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/matrix-go/block/types"
+)
+
+const (
+	segmentFileExt     = ".seg"
+	indexFileName      = "index"
+	walFileName        = "wal"
+	defaultSegmentSize = 64 << 20
+	walCheckpointSize  = 4 << 20
+	recordHeaderLen    = 8 // length and crc32 of the encoded block
+)
+
+// indexEntry locates one block record inside the segment files. An entry
+// without Size is a marker making the block already indexed with Hash the
+// block at Height again.
+type indexEntry struct {
+	Height  uint64
+	Hash    types.Hash
+	Segment uint32
+	Offset  int64
+	Size    uint32
+}
+
+var indexEntryLen = int64(binary.Size(indexEntry{}))
+
+// FileStorage keeps blocks in append-only segment files under dir,
+// together with an index file mapping height and hash to a record.
+// Every Put goes through a write-ahead log. Opening the storage loads the
+// index, verifies the checksums of the records written after the last
+// indexed one and replays unfinished commits, the other records are
+// verified when they are read.
+type FileStorage struct {
+	lock        sync.RWMutex
+	dir         string
+	segmentSize int64
+	segments    map[uint32]*os.File
+	active      uint32
+	activeSize  int64
+	index       *os.File
+	wal         *WAL
+	entries     []indexEntry
+	byHash      map[types.Hash]int
+	byHeight    map[uint64]int
+	// top is the highest height in byHeight
+	top uint64
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	return newFileStorage(dir, defaultSegmentSize)
+}
+
+func newFileStorage(dir string, segmentSize int64) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileStorage{
+		dir:         dir,
+		segmentSize: segmentSize,
+		segments:    make(map[uint32]*os.File),
+		entries:     make([]indexEntry, 0),
+		byHash:      make(map[types.Hash]int),
+		byHeight:    make(map[uint64]int),
+	}
+	if err := s.openSegments(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover loads the index, indexes the records that survived after it and
+// replays the commits the write-ahead log has not seen finished.
+func (s *FileStorage) recover() error {
+	if err := s.openIndex(); err != nil {
+		return err
+	}
+	wal, err := OpenWAL(filepath.Join(s.dir, walFileName))
+	if err != nil {
+		return err
+	}
+	s.wal = wal
+	payloads, err := wal.Recover()
+	if err != nil {
+		return err
+	}
+	for _, payload := range payloads {
+		b := &Block{}
+		if err = b.Decode(NewGobBlockDecoder(bytes.NewReader(payload))); err != nil {
+			// the begin record is intact, so this is not a torn write
+			return fmt.Errorf("decode block from write-ahead log: %w", err)
+		}
+		hash := NewHeaderHasher().Hash(b.Header)
+		if _, exists := s.byHash[hash]; exists {
+			continue
+		}
+		if err = s.write(b.Height, hash, payload); err != nil {
+			return err
+		}
+	}
+	return wal.Checkpoint()
+}
+
+func (s *FileStorage) segmentPath(id uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d%s", id, segmentFileExt))
+}
+
+func (s *FileStorage) openSegments() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	ids := make([]uint32, 0)
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, segmentFileExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentFileExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) == 0 {
+		ids = append(ids, 0)
+	}
+	for _, id := range ids {
+		f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		s.segments[id] = f
+	}
+	s.active = ids[len(ids)-1]
+	info, err := s.segments[s.active].Stat()
+	if err != nil {
+		return err
+	}
+	s.activeSize = info.Size()
+	return nil
+}
+
+// scanSegments reads the records from offset in segment on. A record that
+// fails its checksum in the active segment is treated as a torn write and
+// the segment is truncated there, anywhere else it is reported as
+// corruption.
+func (s *FileStorage) scanSegments(segment uint32, offset int64) ([]indexEntry, error) {
+	entries := make([]indexEntry, 0)
+	for id := segment; id <= s.active; id, offset = id+1, 0 {
+		f, ok := s.segments[id]
+		if !ok {
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		for offset < info.Size() {
+			b, size, err := readRecord(f, offset)
+			if err != nil {
+				if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrChecksumMismatch) {
+					return nil, err
+				}
+				if id != s.active {
+					return nil, fmt.Errorf("segment %d at offset %d: %w", id, offset, ErrSegmentCorrupted)
+				}
+				if err = f.Truncate(offset); err != nil {
+					return nil, err
+				}
+				s.activeSize = offset
+				break
+			}
+			entries = append(entries, indexEntry{
+				Height:  b.Height,
+				Hash:    NewHeaderHasher().Hash(b.Header),
+				Segment: id,
+				Offset:  offset,
+				Size:    size,
+			})
+			offset += recordHeaderLen + int64(size)
+		}
+	}
+	return entries, nil
+}
+
+// openIndex loads the entries of the index file that locate records of the
+// segments, then scans the segments after the last of them for the records
+// the index missed.
+func (s *FileStorage) openIndex() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, indexFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	s.index = f
+	entries, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	segment, offset := s.firstSegment(), int64(0)
+	for _, entry := range slices.Backward(entries) {
+		if entry.Size > 0 {
+			segment, offset = entry.Segment, entry.Offset+recordHeaderLen+int64(entry.Size)
+			break
+		}
+	}
+	missing, err := s.scanSegments(segment, offset)
+	if err != nil {
+		return err
+	}
+
+	if err = f.Truncate(int64(len(entries)) * indexEntryLen); err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for i := range missing {
+		if err = binary.Write(w, binary.LittleEndian, &missing[i]); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	for _, entry := range slices.Concat(entries, missing) {
+		s.addEntry(entry)
+	}
+	return nil
+}
+
+// readIndex reads the entries of the index file up to the first one that
+// does not locate the record following the previous one, or that marks a
+// block not indexed before it
+func (s *FileStorage) readIndex() ([]indexEntry, error) {
+	sizes := make(map[uint32]int64, len(s.segments))
+	for id, f := range s.segments {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		sizes[id] = info.Size()
+	}
+	info, err := s.index.Stat()
+	if err != nil {
+		return nil, err
+	}
+	count := info.Size() / indexEntryLen
+	entries := make([]indexEntry, 0, count)
+	indexed := make(map[types.Hash]bool, count)
+	r := bufio.NewReader(io.NewSectionReader(s.index, 0, count*indexEntryLen))
+	segment, offset := s.firstSegment(), int64(0)
+	for range count {
+		var entry indexEntry
+		if err = binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return nil, err
+		}
+		if entry.Size == 0 {
+			if !indexed[entry.Hash] {
+				break
+			}
+			entries = append(entries, entry)
+			continue
+		}
+		// a record starts the next segment once the previous one is full
+		if entry.Segment != segment && (entry.Segment != segment+1 || offset != sizes[segment]) {
+			break
+		}
+		if entry.Segment != segment {
+			segment, offset = entry.Segment, 0
+		}
+		end := entry.Offset + recordHeaderLen + int64(entry.Size)
+		if entry.Offset != offset || end > sizes[segment] {
+			break
+		}
+		entries = append(entries, entry)
+		indexed[entry.Hash] = true
+		offset = end
+	}
+	return entries, nil
+}
+
+func (s *FileStorage) firstSegment() uint32 {
+	first := s.active
+	for id := range s.segments {
+		if id < first {
+			first = id
+		}
+	}
+	return first
+}
+
+// addEntry indexes entry, its block becomes the block at its height
+func (s *FileStorage) addEntry(entry indexEntry) {
+	if entry.Size > 0 {
+		s.entries = append(s.entries, entry)
+		s.byHash[entry.Hash] = len(s.entries) - 1
+	}
+	s.setHeight(entry.Height, s.byHash[entry.Hash])
+}
+
+// setHeight makes the entry idx the block at height, the blocks above it
+// belong to another branch and are no longer at their heights
+func (s *FileStorage) setHeight(height uint64, idx int) {
+	for h := height + 1; h <= s.top; h++ {
+		delete(s.byHeight, h)
+	}
+	s.byHeight[height] = idx
+	s.top = height
+}
+
+func (s *FileStorage) writeEntry(entry indexEntry) error {
+	if err := binary.Write(s.index, binary.LittleEndian, &entry); err != nil {
+		return err
+	}
+	s.addEntry(entry)
+	return nil
+}
+
+func readRecord(f *os.File, offset int64) (*Block, uint32, error) {
+	header := make([]byte, recordHeaderLen)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint32(header[:4])
+	payload := make([]byte, size)
+	if _, err := f.ReadAt(payload, offset+recordHeaderLen); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, 0, ErrChecksumMismatch
+	}
+	b := &Block{}
+	if err := b.Decode(NewGobBlockDecoder(bytes.NewReader(payload))); err != nil {
+		return nil, 0, err
+	}
+	return b, size, nil
+}
+
+func (s *FileStorage) rotate() error {
+	id := s.active + 1
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	s.segments[id] = f
+	s.active = id
+	s.activeSize = 0
+	return nil
+}
+
+// Put implements Storage.
+func (s *FileStorage) Put(b *Block) error {
+	hash := NewHeaderHasher().Hash(b.Header)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, exists := s.byHash[hash]; exists {
+		if err := s.writeEntry(indexEntry{Height: b.Height, Hash: hash}); err != nil {
+			return err
+		}
+		return s.index.Sync()
+	}
+
+	var buf bytes.Buffer
+	if err := b.Encode(NewGobBlockEncoder(&buf)); err != nil {
+		return err
+	}
+	payload := buf.Bytes()
+	seq, err := s.wal.Begin(payload)
+	if err != nil {
+		return err
+	}
+	if err = s.write(b.Height, hash, payload); err != nil {
+		return err
+	}
+	if err = s.wal.Commit(seq); err != nil {
+		return err
+	}
+	if s.wal.Size() > walCheckpointSize {
+		if err = s.index.Sync(); err != nil {
+			return err
+		}
+		return s.wal.Checkpoint()
+	}
+	return nil
+}
+
+// write appends the encoded block to the active segment and indexes it
+func (s *FileStorage) write(height uint64, hash types.Hash, payload []byte) error {
+	record := make([]byte, recordHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderLen:], payload)
+
+	if s.activeSize > 0 && s.activeSize+int64(len(record)) > s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	f := s.segments[s.active]
+	if _, err := f.WriteAt(record, s.activeSize); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	entry := indexEntry{
+		Height:  height,
+		Hash:    hash,
+		Segment: s.active,
+		Offset:  s.activeSize,
+		Size:    uint32(len(payload)),
+	}
+	s.activeSize += int64(len(record))
+	return s.writeEntry(entry)
+}
+
+func (s *FileStorage) read(idx int) (*Block, error) {
+	entry := s.entries[idx]
+	b, _, err := readRecord(s.segments[entry.Segment], entry.Offset)
+	return b, err
+}
+
+// Get implements Storage.
+func (s *FileStorage) Get(height uint64) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	idx, ok := s.byHeight[height]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return s.read(idx)
+}
+
+// GetByHash implements Storage.
+func (s *FileStorage) GetByHash(hash types.Hash) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	idx, ok := s.byHash[hash]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return s.read(idx)
+}
+
+// Has implements Storage.
+func (s *FileStorage) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	_, ok := s.byHash[hash]
+	return ok
+}
+
+// Iterate implements Storage.
+func (s *FileStorage) Iterate(fn func(b *Block) error) error {
+	s.lock.RLock()
+	count := len(s.entries)
+	s.lock.RUnlock()
+	for i := 0; i < count; i++ {
+		s.lock.RLock()
+		b, err := s.read(i)
+		s.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+		if err = fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements Storage.
+func (s *FileStorage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var errs []error
+	for id, f := range s.segments {
+		errs = append(errs, f.Close())
+		delete(s.segments, id)
+	}
+	if s.index != nil {
+		errs = append(errs, s.index.Close())
+		s.index = nil
+	}
+	if s.wal != nil {
+		errs = append(errs, s.wal.Close())
+		s.wal = nil
+	}
+	return errors.Join(errs...)
+}
+
+var _ Storage = (*FileStorage)(nil)
+
+var (
+	ErrChecksumMismatch = errors.New("record checksum mismatch")
+	ErrSegmentCorrupted = errors.New("segment corrupted")
+)
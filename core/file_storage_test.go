@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matrix-go/block/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage_PutAndGet(t *testing.T) {
+	storage, err := NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	defer storage.Close()
+
+	prevHash := types.Hash{}
+	blocks := make([]*Block, 0)
+	for i := 0; i < 10; i++ {
+		b := randomBlockWithSignature(uint64(i), prevHash)
+		require.NoError(t, storage.Put(b))
+		prevHash = NewHeaderHasher().Hash(b.Header)
+		blocks = append(blocks, b)
+	}
+
+	for i, b := range blocks {
+		hash := NewHeaderHasher().Hash(b.Header)
+		assert.True(t, storage.Has(hash))
+
+		byHeight, err := storage.Get(uint64(i))
+		require.NoError(t, err)
+		assert.Equal(t, hash, NewHeaderHasher().Hash(byHeight.Header))
+
+		byHash, err := storage.GetByHash(hash)
+		require.NoError(t, err)
+		assert.Equal(t, b.Height, byHash.Height)
+		assert.Equal(t, b.Signature, byHash.Signature)
+	}
+
+	_, err = storage.Get(10)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+	assert.False(t, storage.Has(types.RandomHash()))
+}
+
+func TestFileStorage_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	// small segments to force rotation
+	storage, err := newFileStorage(dir, 512)
+	require.NoError(t, err)
+
+	prevHash := types.Hash{}
+	for i := 0; i < 20; i++ {
+		b := randomBlockWithSignature(uint64(i), prevHash)
+		require.NoError(t, storage.Put(b))
+		prevHash = NewHeaderHasher().Hash(b.Header)
+	}
+	assert.Greater(t, len(storage.segments), 1)
+	require.NoError(t, storage.Close())
+
+	storage, err = newFileStorage(dir, 512)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	var height uint64
+	err = storage.Iterate(func(b *Block) error {
+		assert.Equal(t, height, b.Height)
+		height++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), height)
+	assert.True(t, storage.Has(prevHash))
+}
+
+func TestFileStorage_RebuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	require.NoError(t, err)
+
+	prevHash := types.Hash{}
+	for i := 0; i < 5; i++ {
+		b := randomBlockWithSignature(uint64(i), prevHash)
+		require.NoError(t, storage.Put(b))
+		prevHash = NewHeaderHasher().Hash(b.Header)
+	}
+	// lose the last two index entries
+	require.NoError(t, storage.index.Truncate(3*indexEntryLen))
+	require.NoError(t, storage.Close())
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer storage.Close()
+	assert.Len(t, storage.entries, 5)
+	b, err := storage.Get(4)
+	require.NoError(t, err)
+	assert.Equal(t, prevHash, NewHeaderHasher().Hash(b.Header))
+}
+
+func TestFileStorage_RecoverTornSegment(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	prevHash := types.Hash{}
+	for i := 0; i < 3; i++ {
+		b := randomBlockWithSignature(uint64(i), prevHash)
+		require.NoError(t, storage.Put(b))
+		prevHash = NewHeaderHasher().Hash(b.Header)
+	}
+	size := storage.activeSize
+	require.NoError(t, storage.Close())
+
+	// the last record was only partially written
+	require.NoError(t, os.Truncate(filepath.Join(dir, "000000.seg"), size-10))
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer storage.Close()
+	assert.Len(t, storage.entries, 2)
+	assert.False(t, storage.Has(prevHash))
+
+	b, err := storage.Get(1)
+	require.NoError(t, err)
+	next := randomBlockWithSignature(2, NewHeaderHasher().Hash(b.Header))
+	require.NoError(t, storage.Put(next))
+	_, err = storage.Get(2)
+	require.NoError(t, err)
+}
+
+func TestFileStorage_ReplayWAL(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	require.NoError(t, storage.Put(genesis))
+
+	// crash right after the commit was logged
+	b := randomBlockWithSignature(1, NewHeaderHasher().Hash(genesis.Header))
+	var buf bytes.Buffer
+	require.NoError(t, b.Encode(NewGobBlockEncoder(&buf)))
+	_, err = storage.wal.Begin(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer storage.Close()
+	assert.True(t, storage.Has(NewHeaderHasher().Hash(b.Header)))
+	assert.Equal(t, int64(0), storage.wal.Size())
+}
+
+func TestFileStorage_CorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := newFileStorage(dir, 512)
+	require.NoError(t, err)
+	prevHash := types.Hash{}
+	for i := 0; i < 10; i++ {
+		b := randomBlockWithSignature(uint64(i), prevHash)
+		require.NoError(t, storage.Put(b))
+		prevHash = NewHeaderHasher().Hash(b.Header)
+	}
+	require.Greater(t, len(storage.segments), 1)
+	require.NoError(t, storage.Close())
+
+	path := filepath.Join(dir, "000000.seg")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[recordHeaderLen+5] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	// an indexed record is verified when it is read
+	storage, err = newFileStorage(dir, 512)
+	require.NoError(t, err)
+	_, err = storage.Get(0)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	require.NoError(t, storage.Close())
+
+	// without the index every record is verified on open
+	require.NoError(t, os.Remove(filepath.Join(dir, indexFileName)))
+	_, err = newFileStorage(dir, 512)
+	assert.ErrorIs(t, err, ErrSegmentCorrupted)
+}
+
+func TestFileStorage_LoadIndex(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := newFileStorage(dir, 512)
+	require.NoError(t, err)
+	prevHash := types.Hash{}
+	for i := 0; i < 10; i++ {
+		b := randomBlockWithSignature(uint64(i), prevHash)
+		require.NoError(t, storage.Put(b))
+		prevHash = NewHeaderHasher().Hash(b.Header)
+	}
+	entries := storage.entries
+	require.NoError(t, storage.Close())
+
+	// the entries come from the index, the segments are not read
+	index, err := os.ReadFile(filepath.Join(dir, indexFileName))
+	require.NoError(t, err)
+	segment := filepath.Join(dir, "000000.seg")
+	records, err := os.ReadFile(segment)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(segment, make([]byte, len(records)), 0o644))
+	storage, err = newFileStorage(dir, 512)
+	require.NoError(t, err)
+	assert.Equal(t, entries, storage.entries)
+	require.NoError(t, storage.Close())
+	data, err := os.ReadFile(filepath.Join(dir, indexFileName))
+	require.NoError(t, err)
+	assert.Equal(t, index, data)
+
+	// an entry past the end of its segment ends the index, the records
+	// after it are scanned
+	require.NoError(t, os.WriteFile(segment, records[:len(records)-1], 0o644))
+	_, err = newFileStorage(dir, 512)
+	assert.ErrorIs(t, err, ErrSegmentCorrupted)
+}
+
+func TestFileStorage_CanonicalHeight(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFileStorage(dir)
+	require.NoError(t, err)
+	genesis := randomBlockWithSignature(0, types.Hash{})
+	first := randomBlockWithSignature(1, NewHeaderHasher().Hash(genesis.Header))
+	second := randomBlockWithSignature(1, NewHeaderHasher().Hash(genesis.Header))
+	for _, b := range []*Block{genesis, first, second} {
+		require.NoError(t, storage.Put(b))
+	}
+	assertHeight := func(storage Storage, expected *Block) {
+		t.Helper()
+		b, err := storage.Get(1)
+		require.NoError(t, err)
+		assert.Equal(t, NewHeaderHasher().Hash(expected.Header), NewHeaderHasher().Hash(b.Header))
+	}
+	// the last block stored at a height is the one returned
+	assertHeight(storage, second)
+	require.NoError(t, storage.Put(first))
+	assertHeight(storage, first)
+	assert.Len(t, storage.entries, 3)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	assertHeight(storage, first)
+
+	// the blocks above a block put again belong to the branch it replaced
+	above := randomBlockWithSignature(2, NewHeaderHasher().Hash(first.Header))
+	require.NoError(t, storage.Put(above))
+	require.NoError(t, storage.Put(second))
+	assertHeight(storage, second)
+	_, err = storage.Get(2)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewFileStorage(dir)
+	require.NoError(t, err)
+	defer storage.Close()
+	assertHeight(storage, second)
+	_, err = storage.Get(2)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+	assert.Len(t, storage.entries, 4)
+
+	mem := NewMemStorage()
+	for _, b := range []*Block{genesis, first, second} {
+		require.NoError(t, mem.Put(b))
+	}
+	assertHeight(mem, second)
+	require.NoError(t, mem.Put(first))
+	assertHeight(mem, first)
+	require.NoError(t, mem.Put(above))
+	require.NoError(t, mem.Put(second))
+	assertHeight(mem, second)
+	_, err = mem.Get(2)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+}
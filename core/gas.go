@@ -0,0 +1,62 @@
+package core
+
+// Every byte the VM steps over costs GasStep, the bytes pushed by
+// InstructionPushN included, instructions cost the gas of instructionGas on
+// top of it.
+const (
+	GasStep uint64 = 1
+	// GasPackByte is paid for every byte that InstructionPack packs
+	GasPackByte uint64 = 1
+	// GasCodeByte is paid for every byte of the code of a deployed contract
+	GasCodeByte uint64 = 10
+	// GasLogByte is paid for every byte of the topic and data of a log
+	GasLogByte uint64 = 2
+	// GasHashWord is paid for every word hashed by InstructionSha256 and
+	// InstructionVerify, a partial word counting as one
+	GasHashWord uint64 = 6
+	// GasCallDataByte is paid for every byte pushed by InstructionCallData
+	GasCallDataByte uint64 = 1
+	// defaultBlockGasLimit is used by a genesis without gas limit
+	defaultBlockGasLimit uint64 = 10_000_000
+	// defaultMinGasPrice is used by a genesis without minimum gas price
+	defaultMinGasPrice uint64 = 1
+)
+
+var instructionGas = map[Instruction]uint64{
+	InstructionPushInt:     2,
+	InstructionPushByte:    2,
+	InstructionAdd:         3,
+	InstructionSub:         3,
+	InstructionMul:         5,
+	InstructionDiv:         5,
+	InstructionPack:        3,
+	InstructionStore:       100,
+	InstructionGet:         50,
+	InstructionJump:        8,
+	InstructionJumpIf:      10,
+	InstructionJumpDest:    1,
+	InstructionEq:          3,
+	InstructionLt:          3,
+	InstructionGt:          3,
+	InstructionNot:         3,
+	InstructionAnd:         3,
+	InstructionOr:          3,
+	InstructionDup:         3,
+	InstructionSwap:        3,
+	InstructionPop:         2,
+	InstructionCaller:      2,
+	InstructionValue:       2,
+	InstructionHeight:      2,
+	InstructionTimestamp:   2,
+	InstructionSelfBalance: 20,
+	InstructionLog:         20,
+	InstructionPushN:       3,
+	InstructionToInt:       3,
+	InstructionToBytes:     3,
+	InstructionSha256:      30,
+	InstructionVerify:      1000,
+	InstructionCall:        100,
+	InstructionTransfer:    100,
+	InstructionCallData:    3,
+	InstructionCallDataLen: 2,
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/matrix-go/block/crypto"
+)
+
+// Duration is a time.Duration written like "5s" in JSON
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+type GenesisCollection struct {
+	Fee      int64  `json:"fee"`
+	Metadata string `json:"metadata"`
+}
+
+// Genesis is the configuration every node of a network builds the same
+// genesis block from. Keys are hex encoded ed25519 public keys.
+type Genesis struct {
+	ChainID   uint64   `json:"chainId"`
+	Timestamp uint64   `json:"timestamp"`
+	BlockTime Duration `json:"blockTime"`
+	// GasLimit is the gas limit of every block, defaults to 10M
+	GasLimit uint64 `json:"gasLimit"`
+	// MinGasPrice is the lowest gas price transactions may pay, defaults to 1
+	MinGasPrice uint64 `json:"minGasPrice"`
+	// Validators are the keys allowed to sign blocks, anyone may when empty
+	Validators []string `json:"validators"`
+	// Alloc is the initial balance of accounts
+	Alloc       map[string]uint64   `json:"alloc"`
+	Collections []GenesisCollection `json:"collections"`
+	Issuance    Issuance            `json:"issuance"`
+}
+
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g := &Genesis{}
+	if err = json.Unmarshal(data, g); err != nil {
+		return nil, fmt.Errorf("decode genesis %s: %w", path, err)
+	}
+	if _, err = g.ValidatorKeys(); err != nil {
+		return nil, err
+	}
+	if err = g.Issuance.Validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func publicKeyFromHex(s string) (*crypto.PublicKey, error) {
+	key, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("public key %q: %w", s, ErrGenesisInvalidKey)
+	}
+	return &crypto.PublicKey{Key: key}, nil
+}
+
+func (g *Genesis) ValidatorKeys() ([]*crypto.PublicKey, error) {
+	keys := make([]*crypto.PublicKey, 0, len(g.Validators))
+	for _, validator := range g.Validators {
+		key, err := publicKeyFromHex(validator)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// MinimumGasPrice is the MinGasPrice of the chain, or its default
+func (g *Genesis) MinimumGasPrice() uint64 {
+	if g.MinGasPrice == 0 {
+		return defaultMinGasPrice
+	}
+	return g.MinGasPrice
+}
+
+// Block builds the genesis block. The allocations, ordered by key, and
+// the collections are unsigned transactions of the block, so that the
+// block alone gives the genesis state.
+func (g *Genesis) Block() (*Block, error) {
+	keys := make([]string, 0, len(g.Alloc))
+	for key := range g.Alloc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	gasLimit := g.GasLimit
+	if gasLimit == 0 {
+		gasLimit = defaultBlockGasLimit
+	}
+
+	block := NewBlock(&Header{
+		Version:   1,
+		ChainID:   g.ChainID,
+		Timestamp: g.Timestamp,
+		Height:    0,
+		GasLimit:  gasLimit,
+	})
+	for _, key := range keys {
+		to, err := publicKeyFromHex(key)
+		if err != nil {
+			return nil, err
+		}
+		tx := NewTransaction(nil)
+		tx.To = to
+		tx.Value = g.Alloc[key]
+		tx.ChainID = g.ChainID
+		block.AddTransaction(tx)
+	}
+	for _, collection := range g.Collections {
+		tx := NewTransaction(nil)
+		tx.InnerTx = &CollectionTx{
+			Fee:      collection.Fee,
+			Metadata: []byte(collection.Metadata),
+		}
+		tx.ChainID = g.ChainID
+		block.AddTransaction(tx)
+	}
+
+	var err error
+	if block.DataHash, err = CalculateDataHash(block.Transactions); err != nil {
+		return nil, err
+	}
+	bc := &Blockchain{
+		logger:  log.NewNopLogger(),
+		state:   NewWorldState(),
+		chainID: g.ChainID,
+	}
+	if _, err = bc.applyBlock(block); err != nil {
+		return nil, err
+	}
+	block.StateRoot = bc.state.Root()
+	return block, nil
+}
+
+var (
+	ErrGenesisInvalidKey = errors.New("genesis has an invalid public key")
+)
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matrix-go/block/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenesis_Block(t *testing.T) {
+	validatorKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	aliceKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	validator := hex.EncodeToString(validatorKey.PublicKey().Bytes())
+	alice := hex.EncodeToString(aliceKey.PublicKey().Bytes())
+
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+	"chainId": 7,
+	"timestamp": 1700000000,
+	"blockTime": "5s",
+	"validators": ["`+validator+`"],
+	"alloc": {"`+validator+`": 1000, "0x`+alice+`": 500},
+	"collections": [{"fee": 200, "metadata": "chicken and egg"}]
+}`), 0o644))
+	g, err := LoadGenesis(path)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, g.BlockTime.Duration)
+
+	// every node builds the same block from the file
+	block, err := g.Block()
+	require.NoError(t, err)
+	other, err := g.Block()
+	require.NoError(t, err)
+	var a, b bytes.Buffer
+	require.NoError(t, block.Encode(NewGobBlockEncoder(&a)))
+	require.NoError(t, other.Encode(NewGobBlockEncoder(&b)))
+	assert.Equal(t, a.Bytes(), b.Bytes())
+	assert.Equal(t, uint64(7), block.ChainID)
+	assert.Nil(t, block.Signature)
+
+	validators, err := g.ValidatorKeys()
+	require.NoError(t, err)
+	chain, err := NewBlockchain(BlockchainOpt{Genesis: block, Validators: validators})
+	require.NoError(t, err)
+	assert.Equal(t, block.StateRoot, chain.StateRoot())
+	balance, err := chain.GetBalance(validatorKey.PublicKey().Address())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), balance)
+	balance, err = chain.GetBalance(aliceKey.PublicKey().Address())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(500), balance)
+	assert.True(t, chain.state.collections.Has(block.Transactions[2].GetHash(NewTransactionHasher())))
+
+	// only the validators of the genesis sign blocks
+	b1, err := chain.ProposeBlock(aliceKey, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, chain.AddBlock(b1), ErrBlockValidatorUnknown)
+	b1, err = chain.ProposeBlock(validatorKey, nil)
+	require.NoError(t, err)
+	assert.NoError(t, chain.AddBlock(b1))
+}
+
+func TestGenesis_InvalidKey(t *testing.T) {
+	g := &Genesis{Alloc: map[string]uint64{"0xfoo": 1}}
+	_, err := g.Block()
+	assert.ErrorIs(t, err, ErrGenesisInvalidKey)
+	g = &Genesis{Validators: []string{"abcd"}}
+	_, err = g.ValidatorKeys()
+	assert.ErrorIs(t, err, ErrGenesisInvalidKey)
+}
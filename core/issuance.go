@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+type IssuanceSchedule string
+
+const (
+	// IssuanceFixed pays the same reward for every block
+	IssuanceFixed IssuanceSchedule = "fixed"
+	// IssuanceHalving halves the reward every Interval blocks
+	IssuanceHalving IssuanceSchedule = "halving"
+	// IssuanceDecay lowers the reward by DecayPercent every Interval blocks
+	IssuanceDecay IssuanceSchedule = "decay"
+)
+
+// Issuance is how much new money a validator may pay itself for a block,
+// no reward is paid when Schedule is empty.
+type Issuance struct {
+	Schedule IssuanceSchedule `json:"schedule"`
+	// Reward is the reward of the first blocks
+	Reward       uint64 `json:"reward"`
+	Interval     uint64 `json:"interval"`
+	DecayPercent uint64 `json:"decayPercent"`
+}
+
+// Validate reports an unknown schedule or one missing its parameters
+func (i Issuance) Validate() error {
+	switch i.Schedule {
+	case "", IssuanceFixed:
+		return nil
+	case IssuanceHalving:
+		if i.Interval == 0 {
+			return fmt.Errorf("%s issuance without interval: %w", i.Schedule, ErrIssuanceInvalid)
+		}
+		return nil
+	case IssuanceDecay:
+		if i.Interval == 0 || i.DecayPercent > 100 {
+			return fmt.Errorf("%s issuance with interval %d and decay %d%%: %w", i.Schedule, i.Interval, i.DecayPercent, ErrIssuanceInvalid)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown issuance schedule %q: %w", i.Schedule, ErrIssuanceInvalid)
+	}
+}
+
+// BlockReward returns the reward of the block at height
+func (i Issuance) BlockReward(height uint64) uint64 {
+	switch i.Schedule {
+	case IssuanceFixed:
+		return i.Reward
+	case IssuanceHalving:
+		halvings := height / i.Interval
+		if halvings >= 64 {
+			return 0
+		}
+		return i.Reward >> halvings
+	case IssuanceDecay:
+		if i.DecayPercent == 0 {
+			return i.Reward
+		}
+		reward := i.Reward
+		for n := height / i.Interval; n > 0 && reward > 0; n-- {
+			// reward*(100-DecayPercent) could overflow
+			reward = reward/100*(100-i.DecayPercent) + reward%100*(100-i.DecayPercent)/100
+		}
+		return reward
+	default:
+		return 0
+	}
+}
+
+var (
+	ErrIssuanceInvalid = errors.New("invalid issuance")
+)
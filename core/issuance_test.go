@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssuance_BlockReward(t *testing.T) {
+	tests := []struct {
+		name     string
+		issuance Issuance
+		rewards  map[uint64]uint64
+	}{
+		{
+			name:     "none",
+			issuance: Issuance{Reward: 50},
+			rewards:  map[uint64]uint64{0: 0, 1: 0},
+		},
+		{
+			name:     "fixed",
+			issuance: Issuance{Schedule: IssuanceFixed, Reward: 50},
+			rewards:  map[uint64]uint64{1: 50, 1_000_000: 50},
+		},
+		{
+			name:     "halving",
+			issuance: Issuance{Schedule: IssuanceHalving, Reward: 50, Interval: 10},
+			rewards:  map[uint64]uint64{1: 50, 9: 50, 10: 25, 25: 12, 1_000: 0},
+		},
+		{
+			name:     "decay",
+			issuance: Issuance{Schedule: IssuanceDecay, Reward: 1000, Interval: 10, DecayPercent: 10},
+			rewards:  map[uint64]uint64{1: 1000, 10: 900, 20: 810, 1_000_000_000: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.issuance.Validate())
+			for height, reward := range tt.rewards {
+				assert.Equal(t, reward, tt.issuance.BlockReward(height), "height %d", height)
+			}
+		})
+	}
+}
+
+func TestIssuance_Validate(t *testing.T) {
+	for _, issuance := range []Issuance{
+		{Schedule: "inflation"},
+		{Schedule: IssuanceHalving, Reward: 50},
+		{Schedule: IssuanceDecay, Reward: 50, Interval: 10, DecayPercent: 101},
+	} {
+		assert.ErrorIs(t, issuance.Validate(), ErrIssuanceInvalid)
+	}
+}
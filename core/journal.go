@@ -0,0 +1,58 @@
+package core
+
+import "sync"
+
+// Journal records how to undo every state change, so that the changes made
+// since a snapshot can be reverted as a unit.
+type Journal struct {
+	lock    sync.Mutex
+	entries []func()
+}
+
+func NewJournal() *Journal {
+	return &Journal{
+		entries: make([]func(), 0),
+	}
+}
+
+func (j *Journal) record(undo func()) {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.entries = append(j.entries, undo)
+}
+
+// Snapshot returns an id that RevertToSnapshot can roll back to
+func (j *Journal) Snapshot() int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return len(j.entries)
+}
+
+// RevertToSnapshot undoes every change recorded after the snapshot id
+func (j *Journal) RevertToSnapshot(id int) {
+	j.lock.Lock()
+	entries := j.entries[id:]
+	j.entries = j.entries[:id]
+	j.lock.Unlock()
+	undo(entries)
+}
+
+// Commit forgets the recorded changes and hands them back, so that the
+// caller can still undo them later
+func (j *Journal) Commit() []func() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	entries := j.entries
+	j.entries = make([]func(), 0)
+	return entries
+}
+
+// undo runs the journal entries in reverse order
+func undo(entries []func()) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i]()
+	}
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/matrix-go/block/types"
+)
+
+const (
+	merkleLeafPrefix byte = 0x00
+	merkleNodePrefix byte = 0x01
+)
+
+// MerkleSibling is one step of a proof, Left tells whether the sibling
+// hash goes on the left of the running hash
+type MerkleSibling struct {
+	Hash types.Hash
+	Left bool
+}
+
+type MerkleProof struct {
+	Index    int
+	Siblings []MerkleSibling
+}
+
+func merkleLeaf(h types.Hash) types.Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, h.Bytes()...))
+}
+
+func merkleNode(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left.Bytes()...)
+	buf = append(buf, right.Bytes()...)
+	return sha256.Sum256(buf)
+}
+
+// merkleLevel hashes the nodes of one level in pairs, a node without a
+// pair is carried to the next level as it is
+func merkleLevel(nodes []types.Hash) []types.Hash {
+	next := make([]types.Hash, 0, (len(nodes)+1)/2)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 == len(nodes) {
+			next = append(next, nodes[i])
+			continue
+		}
+		next = append(next, merkleNode(nodes[i], nodes[i+1]))
+	}
+	return next
+}
+
+// MerkleRoot returns the root of the binary merkle tree over leaves,
+// the root of no leaves is zero
+func MerkleRoot(leaves []types.Hash) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+	nodes := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		nodes[i] = merkleLeaf(leaf)
+	}
+	for len(nodes) > 1 {
+		nodes = merkleLevel(nodes)
+	}
+	return nodes[0]
+}
+
+// BuildMerkleProof proves that leaves[index] is part of MerkleRoot(leaves)
+func BuildMerkleProof(leaves []types.Hash, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, ErrMerkleIndexOutOfRange
+	}
+	nodes := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		nodes[i] = merkleLeaf(leaf)
+	}
+	proof := &MerkleProof{
+		Index:    index,
+		Siblings: make([]MerkleSibling, 0),
+	}
+	for pos := index; len(nodes) > 1; pos /= 2 {
+		if pos%2 == 1 {
+			proof.Siblings = append(proof.Siblings, MerkleSibling{Hash: nodes[pos-1], Left: true})
+		} else if pos+1 < len(nodes) {
+			proof.Siblings = append(proof.Siblings, MerkleSibling{Hash: nodes[pos+1]})
+		}
+		nodes = merkleLevel(nodes)
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that leaf is part of the tree with the given root
+func VerifyMerkleProof(root, leaf types.Hash, proof *MerkleProof) bool {
+	if proof == nil {
+		return false
+	}
+	h := merkleLeaf(leaf)
+	for _, sibling := range proof.Siblings {
+		if sibling.Left {
+			h = merkleNode(sibling.Hash, h)
+		} else {
+			h = merkleNode(h, sibling.Hash)
+		}
+	}
+	return h == root
+}
+
+var (
+	ErrMerkleIndexOutOfRange = errors.New("merkle leaf index out of range")
+)
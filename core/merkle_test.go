@@ -0,0 +1,53 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/matrix-go/block/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleProof(t *testing.T) {
+	assert.True(t, MerkleRoot(nil).IsZero())
+
+	for n := 1; n <= 17; n++ {
+		leaves := make([]types.Hash, n)
+		for i := range leaves {
+			leaves[i] = types.RandomHash()
+		}
+		root := MerkleRoot(leaves)
+		for i, leaf := range leaves {
+			proof, err := BuildMerkleProof(leaves, i)
+			require.NoError(t, err)
+			assert.True(t, VerifyMerkleProof(root, leaf, proof), "leaves %d index %d", n, i)
+			assert.False(t, VerifyMerkleProof(root, types.RandomHash(), proof))
+		}
+	}
+
+	_, err := BuildMerkleProof([]types.Hash{types.RandomHash()}, 1)
+	assert.ErrorIs(t, err, ErrMerkleIndexOutOfRange)
+}
+
+func TestMerkleRootOrderMatters(t *testing.T) {
+	a, b := types.RandomHash(), types.RandomHash()
+	assert.NotEqual(t, MerkleRoot([]types.Hash{a, b}), MerkleRoot([]types.Hash{b, a}))
+	// a single leaf is not its own root, so a leaf cannot pass for a node
+	assert.NotEqual(t, a, MerkleRoot([]types.Hash{a}))
+}
+
+func TestBlockTransactionProof(t *testing.T) {
+	b := randomBlock(1, types.Hash{})
+	for i := 0; i < 5; i++ {
+		b.AddTransaction(randomTxWithSignature())
+	}
+	var err error
+	b.DataHash, err = CalculateDataHash(b.Transactions)
+	require.NoError(t, err)
+
+	for i, tx := range b.Transactions {
+		proof, err := b.TransactionProof(i)
+		require.NoError(t, err)
+		assert.True(t, VerifyMerkleProof(b.DataHash, tx.GetHash(NewTransactionHasher()), proof))
+	}
+}
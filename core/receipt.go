@@ -0,0 +1,56 @@
+package core
+
+import (
+	"math/big"
+
+	"github.com/matrix-go/block/types"
+)
+
+type ReceiptStatus uint8
+
+const (
+	ReceiptStatusFailed ReceiptStatus = iota
+	ReceiptStatusSuccess
+)
+
+// Log is an event emitted by a contract with InstructionLog
+type Log struct {
+	Contract types.Address `json:"contract"`
+	Topic    []byte        `json:"topic"`
+	Data     []byte        `json:"data"`
+}
+
+// Receipt is the outcome of a transaction included in a block. A failed
+// transaction stays in its block: its sender pays the fee and uses the
+// nonce but every other change of the transaction is reverted.
+type Receipt struct {
+	TxHash    types.Hash    `json:"txHash"`
+	BlockHash types.Hash    `json:"blockHash"`
+	Height    uint64        `json:"height"`
+	Status    ReceiptStatus `json:"status"`
+	GasUsed   uint64        `json:"gasUsed"`
+	// Contract is the address of the contract deployed by the transaction
+	Contract *types.Address `json:"contract,omitempty"`
+	// Return is the value given to InstructionReturn
+	Return []byte `json:"return,omitempty"`
+	Logs   []*Log `json:"logs"`
+	// Err is why the transaction failed
+	Err string `json:"error,omitempty"`
+}
+
+func (r *Receipt) Failed() bool {
+	return r.Status == ReceiptStatusFailed
+}
+
+// valueBytes encodes a value of the VM stack for a receipt or the storage,
+// ints as words
+func valueBytes(v any) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case *big.Int:
+		return word(v)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/matrix-go/block/types"
+)
+
+// Simulation is the outcome of a transaction executed without committing it
+type Simulation struct {
+	Receipt *Receipt   `json:"receipt"`
+	Diff    *StateDiff `json:"diff"`
+}
+
+// StateDiff is what executing a transaction changed in the world state
+type StateDiff struct {
+	Accounts []*AccountDiff `json:"accounts"`
+	Storage  []*StorageDiff `json:"storage"`
+	// Code are the addresses of the deployed contracts
+	Code []types.Address `json:"code"`
+}
+
+// AccountDiff is an account before and after the transaction, nil when the
+// account does not exist
+type AccountDiff struct {
+	Address types.Address `json:"address"`
+	Before  *Account      `json:"before"`
+	After   *Account      `json:"after"`
+}
+
+// StorageDiff is a key of the storage of a contract before and after the
+// transaction, nil when the key is not set
+type StorageDiff struct {
+	Contract types.Address `json:"contract"`
+	Key      []byte        `json:"key"`
+	Before   []byte        `json:"before"`
+	After    []byte        `json:"after"`
+}
+
+// Simulate executes tx on top of the tip against a copy of the state, so
+// that nothing is committed. The fee goes to the validator of the tip. It
+// returns an error when tx could not be part of the next block.
+func (bc *Blockchain) Simulate(tx *Transaction) (*Simulation, error) {
+	return bc.simulate(tx, false)
+}
+
+// Call executes tx like Simulate, but as a read of the chain: the nonce,
+// the gas price and the balance of the sender are not checked and no fee
+// is paid. Its gas price is 0 and its gas limit defaults to the gas limit
+// of the block.
+func (bc *Blockchain) Call(tx *Transaction) (*Simulation, error) {
+	return bc.simulate(tx, true)
+}
+
+func (bc *Blockchain) simulate(tx *Transaction, call bool) (*Simulation, error) {
+	if tx.From == nil {
+		return nil, ErrTransactionNotSigned
+	}
+	bc.stateLock.Lock()
+	bc.lock.RLock()
+	tip := bc.tip
+	bc.lock.RUnlock()
+	before := bc.state.Copy()
+	bc.stateLock.Unlock()
+	if tip == nil {
+		return nil, fmt.Errorf("blockchain without genesis block")
+	}
+
+	block, err := NewBlockWithPrevHeader(tip.block.Header, nil)
+	if err != nil {
+		return nil, err
+	}
+	block.Validator = tip.block.Validator
+	if call {
+		// the transaction of the caller is left untouched
+		cp := *tx
+		tx = &cp
+		tx.GasPrice = 0
+		if tx.GasLimit == 0 {
+			tx.GasLimit = block.GasLimit
+		}
+	}
+	if tx.GasLimit > block.GasLimit {
+		return nil, fmt.Errorf("transaction gas limit %d, block gas limit %d: %w", tx.GasLimit, block.GasLimit, ErrBlockGasLimitReached)
+	}
+	after := before.Copy()
+	var receipt *Receipt
+	if call {
+		receipt, err = bc.executeTransaction(after, tx, block, nil)
+	} else {
+		receipt, err = bc.applyTransaction(after, tx, block, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	receipt.Height = block.Height
+	diff, err := after.diff(before)
+	if err != nil {
+		return nil, err
+	}
+	return &Simulation{Receipt: receipt, Diff: diff}, nil
+}
+
+// diff returns what changed from before to ws, only the parts of the
+// tries that differ are visited
+func (ws *WorldState) diff(before *WorldState) (*StateDiff, error) {
+	diff := &StateDiff{
+		Accounts: make([]*AccountDiff, 0),
+		Storage:  make([]*StorageDiff, 0),
+		Code:     make([]types.Address, 0),
+	}
+	if err := ws.accounts.diff(before.accounts, func(addr types.Address, prev, next *Account) {
+		diff.Accounts = append(diff.Accounts, &AccountDiff{Address: addr, Before: prev, After: next})
+	}); err != nil {
+		return nil, err
+	}
+	ws.contracts.diff(before.contracts, func(k, prev, next []byte) {
+		// the keys of the contract storage are prefixed by its address
+		diff.Storage = append(diff.Storage, &StorageDiff{
+			Contract: types.AddressFromBytes(k[:20]),
+			Key:      k[20:],
+			Before:   prev,
+			After:    next,
+		})
+	})
+	ws.code.diff(before.code, func(k, _, _ []byte) {
+		diff.Code = append(diff.Code, types.AddressFromBytes(k))
+	})
+	return diff, nil
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/matrix-go/block/crypto"
+	"github.com/matrix-go/block/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockchain_Simulate(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	alice, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	bob, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	aliceAddr := alice.PublicKey().Address()
+	require.NoError(t, chain.state.accounts.AddBalance(aliceAddr, 100))
+	root := chain.StateRoot()
+
+	// store 3-2 under FOO and send 10 to bob, unsigned
+	tx := NewTransaction(storeTx(t, "FOO").Data)
+	tx.From = alice.PublicKey()
+	tx.To = bob.PublicKey()
+	tx.Value = 10
+	tx.GasLimit = testGas
+	simulation, err := chain.Simulate(tx)
+	require.NoError(t, err)
+	assert.False(t, simulation.Receipt.Failed())
+	assert.NotZero(t, simulation.Receipt.GasUsed)
+	assert.Equal(t, uint64(1), simulation.Receipt.Height)
+
+	require.Len(t, simulation.Diff.Accounts, 2)
+	for _, account := range simulation.Diff.Accounts {
+		switch account.Address {
+		case aliceAddr:
+			assert.Equal(t, &Account{Address: aliceAddr, Balance: 100}, account.Before)
+			assert.Equal(t, &Account{Address: aliceAddr, Balance: 90, Nonce: 1}, account.After)
+		case bob.PublicKey().Address():
+			assert.Nil(t, account.Before)
+			assert.Equal(t, uint64(10), account.After.Balance)
+		default:
+			t.Fatalf("unexpected account %s", account.Address)
+		}
+	}
+	require.Len(t, simulation.Diff.Storage, 1)
+	assert.Equal(t, aliceAddr, simulation.Diff.Storage[0].Contract)
+	assert.Equal(t, []byte("FOO"), simulation.Diff.Storage[0].Key)
+	assert.Nil(t, simulation.Diff.Storage[0].Before)
+	assert.Equal(t, int64(1), new(big.Int).SetBytes(simulation.Diff.Storage[0].After).Int64())
+	assert.Empty(t, simulation.Diff.Code)
+
+	// nothing was committed
+	assert.Equal(t, root, chain.StateRoot())
+	nonce, err := chain.GetNonce(aliceAddr)
+	require.NoError(t, err)
+	assert.Zero(t, nonce)
+	_, err = stored(chain, tx, "FOO")
+	assert.Error(t, err)
+
+	// a deployment reports the code of the contract
+	deploy := NewTransaction(nil)
+	deploy.From = alice.PublicKey()
+	deploy.InnerTx = &DeployTx{Code: []byte{0x01, 0x0a}}
+	deploy.GasLimit = testGas
+	simulation, err = chain.Simulate(deploy)
+	require.NoError(t, err)
+	assert.Equal(t, []types.Address{ContractAddress(aliceAddr, 0)}, simulation.Diff.Code)
+
+	// a failing contract only uses the nonce
+	failed := NewTransaction([]byte{0x01, 0x0a, 0x00, 0x0a, 0x12})
+	failed.From = alice.PublicKey()
+	failed.GasLimit = testGas
+	simulation, err = chain.Simulate(failed)
+	require.NoError(t, err)
+	assert.True(t, simulation.Receipt.Failed())
+	assert.Contains(t, simulation.Receipt.Err, ErrDivisionByZero.Error())
+	require.Len(t, simulation.Diff.Accounts, 1)
+	assert.Empty(t, simulation.Diff.Storage)
+
+	// transactions that cannot be part of a block are rejected
+	tx.Nonce = 1
+	_, err = chain.Simulate(tx)
+	assert.ErrorIs(t, err, ErrNonceTooHigh)
+	tx.From = nil
+	_, err = chain.Simulate(tx)
+	assert.ErrorIs(t, err, ErrTransactionNotSigned)
+}
+
+func TestBlockchain_Call(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	chain.minGasPrice = 1
+	alice, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	root := chain.StateRoot()
+
+	// alice has no account, any nonce and gas price are accepted
+	tx := NewTransaction(storeTx(t, "FOO").Data)
+	tx.From = alice.PublicKey()
+	tx.Nonce = 5
+	tx.GasPrice = 3
+	_, err = chain.Simulate(tx)
+	assert.ErrorIs(t, err, ErrNonceTooHigh)
+	simulation, err := chain.Call(tx)
+	require.NoError(t, err)
+	assert.False(t, simulation.Receipt.Failed())
+	assert.NotZero(t, simulation.Receipt.GasUsed)
+	assert.Empty(t, simulation.Diff.Accounts)
+	require.Len(t, simulation.Diff.Storage, 1)
+	assert.Equal(t, []byte("FOO"), simulation.Diff.Storage[0].Key)
+	assert.Equal(t, uint64(3), tx.GasPrice)
+	assert.Zero(t, tx.GasLimit)
+	assert.Equal(t, root, chain.StateRoot())
+
+	tx.GasLimit = math.MaxUint64
+	_, err = chain.Call(tx)
+	assert.ErrorIs(t, err, ErrBlockGasLimitReached)
+}
+
+func TestWorldState_Copy(t *testing.T) {
+	state := NewWorldState()
+	require.NoError(t, state.contracts.Put([]byte("k"), []byte("v")))
+	cp := state.Copy()
+	require.NoError(t, cp.contracts.Put([]byte("k"), []byte("w")))
+	require.NoError(t, cp.accounts.AddBalance(types.Address{1}, 5))
+
+	v, err := state.contracts.Get([]byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), v)
+	_, err = state.accounts.GetAccount(types.Address{1})
+	assert.ErrorIs(t, err, ErrAccountNotFound)
+
+	// the journal of the copy is its own
+	state.RevertToSnapshot(0)
+	v, err = cp.contracts.Get([]byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("w"), v)
+}
+
+func TestWorldState_CopyCollections(t *testing.T) {
+	state := NewWorldState()
+	a, b := types.RandomHash(), types.RandomHash()
+	require.NoError(t, state.collections.Put(a, &CollectionTx{Fee: 1}))
+	cp := state.Copy()
+
+	require.NoError(t, cp.collections.Put(b, &CollectionTx{Fee: 2}))
+	require.NoError(t, state.collections.Put(a, &CollectionTx{Fee: 3}))
+	assert.False(t, state.collections.Has(b))
+	collection, ok, err := cp.collections.Get(a)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), collection.Fee)
+
+	// undoing a change of one store leaves the other alone
+	other := cp.Copy()
+	cp.RevertToSnapshot(0)
+	assert.False(t, cp.collections.Has(b))
+	assert.True(t, other.collections.Has(b))
+}
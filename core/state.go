@@ -2,28 +2,95 @@ package core
 
 import (
 	"fmt"
+	"sync"
+
+	"github.com/matrix-go/block/trie"
+	"github.com/matrix-go/block/types"
 )
 
 type State struct {
-	data map[string][]byte
+	lock    sync.RWMutex
+	trie    *trie.Trie
+	journal *Journal
 }
 
 func NewState() *State {
-	return &State{data: make(map[string][]byte)}
+	return &State{trie: trie.New()}
+}
+
+// setJournal makes every later change of the state revertible through journal
+func (s *State) setJournal(journal *Journal) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.journal = journal
+}
+
+// record keeps the current trie in the journal,
+// it must be called with the lock held.
+func (s *State) record() {
+	if s.journal == nil {
+		return
+	}
+	prev := s.trie.Copy()
+	s.journal.record(func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.trie = prev
+	})
+}
+
+// copy returns a state with the content of s whose changes are recorded
+// in journal
+func (s *State) copy(journal *Journal) *State {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &State{trie: s.trie.Copy(), journal: journal}
+}
+
+// iterate visits every key in ascending order until fn returns false
+func (s *State) iterate(fn func(k, v []byte) bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.trie.Iterate(fn)
+}
+
+// diff calls fn in ascending key order with every key whose value differs
+// between before and s, the value of a missing key is nil
+func (s *State) diff(before *State, fn func(k, prev, next []byte)) {
+	before.lock.RLock()
+	defer before.lock.RUnlock()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	trie.Diff(before.trie, s.trie, fn)
+}
+
+// Root returns the root hash of the state trie
+func (s *State) Root() types.Hash {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.trie.Hash()
 }
 
 func (s *State) Put(k, v []byte) error {
-	s.data[string(k)] = v
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.record()
+	s.trie.Put(k, v)
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
-	delete(s.data, string(k))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.record()
+	s.trie.Delete(k)
 	return nil
 }
 
 func (s *State) Get(k []byte) ([]byte, error) {
-	if v, ok := s.data[string(k)]; ok {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if v, ok := s.trie.Get(k); ok {
 		return v, nil
 	}
 	return nil, fmt.Errorf("key not found")
@@ -1,19 +1,110 @@
 package core
 
+import (
+	"errors"
+	"sync"
+
+	"github.com/matrix-go/block/types"
+)
+
 type Storage interface {
+	// Put stores b, which becomes the block at its height, the blocks above
+	// it are no longer at their heights. A block already stored only
+	// becomes the block at its height again.
 	Put(b *Block) error
+	// Get returns the block at height
+	Get(height uint64) (*Block, error)
+	GetByHash(hash types.Hash) (*Block, error)
+	Has(hash types.Hash) bool
+	// Iterate visits every stored block in the order it was put
+	Iterate(fn func(b *Block) error) error
+	Close() error
 }
 
 type MemStorage struct {
+	lock     sync.RWMutex
+	blocks   []*Block
+	byHash   map[types.Hash]*Block
+	byHeight map[uint64]*Block
+	// top is the highest height in byHeight
+	top uint64
 }
 
 func NewMemStorage() *MemStorage {
-	return &MemStorage{}
+	return &MemStorage{
+		blocks:   make([]*Block, 0),
+		byHash:   make(map[types.Hash]*Block),
+		byHeight: make(map[uint64]*Block),
+	}
 }
 
 // Put implements Storage.
 func (m *MemStorage) Put(b *Block) error {
+	hash := NewHeaderHasher().Hash(b.Header)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for h := b.Height + 1; h <= m.top; h++ {
+		delete(m.byHeight, h)
+	}
+	m.byHeight[b.Height] = b
+	m.top = b.Height
+	if _, exists := m.byHash[hash]; exists {
+		return nil
+	}
+	m.blocks = append(m.blocks, b)
+	m.byHash[hash] = b
+	return nil
+}
+
+// Get implements Storage.
+func (m *MemStorage) Get(height uint64) (*Block, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if b, ok := m.byHeight[height]; ok {
+		return b, nil
+	}
+	return nil, ErrBlockNotFound
+}
+
+// GetByHash implements Storage.
+func (m *MemStorage) GetByHash(hash types.Hash) (*Block, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if b, ok := m.byHash[hash]; ok {
+		return b, nil
+	}
+	return nil, ErrBlockNotFound
+}
+
+// Has implements Storage.
+func (m *MemStorage) Has(hash types.Hash) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	_, ok := m.byHash[hash]
+	return ok
+}
+
+// Iterate implements Storage.
+func (m *MemStorage) Iterate(fn func(b *Block) error) error {
+	m.lock.RLock()
+	blocks := make([]*Block, len(m.blocks))
+	copy(blocks, m.blocks)
+	m.lock.RUnlock()
+	for _, b := range blocks {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements Storage.
+func (m *MemStorage) Close() error {
 	return nil
 }
 
 var _ Storage = (*MemStorage)(nil)
+
+var (
+	ErrBlockNotFound = errors.New("block not found")
+)
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+
+	"github.com/matrix-go/block/types"
+)
+
+// Tracer follows the execution of contracts instruction by instruction.
+// The instructions of a called contract are traced between the
+// BeforeInstruction and the AfterInstruction of the InstructionCall.
+type Tracer interface {
+	// BeforeInstruction is called with the step about to run
+	BeforeInstruction(step *Step)
+	// AfterInstruction is called with the step once it ran, its GasCost,
+	// Storage and Err set
+	AfterInstruction(step *Step)
+}
+
+// Step is an instruction run by the VM
+type Step struct {
+	// Depth is the number of calls between the transaction and Contract
+	Depth    int
+	Contract types.Address
+	IP       int
+	Op       Instruction
+	// Gas is the gas left before the instruction, GasCost what it used
+	Gas     uint64
+	GasCost uint64
+	// Stack is a copy of the stack before the instruction, bottom first
+	Stack   []any
+	Storage []*StorageAccess
+	Err     error
+}
+
+// StorageAccess is a read or a write of the storage of the contract, Value
+// is nil for a key read but not found
+type StorageAccess struct {
+	Key   []byte
+	Value []byte
+	Write bool
+}
+
+type stepJSON struct {
+	Depth    int                  `json:"depth"`
+	Contract string               `json:"contract"`
+	IP       int                  `json:"ip"`
+	Op       string               `json:"op"`
+	Gas      uint64               `json:"gas"`
+	GasCost  uint64               `json:"gasCost"`
+	Stack    []string             `json:"stack"`
+	Storage  []*storageAccessJSON `json:"storage,omitempty"`
+	Err      string               `json:"error,omitempty"`
+}
+
+type storageAccessJSON struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+	Write bool    `json:"write"`
+}
+
+// MarshalJSON writes ints of the stack in decimal and strings in hex with a
+// 0x prefix
+func (s *Step) MarshalJSON() ([]byte, error) {
+	out := stepJSON{
+		Depth:    s.Depth,
+		Contract: "0x" + s.Contract.String(),
+		IP:       s.IP,
+		Op:       s.Op.String(),
+		Gas:      s.Gas,
+		GasCost:  s.GasCost,
+		Stack:    make([]string, len(s.Stack)),
+	}
+	for i, v := range s.Stack {
+		out.Stack[i] = traceValue(v)
+	}
+	for _, access := range s.Storage {
+		a := &storageAccessJSON{Key: traceValue(access.Key), Write: access.Write}
+		if access.Value != nil {
+			value := traceValue(access.Value)
+			a.Value = &value
+		}
+		out.Storage = append(out.Storage, a)
+	}
+	if s.Err != nil {
+		out.Err = s.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+func traceValue(v any) string {
+	switch v := v.(type) {
+	case *big.Int:
+		return v.String()
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	default:
+		return ""
+	}
+}
+
+// JSONTracer writes every step as a line of JSON once it ran
+type JSONTracer struct {
+	enc *json.Encoder
+	err error
+}
+
+var _ Tracer = (*JSONTracer)(nil)
+
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+func (t *JSONTracer) BeforeInstruction(*Step) {}
+
+func (t *JSONTracer) AfterInstruction(step *Step) {
+	if t.err == nil {
+		t.err = t.enc.Encode(step)
+	}
+}
+
+// Err is the first error writing the steps
+func (t *JSONTracer) Err() error {
+	return t.err
+}
+
+// TraceTransaction executes again the transaction of the canonical chain
+// with the given hash, followed by tracer, and returns its receipt. The
+// blocks since the closest kept state are executed again against a copy of
+// it, then the transactions of its block up to it.
+func (bc *Blockchain) TraceTransaction(hash types.Hash, tracer Tracer) (*Receipt, error) {
+	bc.txLock.RLock()
+	location, ok := bc.txLookup[hash]
+	bc.txLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("transaction not found")
+	}
+	state, replay, err := bc.stateBefore(location.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %s: %w", hash, err)
+	}
+	block := replay[len(replay)-1]
+	if location.Index >= len(block.Transactions) {
+		return nil, fmt.Errorf("transaction %s not found in block %s", hash, location.BlockHash)
+	}
+
+	for _, b := range replay[:len(replay)-1] {
+		for _, tx := range b.Transactions {
+			if _, err := bc.applyTransaction(state, tx, b, nil); err != nil {
+				return nil, fmt.Errorf("replay block %d: %w", b.Height, err)
+			}
+		}
+	}
+	for _, tx := range block.Transactions[:location.Index] {
+		if _, err := bc.applyTransaction(state, tx, block, nil); err != nil {
+			return nil, fmt.Errorf("replay block %d: %w", block.Height, err)
+		}
+	}
+	receipt, err := bc.applyTransaction(state, block.Transactions[location.Index], block, tracer)
+	if err != nil {
+		return nil, err
+	}
+	receipt.BlockHash = location.BlockHash
+	receipt.Height = location.Height
+	return receipt, nil
+}
+
+// stateBefore returns a copy of the closest state kept before the canonical
+// block with the given hash, and the blocks from there up to that block,
+// oldest first
+func (bc *Blockchain) stateBefore(hash types.Hash) (*WorldState, []*Block, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+	node, ok := bc.nodes[hash]
+	if !ok || node.height() >= uint64(len(bc.blocks)) || bc.blocks[node.height()] != node.block {
+		return nil, nil, fmt.Errorf("block %s is not in the canonical chain", hash)
+	}
+	replay := []*Block{node.block}
+	for n := node.parent; n != nil; n = n.parent {
+		if n.state != nil {
+			slices.Reverse(replay)
+			return n.state.Copy(), replay, nil
+		}
+		replay = append(replay, n.block)
+	}
+	slices.Reverse(replay)
+	return NewWorldState(), replay, nil
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matrix-go/block/crypto"
+	"github.com/matrix-go/block/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder keeps the steps once they ran
+type recorder struct {
+	before int
+	steps  []*Step
+}
+
+func (r *recorder) BeforeInstruction(*Step) { r.before++ }
+
+func (r *recorder) AfterInstruction(step *Step) { r.steps = append(r.steps, step) }
+
+func TestVM_Tracer(t *testing.T) {
+	// store 1 under K, get it back then divide by zero
+	data := []byte{
+		0x01, 0x0a, 'K', 0x0c, 0x0f,
+		'K', 0x0c, 0x10, 0x28,
+		0x00, 0x0a, 0x12,
+	}
+	tracer := &recorder{}
+	vm := NewVM(data, NewState(), testGas, nil)
+	vm.SetTracer(tracer)
+	assert.ErrorIs(t, vm.Run(), ErrDivisionByZero)
+
+	require.Len(t, tracer.steps, 8)
+	assert.Equal(t, 8, tracer.before)
+	ops := make([]Instruction, len(tracer.steps))
+	for i, step := range tracer.steps {
+		ops[i] = step.Op
+	}
+	assert.Equal(t, []Instruction{
+		InstructionPushInt, InstructionPushByte, InstructionStore,
+		InstructionPushByte, InstructionGet, InstructionToInt, InstructionPushInt, InstructionDiv,
+	}, ops)
+
+	push := tracer.steps[0]
+	assert.Equal(t, 1, push.IP)
+	assert.Equal(t, uint64(testGas), push.Gas)
+	assert.Equal(t, 2*GasStep+instructionGas[InstructionPushInt], push.GasCost)
+	assert.Empty(t, push.Stack)
+	store := tracer.steps[2]
+	assert.Equal(t, []any{big.NewInt(1), []byte("K")}, store.Stack)
+	assert.Equal(t, []*StorageAccess{{Key: []byte("K"), Value: word(big.NewInt(1)), Write: true}}, store.Storage)
+	get := tracer.steps[4]
+	assert.Equal(t, []*StorageAccess{{Key: []byte("K"), Value: word(big.NewInt(1))}}, get.Storage)
+	div := tracer.steps[7]
+	assert.ErrorIs(t, div.Err, ErrDivisionByZero)
+	assert.Equal(t, testGas-vm.GasLeft(), push.Gas-div.Gas+div.GasCost)
+
+	// the called contracts are traced with their depth
+	caller, callee := types.Address{0xa1}, types.Address{0xb1}
+	state := NewWorldState()
+	require.NoError(t, state.code.Put(callee.Bytes(), []byte{0x07, 0x0a, 0x20}))
+	call := slices.Concat([]byte{0x00, 0x0a, 0x0d, 0xff, 0x0a, 0x00, 0x0a}, pushBytes(callee.Bytes()), []byte{0x2c})
+	tracer = &recorder{}
+	vm = NewVM(call, NewState(), testGas, &VMContext{Contract: caller, State: state})
+	vm.SetTracer(tracer)
+	require.NoError(t, vm.Run())
+	n := len(tracer.steps)
+	require.Greater(t, n, 3)
+	assert.Equal(t, InstructionPushInt, tracer.steps[n-3].Op)
+	assert.Equal(t, 1, tracer.steps[n-3].Depth)
+	assert.Equal(t, callee, tracer.steps[n-2].Contract)
+	assert.Equal(t, InstructionCall, tracer.steps[n-1].Op)
+	assert.Equal(t, 0, tracer.steps[n-1].Depth)
+}
+
+func TestJSONTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewJSONTracer(&buf)
+	vm := NewVM(slices.Concat(pushBytes([]byte("K")), []byte{0x10}), NewState(), testGas, nil)
+	vm.SetTracer(tracer)
+	assert.ErrorIs(t, vm.Run(), ErrKeyNotFound)
+	require.NoError(t, tracer.Err())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	var step map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &step))
+	assert.Equal(t, "pack", step["op"])
+	assert.Equal(t, []any{"0x4b", "1"}, step["stack"])
+	require.NoError(t, json.Unmarshal([]byte(lines[3]), &step))
+	assert.Equal(t, "get", step["op"])
+	assert.Equal(t, []any{map[string]any{"key": "0x4b", "value": nil, "write": false}}, step["storage"])
+	assert.Contains(t, step["error"], ErrKeyNotFound.Error())
+}
+
+func TestBlockchain_TraceTransaction(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	privateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	// the second transaction returns what the first one stored
+	store := NewTransaction(storeTx(t, "FOO").Data)
+	store.GasLimit = testGas
+	require.NoError(t, store.Sign(privateKey))
+	get := NewTransaction(slices.Concat(pushBytes([]byte("FOO")), []byte{0x10, 0x20}))
+	get.GasLimit = testGas
+	get.Nonce = 1
+	require.NoError(t, get.Sign(privateKey))
+	extendChain(t, chain, store)
+	extendChain(t, chain, storeTx(t, "BAR"), get)
+
+	hash := get.GetHash(NewTransactionHasher())
+	tracer := &recorder{}
+	receipt, err := chain.TraceTransaction(hash, tracer)
+	require.NoError(t, err)
+	mined, err := chain.GetReceipt(hash)
+	require.NoError(t, err)
+	assert.Equal(t, mined, receipt)
+	assert.False(t, receipt.Failed())
+	n := len(tracer.steps)
+	require.Greater(t, n, 2)
+	assert.Equal(t, InstructionGet, tracer.steps[n-2].Op)
+	assert.Equal(t, []*StorageAccess{{Key: []byte("FOO"), Value: word(big.NewInt(1))}}, tracer.steps[n-2].Storage)
+	assert.Equal(t, InstructionReturn, tracer.steps[n-1].Op)
+
+	// later blocks change neither the trace nor are changed by it
+	extendChain(t, chain, storeTx(t, "FOO"))
+	root := chain.state.Root()
+	receipt, err = chain.TraceTransaction(hash, &recorder{})
+	require.NoError(t, err)
+	assert.Equal(t, mined, receipt)
+	assert.Equal(t, root, chain.state.Root())
+
+	_, err = chain.TraceTransaction(types.RandomHash(), tracer)
+	assert.Error(t, err)
+}
+
+func TestBlockchain_TraceTransactionPrunedState(t *testing.T) {
+	chain := newBlockChainWithGenesisBlock(t)
+	chain.maxReorgDepth = 1
+	privateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	store := NewTransaction(storeTx(t, "FOO").Data)
+	store.GasLimit = testGas
+	require.NoError(t, store.Sign(privateKey))
+	get := NewTransaction(slices.Concat(pushBytes([]byte("FOO")), []byte{0x10, 0x20}))
+	get.GasLimit = testGas
+	get.Nonce = 1
+	require.NoError(t, get.Sign(privateKey))
+	extendChain(t, chain, store)
+	extendChain(t, chain, get)
+	extendChain(t, chain, storeTx(t, "BAR"))
+
+	// only the genesis block and the tip keep their state
+	for height, block := range chain.blocks {
+		node, ok := chain.getNode(block.GetHash(NewHeaderHasher()))
+		require.True(t, ok)
+		assert.Equal(t, height == 0 || height == len(chain.blocks)-1, node.state != nil, height)
+	}
+
+	hash := get.GetHash(NewTransactionHasher())
+	receipt, err := chain.TraceTransaction(hash, &recorder{})
+	require.NoError(t, err)
+	mined, err := chain.GetReceipt(hash)
+	require.NoError(t, err)
+	assert.Equal(t, mined, receipt)
+	assert.False(t, receipt.Failed())
+}
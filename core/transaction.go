@@ -3,6 +3,8 @@ package core
 import (
 	"encoding/gob"
 	"errors"
+	"math/bits"
+
 	"github.com/matrix-go/block/crypto"
 	"github.com/matrix-go/block/types"
 )
@@ -25,6 +27,11 @@ type Transaction struct {
 	From      *crypto.PublicKey
 	To        *crypto.PublicKey
 	Value     uint64 // TODO: big.Int
+	Fee       uint64 // paid to the validator of the block
+	GasLimit  uint64 // gas that executing Data may use
+	GasPrice  uint64 // paid to the validator for every unit of gas used
+	Nonce     uint64 // number of transactions sent by From before this one
+	ChainID   uint64 // chain the transaction is signed for
 	Signature *crypto.Signature
 
 	// first local node see the tx
@@ -33,7 +40,7 @@ type Transaction struct {
 	Hash types.Hash
 
 	// inner tx
-	InnerTx any // one of MintTx and CollectionTx
+	InnerTx any // one of MintTx, CollectionTx, DeployTx and InvokeTx
 }
 
 func NewTransaction(data []byte) *Transaction {
@@ -42,6 +49,25 @@ func NewTransaction(data []byte) *Transaction {
 	}
 }
 
+// NewCoinbaseTransaction pays reward to the validator of the block at height,
+// it is the only unsigned transaction a block may carry. The nonce is the
+// height so that every coinbase has its own hash.
+func NewCoinbaseTransaction(validator *crypto.PublicKey, height, reward, chainID uint64) *Transaction {
+	tx := NewTransaction(nil)
+	tx.To = validator
+	tx.Value = reward
+	tx.Nonce = height
+	tx.ChainID = chainID
+	return tx
+}
+
+// GasCost is what the sender pays up front for the gas of tx, the gas left
+// unused is refunded. It reports false on overflow.
+func (tx *Transaction) GasCost() (uint64, bool) {
+	hi, cost := bits.Mul64(tx.GasLimit, tx.GasPrice)
+	return cost, hi == 0
+}
+
 func (tx *Transaction) Sign(privateKey *crypto.PrivateKey) error {
 	tx.From = privateKey.PublicKey()
 	hash := tx.GetHash(NewTransactionHasher())
@@ -52,7 +78,7 @@ func (tx *Transaction) Sign(privateKey *crypto.PrivateKey) error {
 }
 
 func (tx *Transaction) Verify() error {
-	if tx.Signature == nil {
+	if tx.Signature == nil || tx.From == nil {
 		return ErrTransactionNotSigned
 	}
 	hash := tx.GetHash(NewTransactionHasher())
@@ -85,9 +111,14 @@ func (tx *Transaction) SetFirstSeen(t int64) {
 func init() {
 	gob.Register(&CollectionTx{})
 	gob.Register(&MintTx{})
+	gob.Register(&DeployTx{})
+	gob.Register(&InvokeTx{})
 }
 
 var (
 	ErrTransactionVerifyFailed = errors.New("transaction verify failed")
 	ErrTransactionNotSigned    = errors.New("transaction not signed")
+	ErrChainIDMismatch         = errors.New("chain id mismatch")
+	ErrTransactionNoRecipient  = errors.New("transaction without recipient")
+	ErrGasPriceTooLow          = errors.New("gas price too low")
 )
@@ -1,12 +1,18 @@
 package core
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+
+	"github.com/matrix-go/block/types"
 )
 
 type Validator interface {
 	ValidateBlock(bc *Blockchain, block *Block) error
+	// ValidateExecution is called once the block has been executed,
+	// before its state changes are committed
+	ValidateExecution(bc *Blockchain, block *Block) error
 }
 
 type BlockValidator struct {
@@ -16,28 +22,109 @@ func NewBlockValidator() *BlockValidator {
 	return &BlockValidator{}
 }
 
+// ValidateBlock accepts a block on top of any known block, side branches
+// included, the fork choice of the chain decides whether it becomes canonical
 func (b *BlockValidator) ValidateBlock(bc *Blockchain, block *Block) error {
-	// height of block
-	if bc.HasBlock(block.Height) {
+	hash := block.GetHash(NewHeaderHasher())
+	if bc.HasBlockHash(hash) {
 		return ErrBlockAlreadyInBlockchain
 	}
+	parent, ok := bc.getNode(block.PrevHash)
+	if !ok {
+		return fmt.Errorf("block %s, %w", hash, ErrBlockUnknownParent)
+	}
+	if parent.invalid {
+		return fmt.Errorf("block %s, %w", hash, ErrBlockInvalidParent)
+	}
 	// too high
-	if block.Height != bc.Height()+1 {
-		return fmt.Errorf("block %s, %w", block.GetHash(NewHeaderHasher()), ErrBlockTooHigh)
+	if block.Height != parent.height()+1 {
+		return fmt.Errorf("block %s, %w", hash, ErrBlockTooHigh)
+	}
+	if block.GasLimit != parent.block.GasLimit {
+		return fmt.Errorf("block %s gas limit %d, expected %d: %w", hash, block.GasLimit, parent.block.GasLimit, ErrBlockGasLimitInvalid)
+	}
+	// the fork choice weighs side blocks by their gas used before they are
+	// executed, it cannot be more than their transactions may use
+	if limit := txGasLimit(block); block.GasUsed > min(block.GasLimit, limit) {
+		return fmt.Errorf("block %s gas used %d, limit %d: %w", hash, block.GasUsed, min(block.GasLimit, limit), ErrBlockGasUsedInvalid)
+	}
+	if block.ChainID != bc.ChainID() {
+		return fmt.Errorf("block %s chain id %d, expected %d: %w", hash, block.ChainID, bc.ChainID(), ErrChainIDMismatch)
+	}
+	for _, tx := range block.Transactions {
+		if tx.ChainID != bc.ChainID() {
+			return fmt.Errorf("block %s transaction chain id %d, expected %d: %w", hash, tx.ChainID, bc.ChainID(), ErrChainIDMismatch)
+		}
+	}
+
+	if !bc.IsValidator(block.Validator) {
+		return fmt.Errorf("block %s validator %s, %w", hash, block.Validator, ErrBlockValidatorUnknown)
 	}
 
 	// verify block
 	if err := block.Verify(); err != nil {
 		return err
 	}
-
-	header, err := bc.GetHeader(bc.Height())
-	if err != nil {
+	if err := validateCoinbase(bc, block); err != nil {
 		return err
 	}
-	hash := NewHeaderHasher().Hash(header)
-	if block.PrevHash != hash {
-		return ErrBlockPrevHashInvalid
+	return validateNonces(block)
+}
+
+// txGasLimit is the sum of the gas limits of the transactions of block
+func txGasLimit(block *Block) uint64 {
+	var limit uint64
+	for _, tx := range block.Transactions {
+		limit = saturatingAdd(limit, tx.GasLimit)
+	}
+	return limit
+}
+
+// validateCoinbase checks that the block pays its validator no more than
+// the reward of its height
+func validateCoinbase(bc *Blockchain, block *Block) error {
+	coinbase := block.Coinbase()
+	if coinbase == nil {
+		return nil
+	}
+	hash := block.GetHash(NewHeaderHasher())
+	if coinbase.To == nil || !bytes.Equal(coinbase.To.Key, block.Validator.Key) {
+		return fmt.Errorf("block %s coinbase not paid to its validator: %w", hash, ErrBlockCoinbaseInvalid)
+	}
+	if coinbase.Nonce != block.Height || coinbase.Fee != 0 || len(coinbase.Data) > 0 || coinbase.InnerTx != nil {
+		return fmt.Errorf("block %s, %w", hash, ErrBlockCoinbaseInvalid)
+	}
+	if reward := bc.BlockReward(block.Height); coinbase.Value > reward {
+		return fmt.Errorf("block %s reward %d, allowed %d: %w", hash, coinbase.Value, reward, ErrBlockRewardTooHigh)
+	}
+	return nil
+}
+
+// validateNonces checks that the nonces of each sender follow each other
+// inside the block, the first one is checked against the state on execution
+func validateNonces(block *Block) error {
+	next := make(map[types.Address]uint64)
+	for _, tx := range block.Transactions {
+		if tx.From == nil {
+			continue
+		}
+		addr := tx.From.Address()
+		if expected, ok := next[addr]; ok {
+			if tx.Nonce < expected {
+				return fmt.Errorf("block %s, %s nonce %d, expected %d: %w", block.GetHash(NewHeaderHasher()), addr, tx.Nonce, expected, ErrNonceTooLow)
+			}
+			if tx.Nonce > expected {
+				return fmt.Errorf("block %s, %s nonce %d, expected %d: %w", block.GetHash(NewHeaderHasher()), addr, tx.Nonce, expected, ErrNonceTooHigh)
+			}
+		}
+		next[addr] = tx.Nonce + 1
+	}
+	return nil
+}
+
+func (b *BlockValidator) ValidateExecution(bc *Blockchain, block *Block) error {
+	if root := bc.state.Root(); block.StateRoot != root {
+		return fmt.Errorf("block %s state root %s, got %s: %w", block.GetHash(NewHeaderHasher()), block.StateRoot, root, ErrBlockStateRootInvalid)
 	}
 	return nil
 }
@@ -48,4 +135,13 @@ var (
 	ErrBlockTooHigh             = errors.New("block too high")
 	ErrBlockAlreadyInBlockchain = errors.New("block already in blockchain")
 	ErrBlockPrevHashInvalid     = errors.New("block prev GetHash invalid")
+	ErrBlockUnknownParent       = errors.New("block parent unknown")
+	ErrBlockInvalidParent       = errors.New("block parent invalid")
+	ErrBlockValidatorUnknown    = errors.New("block validator not authorised")
+	ErrBlockStateRootInvalid    = errors.New("block state root invalid")
+	ErrBlockCoinbaseInvalid     = errors.New("block coinbase invalid")
+	ErrBlockRewardTooHigh       = errors.New("block reward too high")
+	ErrBlockGasLimitInvalid     = errors.New("block gas limit invalid")
+	ErrBlockGasLimitReached     = errors.New("block gas limit reached")
+	ErrBlockGasUsedInvalid      = errors.New("block gas used invalid")
 )
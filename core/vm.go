@@ -1,10 +1,23 @@
 package core
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"github.com/matrix-go/block/util"
+	"math"
+	"math/big"
+	"slices"
+
+	"github.com/matrix-go/block/crypto"
 )
 
+// wordSize is the width in bytes of the integers of the VM, they are
+// unsigned and wrap around
+const wordSize = 32
+
+var wordModulus = new(big.Int).Lsh(big.NewInt(1), wordSize*8)
+
 type Stack struct {
 	data []any
 	sp   int // stack point
@@ -17,16 +30,23 @@ func NewStack(size int) *Stack {
 	}
 }
 
-func (s *Stack) Push(v any) {
+func (s *Stack) Push(v any) error {
+	if s.sp == len(s.data) {
+		return ErrStackOverflow
+	}
 	s.data[s.sp] = v
 	s.sp++
+	return nil
 }
 
-func (s *Stack) Pop() any {
-	ret := s.data[s.sp-1]
-	s.data = append(s.data[:s.sp-1], s.data[s.sp:]...)
+func (s *Stack) Pop() (any, error) {
+	if s.sp == 0 {
+		return nil, ErrStackUnderflow
+	}
 	s.sp--
-	return ret
+	ret := s.data[s.sp]
+	s.data[s.sp] = nil
+	return ret, nil
 }
 
 func (s *Stack) Shift() any {
@@ -39,97 +59,671 @@ func (s *Stack) Shift() any {
 	return ret
 }
 
+// peek returns the top of the stack without removing it
+func (s *Stack) peek() any {
+	if s.sp == 0 {
+		return nil
+	}
+	return s.data[s.sp-1]
+}
+
+// pop returns the top of the stack, which must be a T
+func pop[T any](s *Stack) (T, error) {
+	var zero T
+	v, err := s.Pop()
+	if err != nil {
+		return zero, err
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: %T, expected %T", ErrTypeMismatch, v, zero)
+	}
+	return t, nil
+}
+
 type VM struct {
 	data          []byte
 	ip            int // instruction pointer
+	next          int // instruction run after the current one
 	stack         *Stack
-	contractState *State // contract state
+	contractState ContractStorage // storage of the running contract
+	gas           uint64          // gas left
+	ctx           *VMContext
+	// jumpDests are the positions of the InstructionJumpDest of data
+	jumpDests map[int]struct{}
+	ret       any
+	logs      []*Log
+	tracer    Tracer
+	// accesses are the storage accesses of the traced instruction
+	accesses []*StorageAccess
 }
 
-func NewVM(data []byte, contractState *State) *VM {
+// NewVM returns a VM running data, a nil ctx is an empty context
+func NewVM(data []byte, contractState ContractStorage, gas uint64, ctx *VMContext) *VM {
+	if ctx == nil {
+		ctx = &VMContext{}
+	}
 	return &VM{
 		data:          data,
 		ip:            0,
 		stack:         NewStack(128),
 		contractState: contractState,
+		gas:           gas,
+		ctx:           ctx,
+		jumpDests:     jumpDests(data),
+	}
+}
+
+// jumpDests walks data the way Run does, so that an operand is never taken
+// for an InstructionJumpDest
+func jumpDests(data []byte) map[int]struct{} {
+	dests := make(map[int]struct{})
+	for ip := 0; ip < len(data); ip++ {
+		if ip+1 < len(data) && isPush(Instruction(data[ip+1])) {
+			ip++
+			if Instruction(data[ip]) == InstructionPushN {
+				ip += int(data[ip-1])
+			}
+			continue
+		}
+		if Instruction(data[ip]) == InstructionJumpDest {
+			dests[ip] = struct{}{}
+		}
+	}
+	return dests
+}
+
+// Logs are the events emitted by the run
+func (vm *VM) Logs() []*Log {
+	return vm.logs
+}
+
+// ReturnValue is the value given to InstructionReturn, nil without one
+func (vm *VM) ReturnValue() any {
+	return vm.ret
+}
+
+// SetTracer makes tracer follow the run and the contracts it calls
+func (vm *VM) SetTracer(tracer Tracer) {
+	vm.tracer = tracer
+}
+
+// GasLeft is the gas that the execution has not used
+func (vm *VM) GasLeft() uint64 {
+	return vm.gas
+}
+
+func (vm *VM) useGas(gas uint64) error {
+	if gas > vm.gas {
+		vm.gas = 0
+		return ErrOutOfGas
 	}
+	vm.gas -= gas
+	return nil
 }
 
+// Run executes the code until its end, it stops at the first error.
+// A push takes the byte before it as its operand, InstructionPushN also
+// takes the bytes after it.
 func (vm *VM) Run() error {
-	for {
-		instr := vm.data[vm.ip]
-		if err := vm.Exec(Instruction(instr)); err != nil {
-			fmt.Println(err)
+	for vm.ip < len(vm.data) {
+		var step *Step
+		if vm.tracer != nil {
+			step = vm.newStep()
+			vm.tracer.BeforeInstruction(step)
 		}
-		vm.ip++
-		if vm.ip >= len(vm.data) {
-			break
+		err := vm.step()
+		if vm.tracer != nil {
+			step.GasCost = step.Gas - vm.gas
+			step.Storage, vm.accesses = vm.accesses, nil
+			step.Err = err
+			vm.tracer.AfterInstruction(step)
 		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step runs the instruction at ip, with its operand
+func (vm *VM) step() error {
+	if vm.ip+1 < len(vm.data) && isPush(Instruction(vm.data[vm.ip+1])) {
+		if err := vm.useGas(GasStep); err != nil {
+			return err
+		}
+		vm.ip++
+	} else if isPush(Instruction(vm.data[vm.ip])) {
+		return fmt.Errorf("instruction %#x at %d: %w", vm.data[vm.ip], vm.ip, ErrMissingOperand)
+	}
+	instr := Instruction(vm.data[vm.ip])
+	if err := vm.useGas(GasStep + instructionGas[instr]); err != nil {
+		return err
 	}
+	vm.next = vm.ip + 1
+	if err := vm.Exec(instr); err != nil {
+		return fmt.Errorf("instruction %#x at %d: %w", byte(instr), vm.ip, err)
+	}
+	vm.ip = vm.next
 	return nil
 }
 
+// newStep is the Step of the instruction at ip, its operand skipped
+func (vm *VM) newStep() *Step {
+	ip := vm.ip
+	if ip+1 < len(vm.data) && isPush(Instruction(vm.data[ip+1])) {
+		ip++
+	}
+	return &Step{
+		Depth:    vm.ctx.depth,
+		Contract: vm.ctx.Contract,
+		IP:       ip,
+		Op:       Instruction(vm.data[ip]),
+		Gas:      vm.gas,
+		Stack:    slices.Clone(vm.stack.data[:vm.stack.sp]),
+	}
+}
+
+// access records a storage access for the tracer
+func (vm *VM) access(key, value []byte, write bool) {
+	if vm.tracer != nil {
+		vm.accesses = append(vm.accesses, &StorageAccess{Key: key, Value: value, Write: write})
+	}
+}
+
+// jump makes the instruction at dest the next one, it must be an
+// InstructionJumpDest
+func (vm *VM) jump(dest int) error {
+	if _, ok := vm.jumpDests[dest]; !ok {
+		return fmt.Errorf("%w: %d", ErrInvalidJump, dest)
+	}
+	vm.next = dest
+	return nil
+}
+
+func boolToInt(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return new(big.Int)
+}
+
+// word is the big-endian encoding of x on wordSize bytes
+func word(x *big.Int) []byte {
+	return x.FillBytes(make([]byte, wordSize))
+}
+
+// equal compares values of the stack, byte slices by content
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case *big.Int:
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	default:
+		return false
+	}
+}
+
+func isPush(instr Instruction) bool {
+	return instr == InstructionPushInt || instr == InstructionPushByte || instr == InstructionPushN
+}
+
+// popOperands pops b then a
+func (vm *VM) popOperands() (a, b *big.Int, err error) {
+	if b, err = pop[*big.Int](vm.stack); err != nil {
+		return nil, nil, err
+	}
+	a, err = pop[*big.Int](vm.stack)
+	return a, b, err
+}
+
+// popSize pops an int used as a count or a position in the code
+func (vm *VM) popSize() (int, error) {
+	x, err := pop[*big.Int](vm.stack)
+	if err != nil {
+		return 0, err
+	}
+	if !x.IsInt64() || x.Int64() > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: %s", ErrIntOutOfRange, x)
+	}
+	return int(x.Int64()), nil
+}
+
+// arithmetic pops b then a and pushes op(a, b) wrapped around to a word,
+// op must not change a or b
+func (vm *VM) arithmetic(op func(a, b *big.Int) *big.Int) error {
+	a, b, err := vm.popOperands()
+	if err != nil {
+		return err
+	}
+	z := op(a, b)
+	return vm.stack.Push(z.Mod(z, wordModulus))
+}
+
+// popData pops a value used as bytes, ints are taken as words
+func (vm *VM) popData() ([]byte, error) {
+	v, err := vm.stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+	data := valueBytes(v)
+	if data == nil {
+		return nil, fmt.Errorf("%w: %T", ErrTypeMismatch, v)
+	}
+	return data, nil
+}
+
+// useHashGas pays GasHashWord for every word of data
+func (vm *VM) useHashGas(data []byte) error {
+	words := (uint64(len(data)) + wordSize - 1) / wordSize
+	return vm.useGas(words * GasHashWord)
+}
+
+// pushN pushes the n bytes following the instruction as an int
+func (vm *VM) pushN() error {
+	n := int(vm.data[vm.ip-1])
+	if n == 0 || n > wordSize {
+		return fmt.Errorf("%w: push of %d bytes", ErrIntOutOfRange, n)
+	}
+	if vm.ip+n >= len(vm.data) {
+		return ErrMissingOperand
+	}
+	if err := vm.useGas(uint64(n) * GasStep); err != nil {
+		return err
+	}
+	vm.next = vm.ip + 1 + n
+	return vm.stack.Push(new(big.Int).SetBytes(vm.data[vm.ip+1 : vm.next]))
+}
+
 func (vm *VM) Exec(instr Instruction) error {
 	switch instr {
 	case InstructionPushInt:
-		vm.stack.Push(int(vm.data[vm.ip-1]))
+		return vm.stack.Push(big.NewInt(int64(vm.data[vm.ip-1])))
 	case InstructionPushByte:
-		vm.stack.Push(vm.data[vm.ip-1])
+		return vm.stack.Push([]byte{vm.data[vm.ip-1]})
+	case InstructionPushN:
+		return vm.pushN()
 	case InstructionAdd:
-		b := vm.stack.Pop()
-		a := vm.stack.Pop()
-		c := a.(int) + b.(int)
-		vm.stack.Push(util.SerializeInt64(int64(c)))
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) })
 	case InstructionSub:
-		b := vm.stack.Pop()
-		a := vm.stack.Pop()
-		c := a.(int) - b.(int)
-		vm.stack.Push(util.SerializeInt64(int64(c)))
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return new(big.Int).Sub(a, b) })
 	case InstructionMul:
-		b := vm.stack.Pop()
-		a := vm.stack.Pop()
-		c := a.(int) * b.(int)
-		vm.stack.Push(util.SerializeInt64(int64(c)))
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return new(big.Int).Mul(a, b) })
 	case InstructionDiv:
-		b := vm.stack.Pop()
-		a := vm.stack.Pop()
-		c := a.(int) / b.(int)
-		vm.stack.Push(util.SerializeInt64(int64(c)))
+		if b, ok := vm.stack.peek().(*big.Int); ok && b.Sign() == 0 {
+			return ErrDivisionByZero
+		}
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return new(big.Int).Quo(a, b) })
 	case InstructionPack:
-		n := vm.stack.Pop().(int)
-		b := make([]byte, n)
-		for i := 0; i < n; i++ {
-			b[n-i-1] = vm.stack.Pop().(byte)
+		n, err := vm.popSize()
+		if err != nil {
+			return err
 		}
-		vm.stack.Push(b)
+		if n > vm.stack.sp {
+			return ErrStackUnderflow
+		}
+		parts := make([][]byte, n)
+		size := 0
+		for i := n - 1; i >= 0; i-- {
+			if parts[i], err = pop[[]byte](vm.stack); err != nil {
+				return err
+			}
+			size += len(parts[i])
+		}
+		if err = vm.useGas(uint64(size) * GasPackByte); err != nil {
+			return err
+		}
+		return vm.stack.Push(bytes.Join(parts, nil))
 	case InstructionStore:
-		key := vm.stack.Pop().([]byte)
-		v := vm.stack.Pop().([]byte)
-		vm.contractState.Put(key, v)
-		fmt.Printf("key: %v, value: %v\n", key, v)
+		key, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+		value, err := vm.popData()
+		if err != nil {
+			return err
+		}
+		vm.access(key, value, true)
+		return vm.contractState.Put(key, value)
 	case InstructionGet:
-		key := vm.stack.Pop().([]byte)
+		key, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
 		value, err := vm.contractState.Get(key)
+		vm.access(key, value, false)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+		}
+		return vm.stack.Push(value)
+	case InstructionJump:
+		dest, err := vm.popSize()
+		if err != nil {
+			return err
+		}
+		return vm.jump(dest)
+	case InstructionJumpIf:
+		dest, err := vm.popSize()
+		if err != nil {
+			return err
+		}
+		cond, err := pop[*big.Int](vm.stack)
+		if err != nil {
+			return err
+		}
+		if cond.Sign() == 0 {
+			return nil
+		}
+		return vm.jump(dest)
+	case InstructionJumpDest:
+		return nil
+	case InstructionEq:
+		b, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		a, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolToInt(equal(a, b)))
+	case InstructionLt:
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return boolToInt(a.Cmp(b) < 0) })
+	case InstructionGt:
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return boolToInt(a.Cmp(b) > 0) })
+	case InstructionNot:
+		a, err := pop[*big.Int](vm.stack)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("value: %v\n", value)
-		vm.stack.Push(value)
+		return vm.stack.Push(boolToInt(a.Sign() == 0))
+	case InstructionAnd:
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return new(big.Int).And(a, b) })
+	case InstructionOr:
+		return vm.arithmetic(func(a, b *big.Int) *big.Int { return new(big.Int).Or(a, b) })
+	case InstructionDup:
+		v := vm.stack.peek()
+		if v == nil {
+			return ErrStackUnderflow
+		}
+		return vm.stack.Push(v)
+	case InstructionSwap:
+		b, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		a, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		_ = vm.stack.Push(b)
+		return vm.stack.Push(a)
+	case InstructionPop:
+		_, err := vm.stack.Pop()
+		return err
+	case InstructionStop:
+		vm.next = len(vm.data)
+		return nil
+	case InstructionReturn:
+		v, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		vm.ret = v
+		vm.next = len(vm.data)
+		return nil
+	case InstructionCaller:
+		return vm.stack.Push(vm.ctx.Caller.Bytes())
+	case InstructionValue:
+		return vm.stack.Push(new(big.Int).SetUint64(vm.ctx.Value))
+	case InstructionHeight:
+		return vm.stack.Push(new(big.Int).SetUint64(vm.ctx.Height))
+	case InstructionTimestamp:
+		return vm.stack.Push(new(big.Int).SetUint64(vm.ctx.Timestamp))
+	case InstructionSelfBalance:
+		balance, err := vm.ctx.balance()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(new(big.Int).SetUint64(balance))
+	case InstructionLog:
+		topic, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+		data, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+		if err = vm.useGas(uint64(len(topic)+len(data)) * GasLogByte); err != nil {
+			return err
+		}
+		vm.logs = append(vm.logs, &Log{
+			Contract: vm.ctx.Contract,
+			Topic:    topic,
+			Data:     data,
+		})
+		return nil
+	case InstructionToInt:
+		b, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+		if len(b) > wordSize {
+			return fmt.Errorf("%w: %d bytes", ErrIntOutOfRange, len(b))
+		}
+		return vm.stack.Push(new(big.Int).SetBytes(b))
+	case InstructionToBytes:
+		x, err := pop[*big.Int](vm.stack)
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(word(x))
+	case InstructionSha256:
+		data, err := vm.popData()
+		if err != nil {
+			return err
+		}
+		if err = vm.useHashGas(data); err != nil {
+			return err
+		}
+		digest := sha256.Sum256(data)
+		return vm.stack.Push(digest[:])
+	case InstructionVerify:
+		key, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+		sig, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+		msg, err := vm.popData()
+		if err != nil {
+			return err
+		}
+		if err = vm.useHashGas(msg); err != nil {
+			return err
+		}
+		valid := crypto.Signature{Value: sig}.Verify(&crypto.PublicKey{Key: key}, msg)
+		return vm.stack.Push(boolToInt(valid))
+	case InstructionCall:
+		return vm.call()
+	case InstructionTransfer:
+		return vm.transfer()
+	case InstructionCallData:
+		size, err := vm.popSize()
+		if err != nil {
+			return err
+		}
+		offset, err := vm.popSize()
+		if err != nil {
+			return err
+		}
+		if err = vm.useGas(uint64(size) * GasCallDataByte); err != nil {
+			return err
+		}
+		data := make([]byte, size)
+		if offset < len(vm.ctx.Input) {
+			copy(data, vm.ctx.Input[offset:])
+		}
+		return vm.stack.Push(data)
+	case InstructionCallDataLen:
+		return vm.stack.Push(big.NewInt(int64(len(vm.ctx.Input))))
+	default:
+		return ErrUnknownOpcode
 	}
-
-	return nil
 }
 
 type Instruction byte
 
+// The stack holds unsigned 256-bit ints and byte strings. Arithmetic wraps
+// around, byte strings are built with InstructionPushByte and
+// InstructionPack.
 const (
 	InstructionPushInt  Instruction = 0x0a // 10
 	InstructionAdd      Instruction = 0x0b // 11
-	InstructionPushByte Instruction = 0x0c // 12
-	InstructionPack     Instruction = 0x0d // 13
+	InstructionPushByte Instruction = 0x0c // 12, pushes a string of one byte
+	InstructionPack     Instruction = 0x0d // 13, pops n then joins n strings
 	InstructionSub      Instruction = 0x0e // 14
-	InstructionStore    Instruction = 0x0f // 15
+	InstructionStore    Instruction = 0x0f // 15, ints are stored as words
 	InstructionGet      Instruction = 0x10 // 16
 	InstructionMul      Instruction = 0x11 // 17
 	InstructionDiv      Instruction = 0x12 // 18
+	// InstructionJump pops the destination, InstructionJumpIf pops the
+	// destination then the condition and jumps unless it is 0. Both only
+	// jump to an InstructionJumpDest.
+	InstructionJump     Instruction = 0x13 // 19
+	InstructionJumpIf   Instruction = 0x14 // 20
+	InstructionJumpDest Instruction = 0x15 // 21
+	// comparisons push 1 when they hold, 0 otherwise
+	InstructionEq     Instruction = 0x16 // 22
+	InstructionLt     Instruction = 0x17 // 23
+	InstructionGt     Instruction = 0x18 // 24
+	InstructionNot    Instruction = 0x19 // 25
+	InstructionAnd    Instruction = 0x1a // 26
+	InstructionOr     Instruction = 0x1b // 27
+	InstructionDup    Instruction = 0x1c // 28
+	InstructionSwap   Instruction = 0x1d // 29
+	InstructionPop    Instruction = 0x1e // 30
+	InstructionStop   Instruction = 0x1f // 31
+	InstructionReturn Instruction = 0x20 // 32, stops with the popped value
+	// context of the run, the caller address is pushed as bytes and the
+	// numbers as ints
+	InstructionCaller      Instruction = 0x21 // 33
+	InstructionValue       Instruction = 0x22 // 34
+	InstructionHeight      Instruction = 0x23 // 35
+	InstructionTimestamp   Instruction = 0x24 // 36
+	InstructionSelfBalance Instruction = 0x25 // 37
+	// InstructionLog pops a topic then data and emits them as a Log
+	InstructionLog Instruction = 0x26 // 38
+	// InstructionPushN pushes the n bytes after it as a big-endian int, n
+	// being its operand
+	InstructionPushN Instruction = 0x27 // 39
+	// InstructionToInt reads a string as a big-endian int,
+	// InstructionToBytes writes an int as a word
+	InstructionToInt   Instruction = 0x28 // 40
+	InstructionToBytes Instruction = 0x29 // 41
+	// InstructionSha256 pushes the digest of the popped value,
+	// InstructionVerify pops an ed25519 public key, a signature then the
+	// message and pushes 1 when the signature is valid, 0 otherwise
+	InstructionSha256 Instruction = 0x2a // 42
+	InstructionVerify Instruction = 0x2b // 43
+	// InstructionCall runs another contract, InstructionTransfer pops a
+	// recipient then an amount sent by the running contract
+	InstructionCall     Instruction = 0x2c // 44
+	InstructionTransfer Instruction = 0x2d // 45
+	// InstructionCallData pops a size then an offset and pushes these bytes
+	// of the call data, zeros past its end
+	InstructionCallData    Instruction = 0x2e // 46
+	InstructionCallDataLen Instruction = 0x2f // 47
+)
+
+var instructionMnemonics = map[Instruction]string{
+	InstructionPushInt:     "push",
+	InstructionAdd:         "add",
+	InstructionPushByte:    "pushb",
+	InstructionPack:        "pack",
+	InstructionSub:         "sub",
+	InstructionStore:       "store",
+	InstructionGet:         "get",
+	InstructionMul:         "mul",
+	InstructionDiv:         "div",
+	InstructionJump:        "jump",
+	InstructionJumpIf:      "jumpi",
+	InstructionJumpDest:    "jumpdest",
+	InstructionEq:          "eq",
+	InstructionLt:          "lt",
+	InstructionGt:          "gt",
+	InstructionNot:         "not",
+	InstructionAnd:         "and",
+	InstructionOr:          "or",
+	InstructionDup:         "dup",
+	InstructionSwap:        "swap",
+	InstructionPop:         "pop",
+	InstructionStop:        "stop",
+	InstructionReturn:      "return",
+	InstructionCaller:      "caller",
+	InstructionValue:       "value",
+	InstructionHeight:      "height",
+	InstructionTimestamp:   "timestamp",
+	InstructionSelfBalance: "selfbalance",
+	InstructionLog:         "log",
+	InstructionPushN:       "pushn",
+	InstructionToInt:       "toint",
+	InstructionToBytes:     "tobytes",
+	InstructionSha256:      "sha256",
+	InstructionVerify:      "verify",
+	InstructionCall:        "call",
+	InstructionTransfer:    "transfer",
+	InstructionCallData:    "calldata",
+	InstructionCallDataLen: "calldatalen",
+}
+
+// Mnemonic is the assembly name of the instruction, ok is false for an
+// unknown opcode
+func (i Instruction) Mnemonic() (mnemonic string, ok bool) {
+	mnemonic, ok = instructionMnemonics[i]
+	return mnemonic, ok
+}
+
+func (i Instruction) String() string {
+	if mnemonic, ok := i.Mnemonic(); ok {
+		return mnemonic
+	}
+	return fmt.Sprintf("0x%02x", byte(i))
+}
+
+// ParseInstruction returns the instruction of an assembly mnemonic
+func ParseInstruction(mnemonic string) (Instruction, bool) {
+	for instr, m := range instructionMnemonics {
+		if m == mnemonic {
+			return instr, true
+		}
+	}
+	return 0, false
+}
+
+// IsPush reports whether the instruction takes the byte before it as operand
+func (i Instruction) IsPush() bool {
+	return isPush(i)
+}
+
+var (
+	ErrOutOfGas          = errors.New("out of gas")
+	ErrStackUnderflow    = errors.New("stack underflow")
+	ErrStackOverflow     = errors.New("stack overflow")
+	ErrTypeMismatch      = errors.New("type mismatch")
+	ErrDivisionByZero    = errors.New("division by zero")
+	ErrUnknownOpcode     = errors.New("unknown opcode")
+	ErrMissingOperand    = errors.New("push without operand")
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrInvalidJump       = errors.New("invalid jump destination")
+	ErrIntOutOfRange     = errors.New("integer out of range")
+	ErrInvalidAddress    = errors.New("invalid address")
+	ErrNoState           = errors.New("no world state")
+	ErrCallDepthExceeded = errors.New("call depth exceeded")
 )
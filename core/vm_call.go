@@ -0,0 +1,134 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/matrix-go/block/types"
+)
+
+// popAddress pops the 20 bytes of an address
+func (vm *VM) popAddress() (types.Address, error) {
+	b, err := pop[[]byte](vm.stack)
+	if err != nil {
+		return types.Address{}, err
+	}
+	if len(b) != 20 {
+		return types.Address{}, fmt.Errorf("%w: address of %d bytes", ErrInvalidAddress, len(b))
+	}
+	return types.AddressFromBytes(b), nil
+}
+
+// popAmount pops an amount of coins
+func (vm *VM) popAmount() (uint64, error) {
+	x, err := pop[*big.Int](vm.stack)
+	if err != nil {
+		return 0, err
+	}
+	if !x.IsUint64() {
+		return 0, fmt.Errorf("%w: amount %s", ErrIntOutOfRange, x)
+	}
+	return x.Uint64(), nil
+}
+
+// transfer pops a recipient then an amount and sends it from the balance of
+// the running contract
+func (vm *VM) transfer() error {
+	to, err := vm.popAddress()
+	if err != nil {
+		return err
+	}
+	amount, err := vm.popAmount()
+	if err != nil {
+		return err
+	}
+	if vm.ctx.State == nil {
+		return ErrNoState
+	}
+	return vm.ctx.State.accounts.Transfer(vm.ctx.Contract, to, amount)
+}
+
+// call pops an address, a value, a gas allowance then an input and runs the
+// contract at the address in a VM of its own, which is sent the value and
+// can use at most the allowance. It pushes the return value of the contract
+// then 1, or an empty string then 0 when the call failed, the changes of a
+// failed call are reverted. An address without code is sent the value only.
+func (vm *VM) call() error {
+	addr, err := vm.popAddress()
+	if err != nil {
+		return err
+	}
+	value, err := vm.popAmount()
+	if err != nil {
+		return err
+	}
+	gas, err := pop[*big.Int](vm.stack)
+	if err != nil {
+		return err
+	}
+	input, err := vm.popData()
+	if err != nil {
+		return err
+	}
+	if vm.ctx.State == nil {
+		return ErrNoState
+	}
+	allowance := vm.gas
+	if gas.IsUint64() && gas.Uint64() < allowance {
+		allowance = gas.Uint64()
+	}
+
+	snapshot := vm.ctx.State.Snapshot()
+	callee, err := vm.runCall(addr, value, allowance, input)
+	if callee != nil {
+		vm.gas -= allowance - callee.GasLeft()
+	}
+	if err != nil {
+		vm.ctx.State.RevertToSnapshot(snapshot)
+		if err = vm.stack.Push([]byte{}); err != nil {
+			return err
+		}
+		return vm.stack.Push(boolToInt(false))
+	}
+	ret := []byte{}
+	if callee != nil {
+		vm.logs = append(vm.logs, callee.logs...)
+		if v := valueBytes(callee.ret); v != nil {
+			ret = v
+		}
+	}
+	if err = vm.stack.Push(ret); err != nil {
+		return err
+	}
+	return vm.stack.Push(boolToInt(true))
+}
+
+// runCall sends value to addr and runs its code, the returned VM is nil when
+// no code ran
+func (vm *VM) runCall(addr types.Address, value, gas uint64, input []byte) (*VM, error) {
+	if vm.ctx.depth >= maxCallDepth {
+		return nil, ErrCallDepthExceeded
+	}
+	state := vm.ctx.State
+	if value > 0 {
+		if err := state.accounts.Transfer(vm.ctx.Contract, addr, value); err != nil {
+			return nil, err
+		}
+	}
+	code, err := state.code.Get(addr.Bytes())
+	if err != nil {
+		return nil, nil
+	}
+	callee := NewVM(code, state.contractStorage(addr), gas, &VMContext{
+		Caller:    vm.ctx.Contract,
+		Contract:  addr,
+		Value:     value,
+		Height:    vm.ctx.Height,
+		Timestamp: vm.ctx.Timestamp,
+		State:     state,
+		Input:     input,
+		depth:     vm.ctx.depth + 1,
+	})
+	callee.SetTracer(vm.tracer)
+	return callee, callee.Run()
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"errors"
+
+	"github.com/matrix-go/block/types"
+)
+
+// maxCallDepth is the number of nested InstructionCall a transaction can make
+const maxCallDepth = 64
+
+// VMContext is what a contract can read about the transaction and the
+// block running it
+type VMContext struct {
+	Caller    types.Address // sender of the transaction, or calling contract
+	Contract  types.Address // address of the running contract
+	Value     uint64        // value sent to the contract
+	Height    uint64
+	Timestamp uint64
+	// State holds the balance of Contract and the code of the contracts it
+	// calls, the balance is 0 and calls fail without it
+	State *WorldState
+	// Input is the data that Contract is called with
+	Input []byte
+	depth int // calls between the transaction and the running contract
+}
+
+func (ctx *VMContext) balance() (uint64, error) {
+	if ctx.State == nil {
+		return 0, nil
+	}
+	balance, err := ctx.State.accounts.GetBalance(ctx.Contract)
+	if errors.Is(err, ErrAccountNotFound) {
+		return 0, nil
+	}
+	return balance, err
+}
@@ -1,12 +1,20 @@
 package core
 
 import (
-	"github.com/matrix-go/block/util"
+	"bytes"
+	"crypto/sha256"
+	"github.com/matrix-go/block/crypto"
+	"github.com/matrix-go/block/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math/big"
+	"slices"
 	"testing"
 )
 
+// testGas is more than any of the test programs uses
+const testGas = 10_000
+
 func TestStack_Shift(t *testing.T) {
 	s := NewStack(128)
 	s.Push(1)
@@ -32,20 +40,20 @@ func TestVM_Run(t *testing.T) {
 	contractState := NewState()
 	data := []byte{0x01, 0x0a, 0x02, 0x0a, 0x0b}
 	// 1 + 2
-	vm := NewVM(data, contractState)
+	vm := NewVM(data, contractState, testGas, nil)
 	err := vm.Run()
 	require.NoError(t, err)
 	assert.Equal(t, 1, vm.stack.sp)
 	t.Logf("stack: %v", vm.stack.data)
 
-	result := util.DeserializeInt64(vm.stack.Shift().([]byte))
+	result := vm.stack.Shift().(*big.Int).Int64()
 	assert.Equal(t, int64(3), result)
 
 	// push bytes and pack
 	// 0x61(a), 0x0c(pushByte), 0x61(a), 0x0c(pushByte), 0x02(len=2), 0x0a(pushInt), 0x0d(pack)
 	// aa
 	data = []byte{0x61, 0x0c, 0x61, 0x0c, 0x02, 0x0a, 0x0d}
-	vm = NewVM(data, contractState)
+	vm = NewVM(data, contractState, testGas, nil)
 	err = vm.Run()
 	require.NoError(t, err)
 	assert.Equal(t, 1, vm.stack.sp)
@@ -56,12 +64,12 @@ func TestVM_Run(t *testing.T) {
 	// push int and sub
 	// 2-1
 	data = []byte{0x02, 0x0a, 0x01, 0x0a, 0x0e}
-	vm = NewVM(data, contractState)
+	vm = NewVM(data, contractState, testGas, nil)
 	err = vm.Run()
 	require.NoError(t, err)
 	assert.Equal(t, 1, vm.stack.sp)
 	t.Logf("stack: %v", vm.stack.data)
-	r := util.DeserializeInt64(vm.stack.Shift().([]byte))
+	r := vm.stack.Shift().(*big.Int).Int64()
 	assert.Equal(t, int64(1), r)
 
 	// store state
@@ -76,7 +84,7 @@ func TestVM_Run(t *testing.T) {
 	//	0x0f, // store [FOO,1]
 	//}
 	//
-	//vm = NewVM(data, contractState)
+	//vm = NewVM(data, contractState, testGas, nil)
 	//err = vm.Run()
 	//require.NoError(t, err)
 	//t.Logf("stack: %v", vm.stack.data)
@@ -85,7 +93,7 @@ func TestVM_Run(t *testing.T) {
 	//t.Logf("state: %v", vm.contractState.data)
 	//val, err := vm.contractState.Get([]byte("FOO"))
 	//require.NoError(t, err)
-	//des := util.DeserializeInt64(val)
+	//des := new(big.Int).SetBytes(val).Int64()
 	//assert.Equal(t, int64(1), des)
 
 	data = []byte{
@@ -97,30 +105,30 @@ func TestVM_Run(t *testing.T) {
 		0x0f, // store [FOM,1]
 	}
 
-	vm = NewVM(data, contractState)
+	vm = NewVM(data, contractState, testGas, nil)
 	err = vm.Run()
 	require.NoError(t, err)
 	t.Logf("stack: %v", vm.stack.data)
 	t.Logf("stack sp: %v", vm.stack.sp)
 	assert.Equal(t, int64(1), r)
-	t.Logf("state: %v", vm.contractState.data)
+	t.Logf("state root: %v", contractState.Root())
 	val, err := vm.contractState.Get([]byte("FOM"))
 	require.NoError(t, err)
-	des := util.DeserializeInt64(val)
+	des := new(big.Int).SetBytes(val).Int64()
 	assert.Equal(t, int64(5), des)
 
 	data = []byte{
 		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, // push FOO and pack
-		0x10, // get FOO
+		0x10, 0x28, // get FOO as an int
 	}
 
-	vm = NewVM(data, contractState)
+	vm = NewVM(data, contractState, testGas, nil)
 	err = vm.Run()
 	require.NoError(t, err)
 	t.Logf("stack: %v", vm.stack.data)
 	t.Logf("stack sp: %v", vm.stack.sp)
-	t.Logf("state: %v", vm.contractState.data)
-	re := util.DeserializeInt64(vm.stack.Shift().([]byte))
+	t.Logf("state root: %v", contractState.Root())
+	re := vm.stack.Shift().(*big.Int).Int64()
 	assert.Equal(t, int64(1), re)
 
 	//
@@ -129,16 +137,420 @@ func TestVM_Run(t *testing.T) {
 		0x46, 0x0c, 0x4f, 0x0c, 0x02, 0x0a, 0x0d, // push FO and pack
 		0x0f,                                     // store [FO, 6]
 		0x46, 0x0c, 0x4f, 0x0c, 0x02, 0x0a, 0x0d, // push FOO and pack
-		0x10, // get FO
+		0x10, 0x28, // get FO as an int
 	}
 
-	vm = NewVM(data, contractState)
+	vm = NewVM(data, contractState, testGas, nil)
 	err = vm.Run()
 	require.NoError(t, err)
 	t.Logf("stack: %v", vm.stack.data)
 	t.Logf("stack sp: %v", vm.stack.sp)
-	t.Logf("state: %v", vm.contractState.data)
-	re = util.DeserializeInt64(vm.stack.Shift().([]byte))
+	t.Logf("state root: %v", contractState.Root())
+	re = vm.stack.Shift().(*big.Int).Int64()
 	assert.Equal(t, int64(6), re)
 
 }
+
+func TestVM_Gas(t *testing.T) {
+	// 1 + 2 steps over 5 bytes, pushes twice and adds
+	data := []byte{0x01, 0x0a, 0x02, 0x0a, 0x0b}
+	cost := 5*GasStep + 2*instructionGas[InstructionPushInt] + instructionGas[InstructionAdd]
+
+	vm := NewVM(data, NewState(), cost, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, uint64(0), vm.GasLeft())
+
+	vm = NewVM(data, NewState(), cost-1, nil)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+	assert.Equal(t, uint64(0), vm.GasLeft())
+
+	// packing pays for every packed byte
+	data = []byte{0x61, 0x0c, 0x61, 0x0c, 0x02, 0x0a, 0x0d}
+	vm = NewVM(data, NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, testGas-7*GasStep-3*instructionGas[InstructionPushByte]-instructionGas[InstructionPack]-2*GasPackByte, vm.GasLeft())
+}
+
+func TestVM_Errors(t *testing.T) {
+	overflow := make([]byte, 0)
+	for i := 0; i <= 128; i++ {
+		overflow = append(overflow, 0x01, 0x0a)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "underflow", data: []byte{0x01, 0x0a, 0x0b}, err: ErrStackUnderflow},
+		{name: "overflow", data: overflow, err: ErrStackOverflow},
+		{name: "type mismatch", data: []byte{0x61, 0x0c, 0x01, 0x0a, 0x0b}, err: ErrTypeMismatch},
+		{name: "division by zero", data: []byte{0x01, 0x0a, 0x00, 0x0a, 0x12}, err: ErrDivisionByZero},
+		{name: "unknown opcode", data: []byte{0xff}, err: ErrUnknownOpcode},
+		{name: "missing operand", data: []byte{0x0a}, err: ErrMissingOperand},
+		{name: "key not found", data: []byte{0x5a, 0x0c, 0x01, 0x0a, 0x0d, 0x10}, err: ErrKeyNotFound},
+		{name: "pack underflow", data: []byte{0x5a, 0x0c, 0x02, 0x0a, 0x0d}, err: ErrStackUnderflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(tt.data, NewState(), testGas, nil)
+			assert.ErrorIs(t, vm.Run(), tt.err)
+		})
+	}
+}
+
+func TestVM_ControlFlow(t *testing.T) {
+	// jump to 8 when the condition holds and return 'd', otherwise stop with 'c' on the stack
+	branch := func(cond byte) []byte {
+		return []byte{
+			cond, 0x0a, 0x08, 0x0a, 0x14, // push cond, push 8 and jumpi
+			0x63, 0x0c, 0x1f, // push 'c' and stop
+			0x15,             // jumpdest
+			0x64, 0x0c, 0x20, // push 'd' and return
+		}
+	}
+	vm := NewVM(branch(1), NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, []byte("d"), vm.ReturnValue())
+	assert.Equal(t, 0, vm.stack.sp)
+
+	vm = NewVM(branch(0), NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Nil(t, vm.ReturnValue())
+	assert.Equal(t, []byte("c"), vm.stack.Shift())
+
+	// the operand 0x15 is not a jumpdest
+	vm = NewVM([]byte{0x03, 0x0a, 0x13, 0x15, 0x0c}, NewState(), testGas, nil)
+	assert.ErrorIs(t, vm.Run(), ErrInvalidJump)
+
+	// jumping back forever runs out of gas
+	vm = NewVM([]byte{0x15, 0x00, 0x0a, 0x13}, NewState(), testGas, nil)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+}
+
+func TestVM_Comparison(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected any
+	}{
+		{name: "eq", data: []byte{0x02, 0x0a, 0x02, 0x0a, 0x16}, expected: big.NewInt(1)},
+		{name: "eq results", data: []byte{0x01, 0x0a, 0x02, 0x0a, 0x0b, 0x03, 0x0a, 0x16}, expected: big.NewInt(1)},
+		{name: "eq bytes", data: []byte{0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x16}, expected: big.NewInt(1)},
+		{name: "eq types", data: []byte{0x61, 0x0c, 0x61, 0x0a, 0x16}, expected: big.NewInt(0)},
+		{name: "lt", data: []byte{0x01, 0x0a, 0x02, 0x0a, 0x17}, expected: big.NewInt(1)},
+		{name: "gt", data: []byte{0x01, 0x0a, 0x02, 0x0a, 0x18}, expected: big.NewInt(0)},
+		{name: "not", data: []byte{0x00, 0x0a, 0x19}, expected: big.NewInt(1)},
+		{name: "and", data: []byte{0x01, 0x0a, 0x00, 0x0a, 0x1a}, expected: big.NewInt(0)},
+		{name: "or", data: []byte{0x01, 0x0a, 0x00, 0x0a, 0x1b}, expected: big.NewInt(1)},
+		{name: "dup", data: []byte{0x07, 0x0a, 0x1c, 0x16}, expected: big.NewInt(1)},
+		{name: "swap", data: []byte{0x01, 0x0a, 0x02, 0x0a, 0x1d, 0x17}, expected: big.NewInt(0)},
+		{name: "pop", data: []byte{0x01, 0x0a, 0x02, 0x0a, 0x1e}, expected: big.NewInt(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(tt.data, NewState(), testGas, nil)
+			require.NoError(t, vm.Run())
+			assert.Equal(t, 1, vm.stack.sp)
+			assert.Equal(t, tt.expected, vm.stack.Shift())
+		})
+	}
+}
+
+func TestVM_Context(t *testing.T) {
+	state := NewWorldState()
+	ctx := &VMContext{
+		Caller:    types.Address{1},
+		Contract:  types.Address{2},
+		Value:     7,
+		Height:    3,
+		Timestamp: 100,
+		State:     state,
+	}
+	require.NoError(t, state.accounts.AddBalance(ctx.Contract, 50))
+	tests := []struct {
+		name     string
+		instr    Instruction
+		expected any
+	}{
+		{name: "caller", instr: InstructionCaller, expected: ctx.Caller.Bytes()},
+		{name: "value", instr: InstructionValue, expected: big.NewInt(7)},
+		{name: "height", instr: InstructionHeight, expected: big.NewInt(3)},
+		{name: "timestamp", instr: InstructionTimestamp, expected: big.NewInt(100)},
+		{name: "balance", instr: InstructionSelfBalance, expected: big.NewInt(50)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM([]byte{byte(tt.instr)}, NewState(), testGas, ctx)
+			require.NoError(t, vm.Run())
+			assert.Equal(t, tt.expected, vm.stack.Shift())
+		})
+	}
+
+	// without context
+	vm := NewVM([]byte{byte(InstructionSelfBalance)}, NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, new(big.Int), vm.stack.Shift())
+}
+
+func TestVM_Log(t *testing.T) {
+	// log "hi" with topic "ev"
+	data := []byte{
+		0x68, 0x0c, 0x69, 0x0c, 0x02, 0x0a, 0x0d,
+		0x65, 0x0c, 0x76, 0x0c, 0x02, 0x0a, 0x0d,
+		0x26,
+	}
+	ctx := &VMContext{Contract: types.Address{3}}
+	vm := NewVM(data, NewState(), testGas, ctx)
+	require.NoError(t, vm.Run())
+	require.Len(t, vm.Logs(), 1)
+	assert.Equal(t, &Log{Contract: ctx.Contract, Topic: []byte("ev"), Data: []byte("hi")}, vm.Logs()[0])
+
+	// the topic must be bytes
+	vm = NewVM([]byte{0x68, 0x0c, 0x01, 0x0a, 0x0d, 0x01, 0x0a, 0x26}, NewState(), testGas, ctx)
+	assert.ErrorIs(t, vm.Run(), ErrTypeMismatch)
+}
+
+func TestInstruction_Mnemonic(t *testing.T) {
+	for instr := range instructionGas {
+		mnemonic, ok := instr.Mnemonic()
+		require.True(t, ok, instr)
+		parsed, ok := ParseInstruction(mnemonic)
+		require.True(t, ok)
+		assert.Equal(t, instr, parsed)
+	}
+	_, ok := Instruction(0xff).Mnemonic()
+	assert.False(t, ok)
+	assert.Equal(t, "0xff", Instruction(0xff).String())
+}
+
+func TestVM_WideIntegers(t *testing.T) {
+	maxWord := bytes.Repeat([]byte{0xff}, wordSize)
+	tests := []struct {
+		name     string
+		data     []byte
+		expected any
+	}{
+		{name: "pushn", data: []byte{0x02, 0x27, 0x01, 0x2c}, expected: big.NewInt(300)},
+		// (300 * 300 - 1) / 2
+		{name: "chained", data: []byte{0x02, 0x27, 0x01, 0x2c, 0x1c, 0x11, 0x01, 0x0a, 0x0e, 0x02, 0x0a, 0x12}, expected: big.NewInt(44_999)},
+		{name: "overflow", data: slices.Concat([]byte{0x20, 0x27}, maxWord, []byte{0x01, 0x0a, 0x0b}), expected: new(big.Int)},
+		{name: "underflow", data: []byte{0x00, 0x0a, 0x01, 0x0a, 0x0e}, expected: new(big.Int).SetBytes(maxWord)},
+		{name: "to bytes", data: []byte{0x02, 0x27, 0x01, 0x2c, 0x29}, expected: word(big.NewInt(300))},
+		{name: "to int", data: []byte{0x01, 0x0c, 0x2c, 0x0c, 0x02, 0x0a, 0x0d, 0x28}, expected: big.NewInt(300)},
+		{name: "pack strings", data: []byte{0x61, 0x0c, 0x62, 0x0c, 0x02, 0x0a, 0x0d, 0x63, 0x0c, 0x02, 0x0a, 0x0d}, expected: []byte("abc")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(tt.data, NewState(), testGas, nil)
+			require.NoError(t, vm.Run())
+			require.Equal(t, 1, vm.stack.sp)
+			v := vm.stack.Shift()
+			if x, ok := v.(*big.Int); ok {
+				assert.Zero(t, x.Cmp(tt.expected.(*big.Int)), x)
+				return
+			}
+			assert.Equal(t, tt.expected, v)
+		})
+	}
+
+	// the bytes of pushn are stepped over
+	vm := NewVM([]byte{0x02, 0x27, 0x01, 0x15}, NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, testGas-4*GasStep-instructionGas[InstructionPushN], vm.GasLeft())
+	vm = NewVM([]byte{0x01, 0x27, 0x15, 0x02, 0x0a, 0x13}, NewState(), testGas, nil)
+	assert.ErrorIs(t, vm.Run(), ErrInvalidJump)
+
+	errs := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "pushn of 33 bytes", data: []byte{0x21, 0x27}, err: ErrIntOutOfRange},
+		{name: "pushn without bytes", data: []byte{0x02, 0x27, 0x01}, err: ErrMissingOperand},
+		{name: "too wide to int", data: slices.Concat(bytes.Repeat([]byte{0x01, 0x0c}, 33), []byte{0x21, 0x0a, 0x0d, 0x28}), err: ErrIntOutOfRange},
+		{name: "jump too far", data: []byte{0x05, 0x27, 0x01, 0x00, 0x00, 0x00, 0x00, 0x13}, err: ErrIntOutOfRange},
+	}
+	for _, tt := range errs {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(tt.data, NewState(), testGas, nil)
+			assert.ErrorIs(t, vm.Run(), tt.err)
+		})
+	}
+}
+
+// pushBytes is the code pushing b as a string, it must not follow an
+// instruction other than a push when b starts with a push opcode
+func pushBytes(b []byte) []byte {
+	code := make([]byte, 0, 2*len(b)+3)
+	for _, c := range b {
+		code = append(code, c, byte(InstructionPushByte))
+	}
+	return append(code, byte(len(b)), byte(InstructionPushInt), byte(InstructionPack))
+}
+
+func TestVM_Crypto(t *testing.T) {
+	abc := sha256.Sum256([]byte("abc"))
+	one := sha256.Sum256(word(big.NewInt(1)))
+	vm := NewVM(slices.Concat(pushBytes([]byte("abc")), []byte{0x2a}), NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, abc[:], vm.stack.Shift())
+	vm = NewVM([]byte{0x01, 0x0a, 0x2a}, NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, one[:], vm.stack.Shift())
+
+	// hashing pays for every word
+	data := slices.Concat(pushBytes(bytes.Repeat([]byte{'a'}, wordSize+1)), []byte{0x2a})
+	vm = NewVM(data, NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	gas := uint64(len(data))*GasStep + (wordSize+1)*instructionGas[InstructionPushByte] + instructionGas[InstructionPushInt] +
+		instructionGas[InstructionPack] + (wordSize+1)*GasPackByte + instructionGas[InstructionSha256] + 2*GasHashWord
+	assert.Equal(t, testGas-gas, vm.GasLeft())
+
+	seed := bytes.Repeat([]byte{0x02}, 32)
+	key, err := crypto.NewPrivateKeyFromSeed(seed)
+	require.NoError(t, err)
+	msg := []byte("hi")
+	sig := key.Sign(msg).Bytes()
+	verify := func(msg, sig, key []byte) *big.Int {
+		data := slices.Concat(pushBytes(msg), pushBytes(sig), pushBytes(key), []byte{0x2b})
+		vm := NewVM(data, NewState(), testGas, nil)
+		require.NoError(t, vm.Run())
+		return vm.stack.Shift().(*big.Int)
+	}
+	require.False(t, isPush(Instruction(sig[0])) || isPush(Instruction(key.PublicKey().Key[0])))
+	assert.Equal(t, int64(1), verify(msg, sig, key.PublicKey().Bytes()).Int64())
+	assert.Equal(t, int64(0), verify([]byte("ho"), sig, key.PublicKey().Bytes()).Int64())
+	assert.Equal(t, int64(0), verify(msg, sig[1:], key.PublicKey().Bytes()).Int64())
+	assert.Equal(t, int64(0), verify(msg, sig, []byte("key")).Int64())
+
+	// the key and the signature must be bytes
+	vm = NewVM(slices.Concat(pushBytes(msg), pushBytes(sig), []byte{0x01, 0x0a, 0x2b}), NewState(), testGas, nil)
+	assert.ErrorIs(t, vm.Run(), ErrTypeMismatch)
+}
+
+func TestVM_Call(t *testing.T) {
+	caller := types.Address{0xa1}
+	callee := types.Address{0xb1}
+	// call callee with "x", a gas allowance of 200 and a value of 5
+	callCode := func(to types.Address) []byte {
+		return slices.Concat([]byte{'x', 0x0c, 0xc8, 0x0a, 0x05, 0x0a}, pushBytes(to.Bytes()), []byte{0x2c})
+	}
+	run := func(code []byte, ctx *VMContext) *VM {
+		state := ctx.State
+		require.NoError(t, state.code.Put(callee.Bytes(), code))
+		vm := NewVM(callCode(callee), state.contractStorage(caller), testGas, ctx)
+		require.NoError(t, vm.Run())
+		return vm
+	}
+	newCtx := func() *VMContext {
+		state := NewWorldState()
+		require.NoError(t, state.accounts.AddBalance(caller, 10))
+		return &VMContext{Caller: types.Address{1}, Contract: caller, State: state}
+	}
+	balance := func(ctx *VMContext, addr types.Address) uint64 {
+		balance, err := ctx.State.accounts.GetBalance(addr)
+		require.NoError(t, err)
+		return balance
+	}
+
+	// store 1 under K, log "ev" and return the caller
+	ctx := newCtx()
+	vm := run([]byte{
+		0x01, 0x0a, 'K', 0x0c, 0x0f,
+		0x00, 0x0a, 0x0d, 'e', 0x0c, 'v', 0x0c, 0x02, 0x0a, 0x0d, 0x26,
+		0x21, 0x20,
+	}, ctx)
+	assert.Equal(t, []any{caller.Bytes(), big.NewInt(1)}, []any{vm.stack.Shift(), vm.stack.Shift()})
+	value, err := ctx.State.contractStorage(callee).Get([]byte("K"))
+	require.NoError(t, err)
+	assert.Equal(t, word(big.NewInt(1)), value)
+	_, err = ctx.State.contractStorage(caller).Get([]byte("K"))
+	assert.Error(t, err)
+	require.Len(t, vm.Logs(), 1)
+	assert.Equal(t, callee, vm.Logs()[0].Contract)
+	assert.Equal(t, uint64(5), balance(ctx, caller))
+	assert.Equal(t, uint64(5), balance(ctx, callee))
+
+	// a failed call is reverted and the caller goes on
+	ctx = newCtx()
+	vm = run([]byte{
+		0x01, 0x0a, 'K', 0x0c, 0x0f,
+		0x00, 0x0a, 0x0d, 'e', 0x0c, 'v', 0x0c, 0x02, 0x0a, 0x0d, 0x26,
+		0x01, 0x0a, 0x00, 0x0a, 0x12,
+	}, ctx)
+	assert.Equal(t, []any{[]byte{}, new(big.Int)}, []any{vm.stack.Shift(), vm.stack.Shift()})
+	_, err = ctx.State.contractStorage(callee).Get([]byte("K"))
+	assert.Error(t, err)
+	assert.Empty(t, vm.Logs())
+	assert.Equal(t, uint64(10), balance(ctx, caller))
+
+	// the callee can only use its allowance
+	ctx = newCtx()
+	empty := NewVM(callCode(types.Address{0xc1}), NewState(), testGas, ctx)
+	require.NoError(t, empty.Run())
+	assert.Equal(t, []any{[]byte{}, big.NewInt(1)}, []any{empty.stack.Shift(), empty.stack.Shift()})
+	vm = run([]byte{0x15, 0x00, 0x0a, 0x13}, newCtx())
+	assert.Equal(t, big.NewInt(0), vm.stack.data[1])
+	assert.Equal(t, empty.GasLeft()-200, vm.GasLeft())
+
+	// calls cannot go deeper than maxCallDepth
+	ctx = newCtx()
+	ctx.depth = maxCallDepth
+	vm = run([]byte{0x21, 0x20}, ctx)
+	assert.Equal(t, big.NewInt(0), vm.stack.data[1])
+	assert.Equal(t, uint64(10), balance(ctx, caller))
+
+	// the value must be owned by the caller
+	ctx = newCtx()
+	require.NoError(t, ctx.State.accounts.SubBalance(caller, 6))
+	vm = run([]byte{0x21, 0x20}, ctx)
+	assert.Equal(t, big.NewInt(0), vm.stack.data[1])
+
+	vm = NewVM(callCode(callee), NewState(), testGas, nil)
+	assert.ErrorIs(t, vm.Run(), ErrNoState)
+}
+
+func TestVM_Transfer(t *testing.T) {
+	contract := types.Address{0xa1}
+	to := types.Address{0xb1}
+	state := NewWorldState()
+	require.NoError(t, state.accounts.AddBalance(contract, 10))
+	ctx := &VMContext{Contract: contract, State: state}
+	transfer := func(amount byte, to []byte) error {
+		data := slices.Concat([]byte{amount, 0x0a}, pushBytes(to), []byte{0x2d})
+		return NewVM(data, NewState(), testGas, ctx).Run()
+	}
+
+	require.NoError(t, transfer(3, to.Bytes()))
+	balance, err := state.accounts.GetBalance(contract)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), balance)
+	balance, err = state.accounts.GetBalance(to)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), balance)
+
+	assert.ErrorIs(t, transfer(8, to.Bytes()), ErrInsufficientBalance)
+	assert.ErrorIs(t, transfer(1, []byte("to")), ErrInvalidAddress)
+	ctx.State = nil
+	assert.ErrorIs(t, transfer(1, to.Bytes()), ErrNoState)
+}
+
+func TestVM_CallData(t *testing.T) {
+	ctx := &VMContext{Input: []byte{0xaa, 0xbb, 0xcc}}
+	// the 2 bytes at 1, the 4 bytes at 2 then the size
+	vm := NewVM([]byte{0x01, 0x0a, 0x02, 0x0a, 0x2e, 0x02, 0x0a, 0x04, 0x0a, 0x2e, 0x2f}, NewState(), testGas, ctx)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, []byte{0xbb, 0xcc}, vm.stack.Shift())
+	assert.Equal(t, []byte{0xcc, 0x00, 0x00, 0x00}, vm.stack.Shift())
+	assert.Equal(t, big.NewInt(3), vm.stack.Shift())
+
+	// past the end and without call data
+	vm = NewVM([]byte{0x09, 0x0a, 0x02, 0x0a, 0x2e, 0x2f}, NewState(), testGas, nil)
+	require.NoError(t, vm.Run())
+	assert.Equal(t, []byte{0x00, 0x00}, vm.stack.Shift())
+	assert.Equal(t, new(big.Int), vm.stack.Shift())
+
+	// every byte is paid for
+	vm = NewVM([]byte{0x00, 0x0a, 0x02, 0x27, 0x00, 0xff, 0x2e}, NewState(), 100, ctx)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+type walRecordType byte
+
+const (
+	walRecordBegin  walRecordType = 0x01
+	walRecordCommit walRecordType = 0x02
+)
+
+// type(1) + seq(8) + payload length(4) + crc32(4)
+const walRecordHeaderLen = 17
+
+// WAL is a write-ahead log of block commits. Every commit is logged with
+// Begin before the block is written anywhere else, and marked with Commit
+// once it is durable, so that half-written commits can be found on startup.
+type WAL struct {
+	f    *os.File
+	size int64
+	seq  uint64
+}
+
+func OpenWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &WAL{
+		f:    f,
+		size: info.Size(),
+	}, nil
+}
+
+func (w *WAL) append(typ walRecordType, seq uint64, payload []byte) error {
+	record := make([]byte, walRecordHeaderLen+len(payload))
+	record[0] = byte(typ)
+	binary.LittleEndian.PutUint64(record[1:9], seq)
+	binary.LittleEndian.PutUint32(record[9:13], uint32(len(payload)))
+	copy(record[walRecordHeaderLen:], payload)
+	crc := crc32.NewIEEE()
+	crc.Write(record[:13])
+	crc.Write(payload)
+	binary.LittleEndian.PutUint32(record[13:17], crc.Sum32())
+
+	if _, err := w.f.WriteAt(record, w.size); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.size += int64(len(record))
+	return nil
+}
+
+// Begin logs the payload of a commit and returns its sequence number
+func (w *WAL) Begin(payload []byte) (uint64, error) {
+	w.seq++
+	return w.seq, w.append(walRecordBegin, w.seq, payload)
+}
+
+// Commit marks the commit seq as durable
+func (w *WAL) Commit(seq uint64) error {
+	return w.append(walRecordCommit, seq, nil)
+}
+
+// Recover reads the log and returns the payloads that were begun but never
+// committed, in log order. A torn or corrupted tail is discarded.
+func (w *WAL) Recover() ([][]byte, error) {
+	var (
+		offset  int64
+		order   []uint64
+		pending = make(map[uint64][]byte)
+		header  = make([]byte, walRecordHeaderLen)
+	)
+	for offset < w.size {
+		if _, err := w.f.ReadAt(header, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint32(header[9:13])
+		if offset+walRecordHeaderLen+int64(size) > w.size {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := w.f.ReadAt(payload, offset+walRecordHeaderLen); err != nil {
+			return nil, err
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[:13])
+		crc.Write(payload)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header[13:17]) {
+			break
+		}
+
+		seq := binary.LittleEndian.Uint64(header[1:9])
+		switch walRecordType(header[0]) {
+		case walRecordBegin:
+			pending[seq] = payload
+			order = append(order, seq)
+		case walRecordCommit:
+			delete(pending, seq)
+		default:
+			return nil, ErrWALCorrupted
+		}
+		if seq > w.seq {
+			w.seq = seq
+		}
+		offset += walRecordHeaderLen + int64(size)
+	}
+	if offset < w.size {
+		if err := w.f.Truncate(offset); err != nil {
+			return nil, err
+		}
+		w.size = offset
+	}
+
+	payloads := make([][]byte, 0, len(pending))
+	for _, seq := range order {
+		if payload, ok := pending[seq]; ok {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads, nil
+}
+
+// Size returns the current length of the log in bytes
+func (w *WAL) Size() int64 {
+	return w.size
+}
+
+// Checkpoint empties the log, callers must make sure every commit logged so
+// far is durable elsewhere.
+func (w *WAL) Checkpoint() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.f.Sync()
+}
+
+func (w *WAL) Close() error {
+	return w.f.Close()
+}
+
+var (
+	ErrWALCorrupted = errors.New("write-ahead log corrupted")
+)
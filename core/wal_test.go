@@ -0,0 +1,65 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_RecoverPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+
+	seq, err := wal.Begin([]byte("committed"))
+	require.NoError(t, err)
+	require.NoError(t, wal.Commit(seq))
+	_, err = wal.Begin([]byte("pending"))
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	wal, err = OpenWAL(path)
+	require.NoError(t, err)
+	defer wal.Close()
+	payloads, err := wal.Recover()
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "pending", string(payloads[0]))
+
+	// sequence numbers continue after the recovered ones
+	next, err := wal.Begin([]byte("next"))
+	require.NoError(t, err)
+	assert.Equal(t, seq+2, next)
+}
+
+func TestWAL_DiscardTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+	_, err = wal.Begin([]byte("first"))
+	require.NoError(t, err)
+	size := wal.Size()
+	_, err = wal.Begin([]byte("second"))
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	// cut the second record in half
+	require.NoError(t, os.Truncate(path, size+walRecordHeaderLen+2))
+
+	wal, err = OpenWAL(path)
+	require.NoError(t, err)
+	defer wal.Close()
+	payloads, err := wal.Recover()
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "first", string(payloads[0]))
+	assert.Equal(t, size, wal.Size())
+
+	require.NoError(t, wal.Checkpoint())
+	payloads, err = wal.Recover()
+	require.NoError(t, err)
+	assert.Empty(t, payloads)
+}
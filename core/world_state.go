@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"slices"
+	"sync"
+
+	"github.com/matrix-go/block/trie"
+	"github.com/matrix-go/block/types"
+)
+
+// hashStore is a journaled store of gob encoded values keyed by hash, used
+// for the NFT stores. The values are kept in a trie, so that copying the
+// store is as cheap as copying the other states.
+type hashStore[T any] struct {
+	lock    sync.RWMutex
+	trie    *trie.Trie
+	journal *Journal
+}
+
+func newHashStore[T any](journal *Journal) *hashStore[T] {
+	return &hashStore[T]{
+		trie:    trie.New(),
+		journal: journal,
+	}
+}
+
+// copy returns a store with the content of s whose changes are recorded
+// in journal
+func (s *hashStore[T]) copy(journal *Journal) *hashStore[T] {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &hashStore[T]{trie: s.trie.Copy(), journal: journal}
+}
+
+func (s *hashStore[T]) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	_, ok := s.trie.Get(hash.Bytes())
+	return ok
+}
+
+func (s *hashStore[T]) Get(hash types.Hash) (T, bool, error) {
+	var v T
+	s.lock.RLock()
+	b, ok := s.trie.Get(hash.Bytes())
+	s.lock.RUnlock()
+	if !ok {
+		return v, false, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v); err != nil {
+		return v, false, err
+	}
+	return v, true, nil
+}
+
+func (s *hashStore[T]) Put(hash types.Hash, v T) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	prev := s.trie.Copy()
+	s.journal.record(func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.trie = prev
+	})
+	s.trie.Put(hash.Bytes(), buf.Bytes())
+	return nil
+}
+
+// WorldState groups every piece of state changed by executing blocks,
+// so that the changes of a block are committed or reverted together.
+type WorldState struct {
+	accounts    *AccountState
+	contracts   *State
+	code        *State // code of the deployed contracts by address
+	collections *hashStore[*CollectionTx]
+	mints       *hashStore[*MintTx]
+	journal     *Journal
+}
+
+func NewWorldState() *WorldState {
+	journal := NewJournal()
+	accounts := NewAccountState()
+	accounts.setJournal(journal)
+	contracts := NewState()
+	contracts.setJournal(journal)
+	code := NewState()
+	code.setJournal(journal)
+	return &WorldState{
+		accounts:    accounts,
+		contracts:   contracts,
+		code:        code,
+		collections: newHashStore[*CollectionTx](journal),
+		mints:       newHashStore[*MintTx](journal),
+		journal:     journal,
+	}
+}
+
+// Copy returns a world state with the content of ws, changing one does not
+// change the other
+func (ws *WorldState) Copy() *WorldState {
+	journal := NewJournal()
+	return &WorldState{
+		accounts:    ws.accounts.copy(journal),
+		contracts:   ws.contracts.copy(journal),
+		code:        ws.code.copy(journal),
+		collections: ws.collections.copy(journal),
+		mints:       ws.mints.copy(journal),
+		journal:     journal,
+	}
+}
+
+func (ws *WorldState) Snapshot() int {
+	return ws.journal.Snapshot()
+}
+
+func (ws *WorldState) RevertToSnapshot(id int) {
+	ws.journal.RevertToSnapshot(id)
+}
+
+// Commit returns what undoes the committed changes
+func (ws *WorldState) Commit() []func() {
+	return ws.journal.Commit()
+}
+
+// Root commits to the account state, the contract state and the code of
+// the contracts
+func (ws *WorldState) Root() types.Hash {
+	accounts := ws.accounts.Root()
+	contracts := ws.contracts.Root()
+	code := ws.code.Root()
+	return sha256.Sum256(slices.Concat(accounts.Bytes(), contracts.Bytes(), code.Bytes()))
+}
+
+// contractStorage is the storage of the contract at addr
+func (ws *WorldState) contractStorage(addr types.Address) *contractStorage {
+	return newContractStorage(ws.contracts, addr)
+}
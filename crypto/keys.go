@@ -138,7 +138,12 @@ func (s Signature) String() string {
 	return "0x" + hex.EncodeToString(s.Value)
 }
 
+// Verify reports whether s is the signature of msg by pubKey, a malformed
+// key is never valid
 func (s Signature) Verify(pubKey *PublicKey, msg []byte) bool {
+	if pubKey == nil || len(pubKey.Key) != kPublicKeyLen {
+		return false
+	}
 	return ed25519.Verify(pubKey.Key, msg, s.Value)
 }
 
@@ -49,6 +49,10 @@ func TestVerifySignature(t *testing.T) {
 	privKey, err = GeneratePrivateKey()
 	require.NoError(t, err)
 	assert.False(t, sig.Verify(privKey.PublicKey(), msg))
+
+	// test with malformed Key
+	assert.False(t, sig.Verify(&PublicKey{Key: []byte{1, 2, 3}}, msg))
+	assert.False(t, sig.Verify(nil, msg))
 }
 
 func TestPublicKeyToAddress(t *testing.T) {
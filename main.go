@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"github.com/matrix-go/block/asm"
 	"github.com/matrix-go/block/core"
 	"github.com/matrix-go/block/crypto"
 	"github.com/matrix-go/block/types"
@@ -11,6 +14,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -27,7 +31,33 @@ var transports = []network.Transport{
 
 var peers []network.Peer
 
+var (
+	genesisPath = flag.String("genesis", "", "genesis file, a single validator network is used if empty")
+	keySeed     = flag.String("key", "", "hex seed of the validator key, generated if empty")
+	dataDir     = flag.String("datadir", "", "directory keeping the blocks of each server, in memory if empty")
+)
+
+var (
+	genesis   *core.Genesis
+	validator *crypto.PrivateKey
+)
+
 func main() {
+	flag.Parse()
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	var err error
+	if validator, err = loadValidatorKey(*keySeed); err != nil {
+		panic(err)
+	}
+	if genesis, err = loadGenesis(*genesisPath, validator); err != nil {
+		panic(err)
+	}
 
 	//servers := initLocalTransportServers()
 
@@ -58,11 +88,7 @@ func initLocalTransportServers() []*network.Server {
 	//localPeer := peers[0]
 
 	// local validator node
-	privateKey, err := crypto.GeneratePrivateKey()
-	if err != nil {
-		panic(err)
-	}
-	localSrv := makeServer("LOCAL", privateKey, localTr, []network.Peer{}, ":9000")
+	localSrv := makeServer("LOCAL", validator, localTr, []network.Peer{}, ":9000")
 	go localSrv.Start()
 
 	// remote node send transaction
@@ -111,10 +137,6 @@ func initTcpTransportSevers() []*network.Server {
 	//localPeer := network.NewTcpPeer(localTr.Addr())
 
 	// local validator node
-	validator, err := crypto.GeneratePrivateKey()
-	if err != nil {
-		panic(err)
-	}
 	localApiAddr := ":9000"
 	localServer := makeServer("LOCAL", validator, localTr, nil, localApiAddr)
 	go localServer.Start()
@@ -151,7 +173,7 @@ func initTcpTransportSevers() []*network.Server {
 	//sendTick := time.NewTicker(time.Second)
 	//go func() {
 	//	for i := 0; i < 10; i++ {
-	//		if err = sendMintTxThroughAPI(privateKey, collection); err != nil {
+	//		if err = sendMintTxThroughAPI(privateKey, collection, uint64(i+1)); err != nil {
 	//			logrus.Error(err)
 	//		}
 	//		<-sendTick.C
@@ -195,9 +217,13 @@ func makeServer(id string, privateKey *crypto.PrivateKey, tr network.Transport,
 		ID:         id,
 		Transport:  tr,
 		SeedPeers:  peers,
-		BlockTime:  time.Second * 5,
 		PrivateKey: privateKey,
 		ApiAddr:    apiAddr,
+		Genesis:    genesis,
+	}
+	if *dataDir != "" {
+		// every server keeps its own blocks
+		opt.DataDir = filepath.Join(*dataDir, id)
 	}
 
 	server, err := network.NewServer(opt)
@@ -207,8 +233,38 @@ func makeServer(id string, privateKey *crypto.PrivateKey, tr network.Transport,
 	return server
 }
 
+func loadValidatorKey(seed string) (*crypto.PrivateKey, error) {
+	if seed == "" {
+		return crypto.GeneratePrivateKey()
+	}
+	return crypto.NewPrivateKeyFromString(seed)
+}
+
+// loadGenesis reads the genesis file, without one the validator is the
+// only validator of the network and owns all of its funds
+func loadGenesis(path string, validator *crypto.PrivateKey) (*core.Genesis, error) {
+	if path != "" {
+		return core.LoadGenesis(path)
+	}
+	key := hex.EncodeToString(validator.PublicKey().Bytes())
+	return &core.Genesis{
+		ChainID:    1,
+		BlockTime:  core.Duration{Duration: 5 * time.Second},
+		Validators: []string{key},
+		Alloc:      map[string]uint64{key: 10_000_000},
+		Issuance: core.Issuance{
+			Schedule: core.IssuanceHalving,
+			Reward:   50,
+			Interval: 100_000,
+		},
+	}, nil
+}
+
 func sendTransaction(tr network.Transport, to network.Peer) error {
 	tx := core.NewTransaction(contract())
+	tx.ChainID = genesis.ChainID
+	tx.GasPrice = genesis.MinimumGasPrice()
+	tx.GasLimit = 10_000
 	privateKey, err := crypto.GeneratePrivateKey()
 	if err != nil {
 		return fmt.Errorf("failed to generate private key: %s", err)
@@ -230,6 +286,8 @@ func sendCollectionTxThroughAPI(privateKey *crypto.PrivateKey) (hash types.Hash,
 		Metadata: []byte("chicken and egg collection"), // collection name
 	}
 	tx := core.NewTransaction(nil)
+	tx.ChainID = genesis.ChainID
+	tx.GasPrice = genesis.MinimumGasPrice()
 	tx.InnerTx = collectionTx
 
 	if err := tx.Sign(privateKey); err != nil {
@@ -255,7 +313,7 @@ func sendCollectionTxThroughAPI(privateKey *crypto.PrivateKey) (hash types.Hash,
 	return tx.GetHash(core.NewTransactionHasher()), nil
 }
 
-func sendMintTxThroughAPI(privateKey *crypto.PrivateKey, collection types.Hash) error {
+func sendMintTxThroughAPI(privateKey *crypto.PrivateKey, collection types.Hash, nonce uint64) error {
 
 	metadata := map[string]any{
 		"power":  8,
@@ -276,7 +334,10 @@ func sendMintTxThroughAPI(privateKey *crypto.PrivateKey, collection types.Hash)
 		CollectionOwner: *privateKey.PublicKey(),
 	}
 	tx := core.NewTransaction(nil)
+	tx.ChainID = genesis.ChainID
+	tx.GasPrice = genesis.MinimumGasPrice()
 	tx.InnerTx = mintTx
+	tx.Nonce = nonce
 
 	if err := tx.Sign(privateKey); err != nil {
 		return fmt.Errorf("failed to sign tx: %s", err)
@@ -307,6 +368,8 @@ func sendTransactionThroughAPI() error {
 	//	Metadata: []byte("chicken and egg collection"), // collection name
 	//}
 	tx := core.NewTransaction(nil)
+	tx.ChainID = genesis.ChainID
+	tx.GasPrice = genesis.MinimumGasPrice()
 	//tx.InnerTx = collectionTx
 	//tx.InnerType = core.InnerTxTypeCollection
 
@@ -340,6 +403,8 @@ func sendTransactionThroughAPI() error {
 func sendTransactionWithMoneyThroughAPI(from *crypto.PrivateKey, to *crypto.PublicKey, amount uint64) error {
 
 	tx := core.NewTransaction(nil)
+	tx.ChainID = genesis.ChainID
+	tx.GasPrice = genesis.MinimumGasPrice()
 	tx.From = from.PublicKey()
 	tx.To = to
 	tx.Value = amount
@@ -368,14 +433,18 @@ func sendTransactionWithMoneyThroughAPI(from *crypto.PrivateKey, to *crypto.Publ
 }
 
 func contract() []byte {
-	return []byte{
-		0x03, 0x0a, 0x02, 0x0a, 0x0e, // push 3, push 2 and sub
-		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, // push FOO and pack
-		0x0f,                         // store [FOO,1]
-		0x03, 0x0a, 0x02, 0x0a, 0x0b, // push 3, push 2 and add
-		0x46, 0x0c, 0x4f, 0x0c, 0x4d, 0x0c, 0x03, 0x0a, 0x0d, // push FOM and pack
-		0x0f,                                                 // store [FOM,1]
-		0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, // push FOO and pack
-		0x10, // get foo
-	}
+	return asm.MustAssemble(`
+		push 3
+		push 2
+		sub
+		push "FOO"
+		store     ; [FOO,1]
+		push 3
+		push 2
+		add
+		push "FOM"
+		store     ; [FOM,5]
+		push "FOO"
+		get
+	`)
 }
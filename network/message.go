@@ -1,8 +1,12 @@
 package network
 
-import "github.com/matrix-go/block/core"
+import (
+	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/types"
+)
 
 type GetStatusMessage struct {
+	ChainID uint64 // chain of the asking server
 }
 
 func NewGetStatusMessage() *GetStatusMessage {
@@ -11,6 +15,7 @@ func NewGetStatusMessage() *GetStatusMessage {
 
 type StatusMessage struct {
 	ID      string // id of server
+	ChainID uint64
 	Version uint32
 	Height  uint64
 }
@@ -40,3 +45,15 @@ func NewBlockMessage(data []*core.Block) *BlockMessage {
 		Data: data,
 	}
 }
+
+// GetBlockByHashMessage asks a peer for a block that we are missing,
+// the peer answers with a block message
+type GetBlockByHashMessage struct {
+	Hash types.Hash
+}
+
+func NewGetBlockByHashMessage(hash types.Hash) *GetBlockByHashMessage {
+	return &GetBlockByHashMessage{
+		Hash: hash,
+	}
+}
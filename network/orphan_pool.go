@@ -0,0 +1,191 @@
+package network
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/types"
+)
+
+const (
+	defaultMaxOrphans        = 256
+	defaultMaxOrphansPerPeer = 64
+	defaultMaxOrphanBytes    = 32 << 20
+	defaultOrphanTTL         = 10 * time.Minute
+)
+
+type orphanBlock struct {
+	block   *core.Block
+	hash    types.Hash
+	from    NetAddr
+	size    int
+	expires time.Time
+}
+
+// OrphanPool holds the blocks whose parent is not known yet, keyed by
+// PrevHash so that they can be connected once the parent arrives. A peer
+// only holds so many orphans, its oldest one makes room for the next.
+type OrphanPool struct {
+	lock    sync.Mutex
+	orphans map[types.Hash]*orphanBlock
+	byPrev  map[types.Hash][]*orphanBlock
+	byPeer  map[NetAddr]int
+	// order is the arrival order, the oldest orphans are evicted first
+	order []*orphanBlock
+	size  int
+
+	maxBlocks  int
+	maxPerPeer int
+	maxBytes   int
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+func NewOrphanPool(maxBlocks, maxPerPeer, maxBytes int, ttl time.Duration) *OrphanPool {
+	return &OrphanPool{
+		orphans:    make(map[types.Hash]*orphanBlock),
+		byPrev:     make(map[types.Hash][]*orphanBlock),
+		byPeer:     make(map[NetAddr]int),
+		order:      make([]*orphanBlock, 0),
+		maxBlocks:  maxBlocks,
+		maxPerPeer: maxPerPeer,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+// Add keeps block sent by from until its parent arrives, it reports false
+// when the block is already in the pool or too big to be kept
+func (p *OrphanPool) Add(from NetAddr, block *core.Block) bool {
+	var buf bytes.Buffer
+	if err := block.Encode(core.NewGobBlockEncoder(&buf)); err != nil {
+		return false
+	}
+	orphan := &orphanBlock{
+		block: block,
+		hash:  block.GetHash(core.NewHeaderHasher()),
+		from:  from,
+		size:  buf.Len(),
+	}
+	if orphan.size > p.maxBytes {
+		return false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, exists := p.orphans[orphan.hash]; exists {
+		return false
+	}
+	now := p.now()
+	p.expire(now)
+	if p.byPeer[from] >= p.maxPerPeer {
+		for _, o := range p.order {
+			if o.from == from {
+				p.remove(o)
+				break
+			}
+		}
+	}
+	for len(p.order) > 0 && (len(p.order) >= p.maxBlocks || p.size+orphan.size > p.maxBytes) {
+		p.remove(p.order[0])
+	}
+	orphan.expires = now.Add(p.ttl)
+	p.orphans[orphan.hash] = orphan
+	p.byPrev[block.PrevHash] = append(p.byPrev[block.PrevHash], orphan)
+	p.order = append(p.order, orphan)
+	p.byPeer[from]++
+	p.size += orphan.size
+	return true
+}
+
+func (p *OrphanPool) Contains(hash types.Hash) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, exists := p.orphans[hash]
+	return exists
+}
+
+// Take removes and returns the orphans whose parent is the given block
+func (p *OrphanPool) Take(parent types.Hash) []*core.Block {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.expire(p.now())
+	children := p.byPrev[parent]
+	blocks := make([]*core.Block, 0, len(children))
+	for _, orphan := range children {
+		blocks = append(blocks, orphan.block)
+	}
+	for len(p.byPrev[parent]) > 0 {
+		p.remove(p.byPrev[parent][0])
+	}
+	return blocks
+}
+
+// MissingAncestor returns the hash of the block that the branch of the
+// orphan hash is waiting for
+func (p *OrphanPool) MissingAncestor(hash types.Hash) (types.Hash, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	orphan, exists := p.orphans[hash]
+	if !exists {
+		return types.Hash{}, false
+	}
+	for {
+		parent, exists := p.orphans[orphan.block.PrevHash]
+		if !exists {
+			return orphan.block.PrevHash, true
+		}
+		orphan = parent
+	}
+}
+
+func (p *OrphanPool) Count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.order)
+}
+
+// Size is the encoded size of the orphans in bytes
+func (p *OrphanPool) Size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.size
+}
+
+// expire must be called with the lock held
+func (p *OrphanPool) expire(now time.Time) {
+	for len(p.order) > 0 && !now.Before(p.order[0].expires) {
+		p.remove(p.order[0])
+	}
+}
+
+// remove must be called with the lock held
+func (p *OrphanPool) remove(orphan *orphanBlock) {
+	delete(p.orphans, orphan.hash)
+	prev := orphan.block.PrevHash
+	siblings := p.byPrev[prev]
+	for i, sibling := range siblings {
+		if sibling == orphan {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byPrev, prev)
+	} else {
+		p.byPrev[prev] = siblings
+	}
+	for i, o := range p.order {
+		if o == orphan {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+	if p.byPeer[orphan.from]--; p.byPeer[orphan.from] == 0 {
+		delete(p.byPeer, orphan.from)
+	}
+	p.size -= orphan.size
+}
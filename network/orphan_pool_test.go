@@ -0,0 +1,106 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomOrphan(height uint64, prevHash types.Hash) *core.Block {
+	return core.NewBlock(&core.Header{
+		Version:   1,
+		Height:    height,
+		PrevHash:  prevHash,
+		DataHash:  types.RandomHash(),
+		Timestamp: uint64(time.Now().UnixNano()),
+	})
+}
+
+func TestOrphanPool_AddTake(t *testing.T) {
+	pool := NewOrphanPool(10, 10, 1<<20, time.Minute)
+	missing := types.RandomHash()
+	first := randomOrphan(5, missing)
+	firstHash := first.GetHash(core.NewHeaderHasher())
+	second := randomOrphan(6, firstHash)
+	sibling := randomOrphan(6, firstHash)
+
+	for _, b := range []*core.Block{first, second, sibling} {
+		require.True(t, pool.Add("PEER", b))
+	}
+	assert.False(t, pool.Add("PEER", first))
+	assert.Equal(t, 3, pool.Count())
+
+	ancestor, ok := pool.MissingAncestor(second.GetHash(core.NewHeaderHasher()))
+	require.True(t, ok)
+	assert.Equal(t, missing, ancestor)
+
+	assert.Equal(t, []*core.Block{first}, pool.Take(missing))
+	assert.ElementsMatch(t, []*core.Block{second, sibling}, pool.Take(firstHash))
+	assert.Equal(t, 0, pool.Count())
+	assert.Equal(t, 0, pool.Size())
+	assert.Empty(t, pool.Take(firstHash))
+}
+
+func TestOrphanPool_Limits(t *testing.T) {
+	pool := NewOrphanPool(2, 2, 1<<20, time.Minute)
+	blocks := []*core.Block{
+		randomOrphan(1, types.RandomHash()),
+		randomOrphan(1, types.RandomHash()),
+		randomOrphan(1, types.RandomHash()),
+	}
+	for _, b := range blocks {
+		require.True(t, pool.Add("PEER", b))
+	}
+	// the oldest orphan makes room
+	assert.Equal(t, 2, pool.Count())
+	assert.False(t, pool.Contains(blocks[0].GetHash(core.NewHeaderHasher())))
+	assert.True(t, pool.Contains(blocks[2].GetHash(core.NewHeaderHasher())))
+
+	size := pool.Size() / 2
+	pool = NewOrphanPool(10, 10, size+size/2, time.Minute)
+	require.True(t, pool.Add("PEER", blocks[0]))
+	require.True(t, pool.Add("PEER", blocks[1]))
+	assert.Equal(t, 1, pool.Count())
+
+	pool = NewOrphanPool(10, 10, size-1, time.Minute)
+	assert.False(t, pool.Add("PEER", blocks[0]))
+}
+
+func TestOrphanPool_Expire(t *testing.T) {
+	pool := NewOrphanPool(10, 10, 1<<20, time.Minute)
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	prevHash := types.RandomHash()
+	require.True(t, pool.Add("PEER", randomOrphan(1, prevHash)))
+	now = now.Add(time.Minute)
+	assert.Empty(t, pool.Take(prevHash))
+	assert.Equal(t, 0, pool.Count())
+}
+
+func TestOrphanPool_LimitPerPeer(t *testing.T) {
+	pool := NewOrphanPool(10, 2, 1<<20, time.Minute)
+	blocks := []*core.Block{
+		randomOrphan(1, types.RandomHash()),
+		randomOrphan(1, types.RandomHash()),
+		randomOrphan(1, types.RandomHash()),
+	}
+	other := randomOrphan(1, types.RandomHash())
+	require.True(t, pool.Add("OTHER", other))
+	for _, b := range blocks {
+		require.True(t, pool.Add("PEER", b))
+	}
+	// the oldest orphan of the peer makes room, not the one of another peer
+	assert.Equal(t, 3, pool.Count())
+	assert.True(t, pool.Contains(other.GetHash(core.NewHeaderHasher())))
+	assert.False(t, pool.Contains(blocks[0].GetHash(core.NewHeaderHasher())))
+	assert.True(t, pool.Contains(blocks[2].GetHash(core.NewHeaderHasher())))
+
+	pool.Take(blocks[1].PrevHash)
+	pool.Take(blocks[2].PrevHash)
+	assert.Empty(t, pool.byPeer["PEER"])
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/types"
 	"io"
 )
 
@@ -49,7 +50,11 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodeMessage, error) {
 			Data: block,
 		}, nil
 	case MessageTypeGetStatus:
-		return &DecodeMessage{From: rpc.From, Data: NewGetStatusMessage()}, nil
+		gstMsg := NewGetStatusMessage()
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(gstMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode get status: %s", err)
+		}
+		return &DecodeMessage{From: rpc.From, Data: gstMsg}, nil
 	case MessageTypeStatus:
 		// get status of current block
 		stsMsg := NewStatusMessage()
@@ -79,10 +84,18 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodeMessage, error) {
 			From: rpc.From,
 			Data: blkMsg,
 		}, nil
+	case MessageTypeGetBlockByHash:
+		gbhMsg := NewGetBlockByHashMessage(types.Hash{})
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(gbhMsg); err != nil {
+			return nil, fmt.Errorf("failed to decode get block by hash: %s", err)
+		}
+		return &DecodeMessage{
+			From: rpc.From,
+			Data: gbhMsg,
+		}, nil
 	default:
 		return nil, fmt.Errorf("uinknown message type %v", msg.Header)
 	}
-	return nil, nil
 }
 
 type RPCProcessor interface {
@@ -118,4 +131,6 @@ const (
 	MessageTypeBlocks    MessageType = 0x06
 	MessageTypeStatus    MessageType = 0x04
 	MessageTypeGetStatus MessageType = 0x05
+
+	MessageTypeGetBlockByHash MessageType = 0x07
 )
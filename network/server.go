@@ -8,6 +8,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/matrix-go/block/api"
 	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/types"
 	"github.com/sirupsen/logrus"
 	"os"
 	"sync"
@@ -26,15 +27,22 @@ type ServerOpt struct {
 	PrivateKey    *crypto.PrivateKey
 	SeedPeers     []Peer // peers wait for connection to sync block status
 	ApiAddr       string
+	DataDir       string // blocks are kept in memory if empty
+	// Genesis defaults to an empty genesis of chain 0,
+	// peers on another chain are refused
+	Genesis *core.Genesis
 }
 type Server struct {
 	ServerOpt
 	Transport Transport
 	peerMap   map[NetAddr]Peer
-	lock      sync.RWMutex
+	// refused are the peers found to be on another chain
+	refused map[NetAddr]struct{}
+	lock    sync.RWMutex
 	//Transports  []Transport // transport wait for connection
 	isValidator bool
 	memPool     *TxPool
+	orphans     *OrphanPool
 	chain       *core.Blockchain
 	blockTime   time.Duration
 	quit        chan struct{}
@@ -47,6 +55,12 @@ func (s *Server) Quit() {
 }
 
 func NewServer(opt ServerOpt) (*Server, error) {
+	if opt.Genesis == nil {
+		opt.Genesis = &core.Genesis{}
+	}
+	if opt.BlockTime == 0 {
+		opt.BlockTime = opt.Genesis.BlockTime.Duration
+	}
 	if opt.BlockTime == 0 {
 		opt.BlockTime = time.Second // default block time
 	}
@@ -58,12 +72,32 @@ func NewServer(opt ServerOpt) (*Server, error) {
 		opt.Logger = log.With(opt.Logger, "ID", opt.ID, "addr", opt.Transport.Addr())
 	}
 
-	var genesis *core.Block
-	if opt.PrivateKey != nil {
-		genesis = genesisBlock(opt.PrivateKey)
+	genesis, err := opt.Genesis.Block()
+	if err != nil {
+		return nil, err
+	}
+	validators, err := opt.Genesis.ValidatorKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var storage core.Storage
+	if opt.DataDir != "" {
+		fileStorage, err := core.NewFileStorage(opt.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		storage = fileStorage
 	}
 
-	chain, err := core.NewBlockchain(genesis, opt.Logger)
+	chain, err := core.NewBlockchain(core.BlockchainOpt{
+		Logger:      opt.Logger,
+		Storage:     storage,
+		Genesis:     genesis,
+		Validators:  validators,
+		Issuance:    opt.Genesis.Issuance,
+		MinGasPrice: opt.Genesis.MinimumGasPrice(),
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -72,10 +106,12 @@ func NewServer(opt ServerOpt) (*Server, error) {
 		ServerOpt: opt,
 		peerMap:   make(map[NetAddr]Peer), // already connected peers
 		lock:      sync.RWMutex{},         // peerMap lock
+		refused:   make(map[NetAddr]struct{}),
 		Transport: opt.Transport,
 		//Transports:  opt.Transports,
-		isValidator: opt.PrivateKey != nil,
+		isValidator: opt.PrivateKey != nil && chain.IsValidator(opt.PrivateKey.PublicKey()),
 		memPool:     NewTxPool(10),
+		orphans:     NewOrphanPool(defaultMaxOrphans, defaultMaxOrphansPerPeer, defaultMaxOrphanBytes, defaultOrphanTTL),
 		chain:       chain,
 		blockTime:   opt.BlockTime,
 		quit:        make(chan struct{}, 1),
@@ -95,6 +131,8 @@ func NewServer(opt ServerOpt) (*Server, error) {
 	if opt.RPCProcessor == nil {
 		server.RPCProcessor = server
 	}
+	server.memPool.SetAccountReader(chain)
+	chain.SetReorgHandler(server.handleChainChange)
 	return server, nil
 }
 
@@ -126,7 +164,11 @@ quit:
 		case peer := <-s.Transport.ConsumePeer():
 			s.lock.RLock()
 			_, exists := s.peerMap[peer.Addr()]
+			_, refused := s.refused[peer.Addr()]
 			s.lock.RUnlock()
+			if refused {
+				continue
+			}
 			if exists {
 				s.Logger.Log("peer", peer.Addr(), "msg", "peer exists")
 				continue
@@ -199,12 +241,18 @@ func (s *Server) requestBlockLoop(peer Peer) error {
 }
 
 func (s *Server) ProcessMessage(msg *DecodeMessage) error {
+	s.lock.RLock()
+	_, refused := s.refused[msg.From]
+	s.lock.RUnlock()
+	if refused {
+		return nil
+	}
 
 	switch t := msg.Data.(type) {
 	case *core.Transaction:
 		return s.processTransaction(t)
 	case *core.Block:
-		return s.processBlock(t)
+		return s.processBlock(msg.From, t)
 	case *GetStatusMessage:
 		return s.processSendStatusMessage(msg.From, t)
 	case *StatusMessage:
@@ -212,7 +260,9 @@ func (s *Server) ProcessMessage(msg *DecodeMessage) error {
 	case *GetBlocksMessage:
 		return s.processSendGetBlocksMessage(msg.From, t)
 	case *BlockMessage:
-		return s.processSyncBlocks(t)
+		return s.processSyncBlocks(msg.From, t)
+	case *GetBlockByHashMessage:
+		return s.processSendBlockByHash(msg.From, t)
 	default:
 		return fmt.Errorf("unknown msg type: %T", t)
 	}
@@ -249,25 +299,16 @@ func (s *Server) broadcastTx(tx *core.Transaction) error {
 }
 
 func (s *Server) createNewBlock() error {
-
-	header, err := s.chain.GetHeader(s.chain.Height())
-	if err != nil {
-		return err
-	}
 	// get txs from mempool
 	txs := s.memPool.Pending()
-	block, err := core.NewBlockWithPrevHeader(header, txs)
+	block, err := s.chain.ProposeBlock(s.PrivateKey, txs)
 	if err != nil {
 		return err
 	}
-	if err = block.Sign(s.PrivateKey); err != nil {
-		return err
-	}
+	// the transactions left out of the block stay pending
 	if err = s.chain.AddBlock(block); err != nil {
 		return err
 	}
-	// clear cached pending transactions
-	s.memPool.ClearPending()
 
 	// broad cast block
 	return s.broadcastBlock(block)
@@ -281,6 +322,18 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 		return nil
 	}
 
+	if tx.ChainID != s.chain.ChainID() {
+		return fmt.Errorf("transaction %s chain id %d, expected %d: %w", txHash, tx.ChainID, s.chain.ChainID(), core.ErrChainIDMismatch)
+	}
+	// no block could ever include it
+	if gasLimit := s.chain.GasLimit(); tx.GasLimit > gasLimit {
+		return fmt.Errorf("transaction %s gas limit %d, block gas limit %d: %w", txHash, tx.GasLimit, gasLimit, core.ErrBlockGasLimitReached)
+	}
+
+	if minGasPrice := s.chain.MinGasPrice(); tx.From != nil && tx.GasPrice < minGasPrice {
+		return fmt.Errorf("transaction %s gas price %d, minimum %d: %w", txHash, tx.GasPrice, minGasPrice, core.ErrGasPriceTooLow)
+	}
+
 	tx.SetFirstSeen(time.Now().UnixNano())
 
 	if err := tx.Verify(); err != nil {
@@ -300,15 +353,147 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 	return s.memPool.Add(tx)
 }
 
-func (s *Server) processBlock(data *core.Block) error {
-	if err := s.chain.AddBlock(data); err != nil {
+func (s *Server) processBlock(from NetAddr, data *core.Block) error {
+	if err := s.addBlock(from, data); err != nil {
+		return err
+	}
+	// orphans are only relayed once connected
+	if s.chain.HasBlockHash(data.GetHash(core.NewHeaderHasher())) {
+		go s.broadcastBlock(data)
+	}
+	return nil
+}
+
+// addBlock adds the block to the chain together with the orphans waiting
+// for it. A block whose parent is unknown is kept as an orphan and its
+// missing ancestor is asked from the peer that sent it.
+func (s *Server) addBlock(from NetAddr, block *core.Block) error {
+	err := s.chain.AddBlock(block)
+	if errors.Is(err, core.ErrBlockUnknownParent) {
+		return s.processOrphanBlock(from, block)
+	}
+	if err != nil {
 		return err
 	}
-	go s.broadcastBlock(data)
+	s.connectOrphans(block)
 	return nil
 }
 
+// processOrphanBlock keeps block as an orphan once it is known to be signed
+// by a validator of the chain, the rest of it is validated once its parent
+// arrives
+func (s *Server) processOrphanBlock(from NetAddr, block *core.Block) error {
+	hash := block.GetHash(core.NewHeaderHasher())
+	if block.ChainID != s.chain.ChainID() {
+		return fmt.Errorf("orphan block %s chain id %d, expected %d: %w", hash, block.ChainID, s.chain.ChainID(), core.ErrChainIDMismatch)
+	}
+	if !s.chain.IsValidator(block.Validator) {
+		return fmt.Errorf("orphan block %s validator %s, %w", hash, block.Validator, core.ErrBlockValidatorUnknown)
+	}
+	if err := block.Verify(); err != nil {
+		return err
+	}
+	if !s.orphans.Add(from, block) {
+		return nil
+	}
+	s.Logger.Log("msg", "keep orphan block", "height", block.Height, "hash", hash, "orphans", s.orphans.Count())
+	missing, ok := s.orphans.MissingAncestor(hash)
+	if !ok || s.chain.HasBlockHash(missing) {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(NewGetBlockByHashMessage(missing)); err != nil {
+		return err
+	}
+	msg := NewMessage(MessageTypeGetBlockByHash, buf.Bytes())
+	s.lock.RLock()
+	peer, ok := s.peerMap[from]
+	s.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("peer not found to %s", from)
+	}
+	return s.Transport.SendMessage(peer, msg.Bytes())
+}
+
+// connectOrphans adds the orphans descending from parent to the chain
+func (s *Server) connectOrphans(parent *core.Block) {
+	queue := []*core.Block{parent}
+	for len(queue) > 0 {
+		hash := queue[0].GetHash(core.NewHeaderHasher())
+		queue = queue[1:]
+		for _, orphan := range s.orphans.Take(hash) {
+			if err := s.chain.AddBlock(orphan); err != nil {
+				s.Logger.Log("err", err, "msg", "connect orphan block failed", "height", orphan.Height)
+				continue
+			}
+			go s.broadcastBlock(orphan)
+			queue = append(queue, orphan)
+		}
+	}
+}
+
+func (s *Server) processSendBlockByHash(to NetAddr, data *GetBlockByHashMessage) error {
+	blocks, err := s.chain.GetBlockByHash(data.Hash)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = blocks[0].Encode(core.NewGobBlockEncoder(&buf)); err != nil {
+		return err
+	}
+	msg := NewMessage(MessageTypeBlock, buf.Bytes())
+	s.lock.RLock()
+	peer, ok := s.peerMap[to]
+	s.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("peer not found to %s", to)
+	}
+	return s.Transport.SendMessage(peer, msg.Bytes())
+}
+
+// handleChainChange drops the transactions included by the new canonical
+// blocks from the pending ones, together with those that can no longer be
+// included. The transactions of orphaned blocks become pending again,
+// unless the new canonical blocks include them as well.
+func (s *Server) handleChainChange(disconnected, connected []*core.Block) {
+	included := make(map[types.Hash]struct{})
+	for _, block := range connected {
+		for _, tx := range block.Transactions {
+			hash := tx.GetHash(core.NewTransactionHasher())
+			included[hash] = struct{}{}
+			s.memPool.RemovePending(hash)
+		}
+	}
+	for _, block := range disconnected {
+		for _, tx := range block.Transactions {
+			if tx == block.Coinbase() {
+				continue
+			}
+			if _, ok := included[tx.GetHash(core.NewTransactionHasher())]; !ok {
+				s.memPool.Reinject(tx)
+			}
+		}
+	}
+	s.memPool.Prune()
+	if len(disconnected) == 0 {
+		return
+	}
+	s.Logger.Log("msg", "chain reorganised", "disconnected", len(disconnected), "connected", len(connected), "height", s.chain.Height())
+}
+
+// refusePeer drops the peer and ignores it from now on
+func (s *Server) refusePeer(addr NetAddr, chainID uint64) error {
+	s.lock.Lock()
+	delete(s.peerMap, addr)
+	s.refused[addr] = struct{}{}
+	s.lock.Unlock()
+	return fmt.Errorf("peer %s chain id %d, expected %d: %w", addr, chainID, s.chain.ChainID(), core.ErrChainIDMismatch)
+}
+
 func (s *Server) processStatusMessage(to NetAddr, data *StatusMessage) error {
+	if data.ChainID != s.chain.ChainID() {
+		return s.refusePeer(to, data.ChainID)
+	}
 	if data.Height <= s.chain.Height() {
 		s.Logger.Log("msg", "remote height is less than or equal with local chain height", "height", s.chain.Height())
 		return nil
@@ -326,7 +511,7 @@ func (s *Server) processStatusMessage(to NetAddr, data *StatusMessage) error {
 
 func (s *Server) processSendGetStatusMessage(peer Peer) error {
 	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(GetStatusMessage{}); err != nil {
+	if err := gob.NewEncoder(&buf).Encode(GetStatusMessage{ChainID: s.chain.ChainID()}); err != nil {
 		s.Logger.Log("err", err, "msg", "send GetStatusMessage failed with encode message")
 		return err
 	}
@@ -338,8 +523,12 @@ func (s *Server) processSendGetStatusMessage(peer Peer) error {
 }
 
 func (s *Server) processSendStatusMessage(to NetAddr, data *GetStatusMessage) error {
+	if data.ChainID != s.chain.ChainID() {
+		return s.refusePeer(to, data.ChainID)
+	}
 	stsMessage := NewStatusMessage()
 	stsMessage.ID = s.ID
+	stsMessage.ChainID = s.chain.ChainID()
 	stsMessage.Height = s.chain.Height()
 	stsMessage.Version = s.chain.Version()
 	var buf bytes.Buffer
@@ -371,9 +560,8 @@ func (s *Server) processSendGetBlocksMessage(to NetAddr, data *GetBlocksMessage)
 			return err
 		}
 		blocks = append(blocks, block)
-		fmt.Printf("height: %d, block: %v\n", i, block)
 	}
-	fmt.Printf("===> process send blocks message to %s, msg %+v\n", to, data)
+	s.Logger.Log("msg", "send blocks", "to", to, "from", heightStart, "until", heightEnd)
 	blkMsg := NewBlockMessage(blocks)
 	var buf bytes.Buffer
 	if err := gob.NewEncoder(&buf).Encode(blkMsg); err != nil {
@@ -390,10 +578,10 @@ func (s *Server) processSendGetBlocksMessage(to NetAddr, data *GetBlocksMessage)
 	return s.Transport.SendMessage(peer, msg.Bytes())
 }
 
-func (s *Server) processSyncBlocks(t *BlockMessage) error {
-	fmt.Printf("process sync blocks: %+v\n", t.Data)
+func (s *Server) processSyncBlocks(from NetAddr, t *BlockMessage) error {
+	s.Logger.Log("msg", "sync blocks", "from", from, "count", len(t.Data))
 	for _, block := range t.Data {
-		if err := s.chain.AddBlock(block); err != nil {
+		if err := s.addBlock(from, block); err != nil {
 			return err
 		}
 	}
@@ -403,26 +591,7 @@ func (s *Server) processSyncBlocks(t *BlockMessage) error {
 func (s *Server) Stop() {
 	//s.apiServer.Stop()
 	//s.Transport.Stop()
-}
-
-func genesisBlock(validator *crypto.PrivateKey) *core.Block {
-	header := &core.Header{
-		Version:   1,
-		Height:    0,
-		Timestamp: 0,
-	}
-
-	coinbase := &crypto.PublicKey{}
-	tx := core.NewTransaction(nil)
-	tx.From = coinbase
-	tx.To = coinbase
-	tx.Value = 10_000_000 // TODO: coinbase reward
-
-	block := core.NewBlock(header)
-	block.AddTransaction(tx)
-
-	if err := block.Sign(validator); err != nil {
-		panic(err)
+	if err := s.chain.Close(); err != nil {
+		s.Logger.Log("err", err, "msg", "close blockchain failed")
 	}
-	return block
 }
@@ -0,0 +1,187 @@
+package network
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/crypto"
+	"github.com/matrix-go/block/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_RefusePeerOnAnotherChain(t *testing.T) {
+	tr := NewLocalTransport("LOCAL")
+	remote := NewLocalTransport("REMOTE")
+	s, err := NewServer(ServerOpt{
+		ID:        "LOCAL",
+		Logger:    log.NewNopLogger(),
+		Transport: tr,
+		Genesis:   &core.Genesis{ChainID: 1},
+	})
+	require.NoError(t, err)
+	s.peerMap[remote.Addr()] = NewLocalPeer(remote.Addr(), remote.RpcChan)
+
+	err = s.ProcessMessage(&DecodeMessage{From: remote.Addr(), Data: &StatusMessage{ChainID: 2, Height: 10}})
+	assert.ErrorIs(t, err, core.ErrChainIDMismatch)
+	assert.NotContains(t, s.peerMap, remote.Addr())
+
+	// later messages of the peer are ignored
+	err = s.ProcessMessage(&DecodeMessage{From: remote.Addr(), Data: &GetStatusMessage{ChainID: 2}})
+	assert.NoError(t, err)
+}
+
+func TestServer_CreateNewBlockKeepsLeftOutTransactions(t *testing.T) {
+	privateKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	senders := make([]*crypto.PrivateKey, 2)
+	alloc := make(map[string]uint64)
+	for i := range senders {
+		senders[i], err = crypto.GeneratePrivateKey()
+		require.NoError(t, err)
+		alloc[hex.EncodeToString(senders[i].PublicKey().Bytes())] = 600
+	}
+	s, err := NewServer(ServerOpt{
+		ID:         "LOCAL",
+		Logger:     log.NewNopLogger(),
+		Transport:  NewLocalTransport("LOCAL"),
+		PrivateKey: privateKey,
+		Genesis:    &core.Genesis{GasLimit: 1000, Alloc: alloc},
+	})
+	require.NoError(t, err)
+
+	// loops using all their gas, only one of them fits in a block
+	txs := make([]*core.Transaction, len(senders))
+	for i, sender := range senders {
+		txs[i] = core.NewTransaction([]byte{0x15, 0x00, 0x0a, 0x13})
+		txs[i].GasLimit = 600
+		txs[i].GasPrice = 1
+		txs[i].SetFirstSeen(int64(i + 1))
+		require.NoError(t, txs[i].Sign(sender))
+		require.NoError(t, s.memPool.Add(txs[i]))
+	}
+	require.NoError(t, s.createNewBlock())
+	block, err := s.chain.GetBlock(1)
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 1)
+	assert.Equal(t, 1, s.memPool.PendingCount())
+	assert.Equal(t, txs[1], s.memPool.Pending()[0])
+
+	require.NoError(t, s.createNewBlock())
+	assert.Zero(t, s.memPool.PendingCount())
+}
+
+func TestServer_RejectLowGasPrice(t *testing.T) {
+	s, err := NewServer(ServerOpt{
+		ID:        "LOCAL",
+		Logger:    log.NewNopLogger(),
+		Transport: NewLocalTransport("LOCAL"),
+		Genesis:   &core.Genesis{MinGasPrice: 2},
+	})
+	require.NoError(t, err)
+	sender, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	tx := core.NewTransaction(nil)
+	tx.GasPrice = 1
+	require.NoError(t, tx.Sign(sender))
+	assert.ErrorIs(t, s.processTransaction(tx), core.ErrGasPriceTooLow)
+	assert.Zero(t, s.memPool.PendingCount())
+}
+
+func TestServer_BlockFromPeerRemovesPending(t *testing.T) {
+	sender, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	genesis := &core.Genesis{Alloc: map[string]uint64{hex.EncodeToString(sender.PublicKey().Bytes()): 1000}}
+	newServer := func(id string) *Server {
+		s, err := NewServer(ServerOpt{
+			ID:        id,
+			Logger:    log.NewNopLogger(),
+			Transport: NewLocalTransport(NetAddr(id)),
+			Genesis:   genesis,
+		})
+		require.NoError(t, err)
+		return s
+	}
+	local, remote := newServer("LOCAL"), newServer("REMOTE")
+
+	newTx := func(nonce uint64) *core.Transaction {
+		tx := core.NewTransaction(nil)
+		tx.To = sender.PublicKey()
+		tx.Value = 1
+		tx.Nonce = nonce
+		tx.GasPrice = 1
+		require.NoError(t, tx.Sign(sender))
+		return tx
+	}
+	included, replayed := newTx(0), newTx(1)
+	require.NoError(t, local.memPool.Add(included))
+	require.NoError(t, local.memPool.Add(replayed))
+
+	// the peer includes both transactions, the nonce of another one is used
+	validator, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	block, err := remote.chain.ProposeBlock(validator, []*core.Transaction{included, replayed})
+	require.NoError(t, err)
+	require.Len(t, block.Transactions, 2)
+	require.NoError(t, remote.chain.AddBlock(block))
+	require.NoError(t, local.addBlock(remote.Transport.Addr(), block))
+	assert.Zero(t, local.memPool.PendingCount())
+
+	stale := newTx(1)
+	stale.Value = 2
+	require.NoError(t, stale.Sign(sender))
+	local.memPool.pending.Add(stale)
+	next, err := remote.chain.ProposeBlock(validator, nil)
+	require.NoError(t, err)
+	require.NoError(t, local.addBlock(remote.Transport.Addr(), next))
+	assert.Zero(t, local.memPool.PendingCount())
+}
+
+func TestServer_VerifyOrphanBlock(t *testing.T) {
+	validator, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	genesis := &core.Genesis{Validators: []string{hex.EncodeToString(validator.PublicKey().Bytes())}}
+	newServer := func(id string) *Server {
+		s, err := NewServer(ServerOpt{
+			ID:        id,
+			Logger:    log.NewNopLogger(),
+			Transport: NewLocalTransport(NetAddr(id)),
+			Genesis:   genesis,
+		})
+		require.NoError(t, err)
+		return s
+	}
+	local, remote := newServer("LOCAL"), newServer("REMOTE")
+	from := remote.Transport.Addr()
+	local.peerMap[from] = NewLocalPeer(from, remote.Transport.(*LocalTransport).RpcChan)
+
+	parent, err := remote.chain.ProposeBlock(validator, nil)
+	require.NoError(t, err)
+	require.NoError(t, remote.chain.AddBlock(parent))
+	orphan, err := remote.chain.ProposeBlock(validator, nil)
+	require.NoError(t, err)
+
+	forge := func(key *crypto.PrivateKey, dataHash types.Hash) *core.Block {
+		header := *orphan.Header
+		header.DataHash = dataHash
+		forged := core.NewBlock(&header)
+		require.NoError(t, forged.Sign(key))
+		return forged
+	}
+	stranger, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	err = local.addBlock(from, forge(stranger, orphan.DataHash))
+	assert.ErrorIs(t, err, core.ErrBlockValidatorUnknown)
+	err = local.addBlock(from, forge(validator, types.RandomHash()))
+	assert.ErrorIs(t, err, core.ErrBlockInvalidHash)
+	assert.Zero(t, local.orphans.Count())
+
+	require.NoError(t, local.addBlock(from, orphan))
+	assert.True(t, local.orphans.Contains(orphan.GetHash(core.NewHeaderHasher())))
+	require.NoError(t, local.addBlock(from, parent))
+	assert.Zero(t, local.orphans.Count())
+	assert.Equal(t, uint64(2), local.chain.Height())
+}
@@ -1,17 +1,29 @@
 package network
 
 import (
+	"errors"
+	"fmt"
 	"github.com/matrix-go/block/core"
 	"github.com/matrix-go/block/types"
+	"sort"
 	"sync"
 )
 
+// AccountReader gives the pool the nonces and balances of the chain state
+type AccountReader interface {
+	GetNonce(addr types.Address) (uint64, error)
+	GetBalance(addr types.Address) (uint64, error)
+}
+
 type TxPool struct {
 	all     *TxSortedMap
 	pending *TxSortedMap
+	// accounts is unset when the pool does not check nonces
+	accounts AccountReader
 
 	// the max length of the mempool of transactions
-	// when the pool is full we will prune the oldest transaction
+	// when the pool is full we will prune the oldest transaction, pending
+	// or not
 	maxLength int
 }
 
@@ -23,20 +35,100 @@ func NewTxPool(maxLength int) *TxPool {
 	}
 }
 
+func (p *TxPool) SetAccountReader(accounts AccountReader) {
+	p.accounts = accounts
+}
+
 func (p *TxPool) Add(tx *core.Transaction) error {
+	txHash := tx.GetHash(core.NewTransactionHasher())
+	if p.all.Contains(txHash) {
+		return nil
+	}
+	if err := p.validateAccount(tx); err != nil {
+		return err
+	}
 
 	if p.all.Count() == p.maxLength {
-		oldest := p.all.First()
-		p.all.Remove(oldest.GetHash(core.NewTransactionHasher()))
+		oldest := p.all.First().GetHash(core.NewTransactionHasher())
+		p.all.Remove(oldest)
+		p.pending.Remove(oldest)
 	}
-	txHash := tx.GetHash(core.NewTransactionHasher())
-	if !p.all.Contains(txHash) {
-		p.all.Add(tx)
-		p.pending.Add(tx)
+	p.all.Add(tx)
+	p.pending.Add(tx)
+	return nil
+}
+
+// validateAccount rejects a transaction whose nonce is already used, by the
+// chain or by a pending transaction, or leaves a gap after them, and one
+// whose sender cannot pay for it on top of its pending transactions
+func (p *TxPool) validateAccount(tx *core.Transaction) error {
+	if p.accounts == nil || tx.From == nil {
+		return nil
+	}
+	addr := tx.From.Address()
+	next, err := p.accounts.GetNonce(addr)
+	if err != nil {
+		return err
+	}
+	if tx.Nonce < next {
+		return fmt.Errorf("%s nonce %d, expected %d: %w", addr, tx.Nonce, next, core.ErrNonceTooLow)
+	}
+	cost, ok := txCost(tx, 0)
+	if !ok {
+		return fmt.Errorf("%s cost overflows: %w", addr, core.ErrInsufficientBalance)
+	}
+	p.pending.lock.RLock()
+	for _, pending := range p.pending.txs.Data {
+		if pending.From == nil || pending.From.Address() != addr || pending.Nonce < next {
+			continue
+		}
+		if pending.Nonce == tx.Nonce {
+			p.pending.lock.RUnlock()
+			return fmt.Errorf("%s nonce %d is pending: %w", addr, tx.Nonce, core.ErrNonceTooLow)
+		}
+		next = max(next, pending.Nonce+1)
+		if cost, ok = txCost(pending, cost); !ok {
+			p.pending.lock.RUnlock()
+			return fmt.Errorf("%s cost overflows: %w", addr, core.ErrInsufficientBalance)
+		}
+	}
+	p.pending.lock.RUnlock()
+	if tx.Nonce > next {
+		return fmt.Errorf("%s nonce %d, expected %d: %w", addr, tx.Nonce, next, core.ErrNonceTooHigh)
+	}
+
+	if cost == 0 {
+		return nil
+	}
+	balance, err := p.accounts.GetBalance(addr)
+	if errors.Is(err, core.ErrAccountNotFound) {
+		balance, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+	if balance < cost {
+		return fmt.Errorf("%s balance %d, cost %d: %w", addr, balance, cost, core.ErrInsufficientBalance)
 	}
 	return nil
 }
 
+// txCost adds the value, fee and gas cost of tx to cost, it reports false on
+// overflow
+func txCost(tx *core.Transaction, cost uint64) (uint64, bool) {
+	gasCost, ok := tx.GasCost()
+	if !ok {
+		return 0, false
+	}
+	for _, v := range []uint64{tx.Value, tx.Fee, gasCost} {
+		if cost+v < cost {
+			return 0, false
+		}
+		cost += v
+	}
+	return cost, true
+}
+
 func (p *TxPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
@@ -46,7 +138,68 @@ func (p *TxPool) Pending() []*core.Transaction {
 }
 
 func (p *TxPool) ClearPending() {
-	p.pending.txs.Clear()
+	p.pending.Clear()
+}
+
+// Reinject makes the transaction of a block orphaned by a reorg pending
+// again, even though the pool has already seen it
+func (p *TxPool) Reinject(tx *core.Transaction) {
+	if !p.all.Contains(tx.GetHash(core.NewTransactionHasher())) {
+		_ = p.Add(tx)
+		return
+	}
+	p.pending.Add(tx)
+}
+
+// RemovePending drops a transaction that a block has included
+func (p *TxPool) RemovePending(hash types.Hash) {
+	p.pending.Remove(hash)
+}
+
+// Prune drops the pending transactions that can no longer be included: a
+// nonce already used by the chain, or one following a transaction its
+// sender cannot pay for
+func (p *TxPool) Prune() {
+	if p.accounts == nil {
+		return
+	}
+	bySender := make(map[types.Address][]*core.Transaction)
+	p.pending.lock.RLock()
+	for _, tx := range p.pending.txs.Data {
+		if tx.From != nil {
+			bySender[tx.From.Address()] = append(bySender[tx.From.Address()], tx)
+		}
+	}
+	p.pending.lock.RUnlock()
+
+	for addr, txs := range bySender {
+		next, err := p.accounts.GetNonce(addr)
+		if err != nil {
+			continue
+		}
+		balance, err := p.accounts.GetBalance(addr)
+		if errors.Is(err, core.ErrAccountNotFound) {
+			balance, err = 0, nil
+		}
+		if err != nil {
+			continue
+		}
+		sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce < txs[j].Nonce })
+		var cost uint64
+		payable := true
+		for _, tx := range txs {
+			if tx.Nonce >= next && payable {
+				var ok bool
+				cost, ok = txCost(tx, cost)
+				payable = ok && cost <= balance && tx.Nonce == next
+				if payable {
+					next++
+					continue
+				}
+			}
+			p.pending.Remove(tx.GetHash(core.NewTransactionHasher()))
+		}
+	}
 }
 
 func (p *TxPool) PendingCount() int {
@@ -79,11 +232,18 @@ func (t *TxSortedMap) Remove(hash types.Hash) {
 	}
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	delete(t.lookup, hash)
 	tx := t.lookup[hash]
+	delete(t.lookup, hash)
 	t.txs.Remove(tx)
 }
 
+func (t *TxSortedMap) Clear() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lookup = make(map[types.Hash]*core.Transaction)
+	t.txs.Clear()
+}
+
 func (t *TxSortedMap) Add(tx *core.Transaction) {
 	hash := tx.GetHash(core.NewTransactionHasher())
 	if contains := t.Contains(hash); contains {
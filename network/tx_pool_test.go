@@ -2,8 +2,11 @@ package network
 
 import (
 	"github.com/matrix-go/block/core"
+	"github.com/matrix-go/block/crypto"
+	"github.com/matrix-go/block/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math"
 	"strconv"
 	"testing"
 )
@@ -24,9 +27,9 @@ func TestTxPool_AddTx(t *testing.T) {
 }
 
 func TestTxPool_SortTransactions(t *testing.T) {
-	pool := NewTxPool(10)
-
 	txLen := 1000
+	pool := NewTxPool(txLen)
+
 	for i := 0; i < txLen; i++ {
 		tx := core.NewTransaction([]byte(strconv.Itoa(i)))
 		tx.SetFirstSeen(int64(i + 1))
@@ -41,3 +44,147 @@ func TestTxPool_SortTransactions(t *testing.T) {
 		assert.True(t, txs[i].FirstSeen() <= txs[i+1].FirstSeen())
 	}
 }
+
+func TestTxPool_Reinject(t *testing.T) {
+	pool := NewTxPool(10)
+	tx := core.NewTransaction([]byte("foo"))
+	require.NoError(t, pool.Add(tx))
+	pool.ClearPending()
+
+	// already seen transactions are not pending again unless reinjected
+	require.NoError(t, pool.Add(tx))
+	assert.Equal(t, 0, pool.PendingCount())
+	pool.Reinject(tx)
+	assert.Equal(t, 1, pool.PendingCount())
+
+	pool.RemovePending(tx.GetHash(core.NewTransactionHasher()))
+	assert.Equal(t, 0, pool.PendingCount())
+	assert.True(t, pool.Contains(tx.GetHash(core.NewTransactionHasher())))
+}
+
+func TestTxPool_MaxLength(t *testing.T) {
+	pool := NewTxPool(3)
+	txs := make([]*core.Transaction, 5)
+	for i := range txs {
+		txs[i] = core.NewTransaction([]byte(strconv.Itoa(i)))
+		txs[i].SetFirstSeen(int64(i + 1))
+		require.NoError(t, pool.Add(txs[i]))
+	}
+	// the oldest transactions are pruned, pending ones included
+	assert.Equal(t, txs[2:], pool.Pending())
+}
+
+type accountReader struct {
+	nonces   map[types.Address]uint64
+	balances map[types.Address]uint64
+}
+
+func (r accountReader) GetNonce(addr types.Address) (uint64, error) {
+	return r.nonces[addr], nil
+}
+
+func (r accountReader) GetBalance(addr types.Address) (uint64, error) {
+	if balance, ok := r.balances[addr]; ok {
+		return balance, nil
+	}
+	return 0, core.ErrAccountNotFound
+}
+
+func TestTxPool_Nonce(t *testing.T) {
+	privKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	pool := NewTxPool(10)
+	pool.SetAccountReader(accountReader{nonces: map[types.Address]uint64{privKey.PublicKey().Address(): 1}})
+
+	newTx := func(nonce uint64) *core.Transaction {
+		tx := core.NewTransaction([]byte(strconv.Itoa(int(nonce))))
+		tx.Nonce = nonce
+		require.NoError(t, tx.Sign(privKey))
+		return tx
+	}
+
+	assert.ErrorIs(t, pool.Add(newTx(0)), core.ErrNonceTooLow)
+	assert.ErrorIs(t, pool.Add(newTx(2)), core.ErrNonceTooHigh)
+	require.NoError(t, pool.Add(newTx(1)))
+	require.NoError(t, pool.Add(newTx(2)))
+
+	// another transaction with a pending nonce
+	replay := newTx(2)
+	replay.Data = []byte("replay")
+	require.NoError(t, replay.Sign(privKey))
+	assert.ErrorIs(t, pool.Add(replay), core.ErrNonceTooLow)
+	assert.Equal(t, 2, pool.PendingCount())
+}
+
+func TestTxPool_Balance(t *testing.T) {
+	privKey, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	addr := privKey.PublicKey().Address()
+	pool := NewTxPool(10)
+	pool.SetAccountReader(accountReader{balances: map[types.Address]uint64{addr: 100}})
+
+	newTx := func(nonce, value, fee uint64) *core.Transaction {
+		tx := core.NewTransaction(nil)
+		tx.Value = value
+		tx.Fee = fee
+		tx.Nonce = nonce
+		require.NoError(t, tx.Sign(privKey))
+		return tx
+	}
+
+	assert.ErrorIs(t, pool.Add(newTx(0, 100, 1)), core.ErrInsufficientBalance)
+	require.NoError(t, pool.Add(newTx(0, 60, 10)))
+	// the pending transaction is paid for first
+	assert.ErrorIs(t, pool.Add(newTx(1, 30, 1)), core.ErrInsufficientBalance)
+	require.NoError(t, pool.Add(newTx(1, 29, 1)))
+	assert.ErrorIs(t, pool.Add(newTx(2, 0, math.MaxUint64)), core.ErrInsufficientBalance)
+
+	// the gas limit is paid for at the gas price
+	pool = NewTxPool(10)
+	pool.SetAccountReader(accountReader{balances: map[types.Address]uint64{addr: 100}})
+	tx := newTx(0, 50, 10)
+	tx.GasLimit = 21
+	tx.GasPrice = 2
+	require.NoError(t, tx.Sign(privKey))
+	assert.ErrorIs(t, pool.Add(tx), core.ErrInsufficientBalance)
+	tx.GasLimit = 20
+	require.NoError(t, tx.Sign(privKey))
+	require.NoError(t, pool.Add(tx))
+	tx = newTx(1, 0, 0)
+	tx.GasLimit = math.MaxUint64
+	tx.GasPrice = 2
+	require.NoError(t, tx.Sign(privKey))
+	assert.ErrorIs(t, pool.Add(tx), core.ErrInsufficientBalance)
+}
+
+func TestTxPool_Prune(t *testing.T) {
+	alice, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	bob, err := crypto.GeneratePrivateKey()
+	require.NoError(t, err)
+	accounts := accountReader{
+		nonces:   map[types.Address]uint64{},
+		balances: map[types.Address]uint64{alice.PublicKey().Address(): 100, bob.PublicKey().Address(): 100},
+	}
+	pool := NewTxPool(10)
+	pool.SetAccountReader(accounts)
+
+	newTx := func(key *crypto.PrivateKey, nonce, value uint64) *core.Transaction {
+		tx := core.NewTransaction(nil)
+		tx.Value = value
+		tx.Nonce = nonce
+		tx.SetFirstSeen(int64(nonce + 1))
+		require.NoError(t, tx.Sign(key))
+		require.NoError(t, pool.Add(tx))
+		return tx
+	}
+	aliceTxs := []*core.Transaction{newTx(alice, 0, 10), newTx(alice, 1, 10), newTx(alice, 2, 10)}
+	bobTxs := []*core.Transaction{newTx(bob, 0, 50), newTx(bob, 1, 50)}
+
+	// a block used the first nonce of alice elsewhere, bob spent some coins
+	accounts.nonces[alice.PublicKey().Address()] = 1
+	accounts.balances[bob.PublicKey().Address()] = 60
+	pool.Prune()
+
+	assert.ElementsMatch(t, append(aliceTxs[1:], bobTxs[0]), pool.Pending())
+}
@@ -0,0 +1,450 @@
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/matrix-go/block/types"
+)
+
+// Trie is a Merkle Patricia trie over nibble paths. Nodes are never changed
+// once built, every update creates new nodes along the updated path, so a
+// copy of a trie is a copy of its root.
+type Trie struct {
+	root node
+}
+
+func New() *Trie {
+	return &Trie{}
+}
+
+// Copy returns an independent trie sharing the current nodes
+func (t *Trie) Copy() *Trie {
+	return &Trie{root: t.root}
+}
+
+// Hash returns the root hash, the hash of an empty trie is zero
+func (t *Trie) Hash() types.Hash {
+	if t.root == nil {
+		return types.Hash{}
+	}
+	return t.root.hash()
+}
+
+func (t *Trie) Get(key []byte) ([]byte, bool) {
+	path := keyToNibbles(key)
+	n := t.root
+	for {
+		switch cur := n.(type) {
+		case nil:
+			return nil, false
+		case *leafNode:
+			if !bytes.Equal(cur.path, path) {
+				return nil, false
+			}
+			return copyBytes(cur.value), true
+		case *extensionNode:
+			if !bytes.HasPrefix(path, cur.path) {
+				return nil, false
+			}
+			path = path[len(cur.path):]
+			n = cur.child
+		case *branchNode:
+			if len(path) == 0 {
+				if !cur.hasValue {
+					return nil, false
+				}
+				return copyBytes(cur.value), true
+			}
+			n = cur.children[path[0]]
+			path = path[1:]
+		}
+	}
+}
+
+func (t *Trie) Put(key, value []byte) {
+	t.root = insert(t.root, keyToNibbles(key), copyBytes(value))
+}
+
+// Delete removes key and reports whether it was present
+func (t *Trie) Delete(key []byte) bool {
+	root, deleted := remove(t.root, keyToNibbles(key))
+	if deleted {
+		t.root = root
+	}
+	return deleted
+}
+
+// Iterate visits every key in ascending order until fn returns false
+func (t *Trie) Iterate(fn func(key, value []byte) bool) {
+	iterate(t.root, nil, fn)
+}
+
+// Diff calls fn in ascending key order with every key whose value differs
+// between a and b, the value of a missing key being nil. Subtrees with the
+// same hash in both tries are skipped, so the cost follows the number of
+// changed keys rather than the size of the tries.
+func Diff(a, b *Trie, fn func(key, before, after []byte)) {
+	diff(a.root, b.root, nil, fn)
+}
+
+type node interface {
+	hash() types.Hash
+}
+
+type leafNode struct {
+	path   []byte
+	value  []byte
+	digest types.Hash
+}
+
+func (n *leafNode) hash() types.Hash { return n.digest }
+
+type extensionNode struct {
+	path   []byte
+	child  node // always a branch
+	digest types.Hash
+}
+
+func (n *extensionNode) hash() types.Hash { return n.digest }
+
+type branchNode struct {
+	children [16]node
+	value    []byte
+	hasValue bool
+	digest   types.Hash
+}
+
+func (n *branchNode) hash() types.Hash { return n.digest }
+
+const (
+	leafPrefix      byte = 0x00
+	extensionPrefix byte = 0x01
+	branchPrefix    byte = 0x02
+)
+
+func newLeaf(path, value []byte) *leafNode {
+	var buf bytes.Buffer
+	buf.WriteByte(leafPrefix)
+	writeBytes(&buf, path)
+	writeBytes(&buf, value)
+	return &leafNode{
+		path:   path,
+		value:  value,
+		digest: sha256.Sum256(buf.Bytes()),
+	}
+}
+
+func newExtension(path []byte, child node) *extensionNode {
+	var buf bytes.Buffer
+	buf.WriteByte(extensionPrefix)
+	writeBytes(&buf, path)
+	digest := child.hash()
+	buf.Write(digest[:])
+	return &extensionNode{
+		path:   path,
+		child:  child,
+		digest: sha256.Sum256(buf.Bytes()),
+	}
+}
+
+func newBranch(children [16]node, value []byte, hasValue bool) *branchNode {
+	var buf bytes.Buffer
+	buf.WriteByte(branchPrefix)
+	for _, child := range children {
+		if child == nil {
+			buf.WriteByte(0)
+			continue
+		}
+		buf.WriteByte(1)
+		digest := child.hash()
+		buf.Write(digest[:])
+	}
+	if hasValue {
+		buf.WriteByte(1)
+		writeBytes(&buf, value)
+	} else {
+		buf.WriteByte(0)
+	}
+	return &branchNode{
+		children: children,
+		value:    value,
+		hasValue: hasValue,
+		digest:   sha256.Sum256(buf.Bytes()),
+	}
+}
+
+func insert(n node, path, value []byte) node {
+	switch cur := n.(type) {
+	case nil:
+		return newLeaf(path, value)
+	case *leafNode:
+		common := prefixLen(cur.path, path)
+		if common == len(cur.path) && common == len(path) {
+			return newLeaf(path, value)
+		}
+		var (
+			children [16]node
+			bvalue   []byte
+			hasValue bool
+		)
+		if common == len(cur.path) {
+			bvalue, hasValue = cur.value, true
+		} else {
+			children[cur.path[common]] = newLeaf(cur.path[common+1:], cur.value)
+		}
+		if common == len(path) {
+			bvalue, hasValue = value, true
+		} else {
+			children[path[common]] = newLeaf(path[common+1:], value)
+		}
+		return wrap(path[:common], newBranch(children, bvalue, hasValue))
+	case *extensionNode:
+		common := prefixLen(cur.path, path)
+		if common == len(cur.path) {
+			return newExtension(cur.path, insert(cur.child, path[common:], value))
+		}
+		var (
+			children [16]node
+			bvalue   []byte
+			hasValue bool
+		)
+		if rest := cur.path[common+1:]; len(rest) == 0 {
+			children[cur.path[common]] = cur.child
+		} else {
+			children[cur.path[common]] = newExtension(rest, cur.child)
+		}
+		if common == len(path) {
+			bvalue, hasValue = value, true
+		} else {
+			children[path[common]] = newLeaf(path[common+1:], value)
+		}
+		return wrap(path[:common], newBranch(children, bvalue, hasValue))
+	case *branchNode:
+		if len(path) == 0 {
+			return newBranch(cur.children, value, true)
+		}
+		children := cur.children
+		children[path[0]] = insert(children[path[0]], path[1:], value)
+		return newBranch(children, cur.value, cur.hasValue)
+	}
+	panic("trie: unknown node type")
+}
+
+// wrap puts branch under an extension node when prefix is not empty
+func wrap(prefix []byte, branch *branchNode) node {
+	if len(prefix) == 0 {
+		return branch
+	}
+	return newExtension(copyBytes(prefix), branch)
+}
+
+func remove(n node, path []byte) (node, bool) {
+	switch cur := n.(type) {
+	case nil:
+		return nil, false
+	case *leafNode:
+		if !bytes.Equal(cur.path, path) {
+			return n, false
+		}
+		return nil, true
+	case *extensionNode:
+		if !bytes.HasPrefix(path, cur.path) {
+			return n, false
+		}
+		child, deleted := remove(cur.child, path[len(cur.path):])
+		if !deleted {
+			return n, false
+		}
+		return join(cur.path, child), true
+	case *branchNode:
+		children := cur.children
+		value, hasValue := cur.value, cur.hasValue
+		if len(path) == 0 {
+			if !hasValue {
+				return n, false
+			}
+			value, hasValue = nil, false
+		} else {
+			child, deleted := remove(children[path[0]], path[1:])
+			if !deleted {
+				return n, false
+			}
+			children[path[0]] = child
+		}
+		return collapse(children, value, hasValue), true
+	}
+	panic("trie: unknown node type")
+}
+
+// join prepends prefix to the path of n, merging nodes where possible
+func join(prefix []byte, n node) node {
+	switch cur := n.(type) {
+	case nil:
+		return nil
+	case *leafNode:
+		return newLeaf(concat(prefix, cur.path), cur.value)
+	case *extensionNode:
+		return newExtension(concat(prefix, cur.path), cur.child)
+	case *branchNode:
+		if len(prefix) == 0 {
+			return cur
+		}
+		return newExtension(prefix, cur)
+	}
+	panic("trie: unknown node type")
+}
+
+// collapse builds a branch, or a smaller node when the branch would have
+// a single child and no value, or a value and no child.
+func collapse(children [16]node, value []byte, hasValue bool) node {
+	count, last := 0, 0
+	for i, child := range children {
+		if child != nil {
+			count++
+			last = i
+		}
+	}
+	switch {
+	case count == 0 && hasValue:
+		return newLeaf(nil, value)
+	case count == 0:
+		return nil
+	case count == 1 && !hasValue:
+		return join([]byte{byte(last)}, children[last])
+	}
+	return newBranch(children, value, hasValue)
+}
+
+func iterate(n node, prefix []byte, fn func(key, value []byte) bool) bool {
+	switch cur := n.(type) {
+	case nil:
+		return true
+	case *leafNode:
+		return fn(nibblesToKey(concat(prefix, cur.path)), copyBytes(cur.value))
+	case *extensionNode:
+		return iterate(cur.child, concat(prefix, cur.path), fn)
+	case *branchNode:
+		if cur.hasValue && !fn(nibblesToKey(prefix), copyBytes(cur.value)) {
+			return false
+		}
+		for i, child := range cur.children {
+			if !iterate(child, concat(prefix, []byte{byte(i)}), fn) {
+				return false
+			}
+		}
+		return true
+	}
+	panic("trie: unknown node type")
+}
+
+func diff(a, b node, prefix []byte, fn func(key, before, after []byte)) {
+	switch {
+	case a == nil && b == nil:
+		return
+	case a == nil:
+		iterate(b, prefix, func(key, value []byte) bool {
+			fn(key, nil, value)
+			return true
+		})
+		return
+	case b == nil:
+		iterate(a, prefix, func(key, value []byte) bool {
+			fn(key, value, nil)
+			return true
+		})
+		return
+	case a.hash() == b.hash():
+		return
+	}
+	if la, ok := a.(*leafNode); ok {
+		if lb, ok := b.(*leafNode); ok && bytes.Equal(la.path, lb.path) {
+			fn(nibblesToKey(concat(prefix, la.path)), copyBytes(la.value), copyBytes(lb.value))
+			return
+		}
+	}
+	if ea, ok := a.(*extensionNode); ok {
+		if eb, ok := b.(*extensionNode); ok && bytes.Equal(ea.path, eb.path) {
+			diff(ea.child, eb.child, concat(prefix, ea.path), fn)
+			return
+		}
+	}
+
+	// the nodes differ in shape, both are compared as branches
+	ac, av, ahas := expand(a)
+	bc, bv, bhas := expand(b)
+	if ahas != bhas || !bytes.Equal(av, bv) {
+		fn(nibblesToKey(prefix), copyBytes(av), copyBytes(bv))
+	}
+	for i := range ac {
+		diff(ac[i], bc[i], concat(prefix, []byte{byte(i)}), fn)
+	}
+}
+
+// expand returns the children and the value of n seen as a branch
+func expand(n node) (children [16]node, value []byte, hasValue bool) {
+	switch cur := n.(type) {
+	case *branchNode:
+		return cur.children, cur.value, cur.hasValue
+	case *leafNode:
+		if len(cur.path) == 0 {
+			return children, cur.value, true
+		}
+		children[cur.path[0]] = newLeaf(cur.path[1:], cur.value)
+	case *extensionNode:
+		if len(cur.path) == 1 {
+			children[cur.path[0]] = cur.child
+		} else {
+			children[cur.path[0]] = newExtension(cur.path[1:], cur.child)
+		}
+	}
+	return children, nil, false
+}
+
+func keyToNibbles(key []byte) []byte {
+	nibbles := make([]byte, len(key)*2)
+	for i, b := range key {
+		nibbles[i*2] = b >> 4
+		nibbles[i*2+1] = b & 0x0f
+	}
+	return nibbles
+}
+
+func nibblesToKey(nibbles []byte) []byte {
+	key := make([]byte, len(nibbles)/2)
+	for i := range key {
+		key[i] = nibbles[i*2]<<4 | nibbles[i*2+1]
+	}
+	return key
+}
+
+func prefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func concat(a, b []byte) []byte {
+	res := make([]byte, 0, len(a)+len(b))
+	res = append(res, a...)
+	return append(res, b...)
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	res := make([]byte, len(b))
+	copy(res, b)
+	return res
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(b)))
+	buf.Write(size[:n])
+	buf.Write(b)
+}
@@ -0,0 +1,178 @@
+package trie
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrie_PutGetDelete(t *testing.T) {
+	tr := New()
+	assert.True(t, tr.Hash().IsZero())
+
+	tr.Put([]byte("dog"), []byte("puppy"))
+	tr.Put([]byte("do"), []byte("verb"))
+	tr.Put([]byte("doge"), []byte("coin"))
+	tr.Put([]byte("horse"), []byte("stallion"))
+
+	v, ok := tr.Get([]byte("do"))
+	require.True(t, ok)
+	assert.Equal(t, "verb", string(v))
+	v, ok = tr.Get([]byte("doge"))
+	require.True(t, ok)
+	assert.Equal(t, "coin", string(v))
+	_, ok = tr.Get([]byte("d"))
+	assert.False(t, ok)
+
+	root := tr.Hash()
+	tr.Put([]byte("dog"), []byte("hound"))
+	assert.NotEqual(t, root, tr.Hash())
+	tr.Put([]byte("dog"), []byte("puppy"))
+	assert.Equal(t, root, tr.Hash())
+
+	assert.True(t, tr.Delete([]byte("doge")))
+	assert.False(t, tr.Delete([]byte("doge")))
+	_, ok = tr.Get([]byte("doge"))
+	assert.False(t, ok)
+	v, ok = tr.Get([]byte("dog"))
+	require.True(t, ok)
+	assert.Equal(t, "puppy", string(v))
+
+	for _, k := range []string{"dog", "do", "horse"} {
+		assert.True(t, tr.Delete([]byte(k)))
+	}
+	assert.True(t, tr.Hash().IsZero())
+}
+
+func TestTrie_CopyIsIndependent(t *testing.T) {
+	tr := New()
+	tr.Put([]byte("foo"), []byte("bar"))
+	cp := tr.Copy()
+	cp.Put([]byte("foo"), []byte("baz"))
+	cp.Put([]byte("qux"), []byte("quux"))
+
+	v, ok := tr.Get([]byte("foo"))
+	require.True(t, ok)
+	assert.Equal(t, "bar", string(v))
+	_, ok = tr.Get([]byte("qux"))
+	assert.False(t, ok)
+	assert.NotEqual(t, tr.Hash(), cp.Hash())
+}
+
+func TestTrie_RootIndependentOfOrder(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	expected := make(map[string][]byte)
+	keys := make([]string, 0)
+	for i := 0; i < 500; i++ {
+		key := make([]byte, 1+rnd.Intn(6))
+		rnd.Read(key)
+		value := make([]byte, rnd.Intn(8))
+		rnd.Read(value)
+		if _, exists := expected[string(key)]; !exists {
+			keys = append(keys, string(key))
+		}
+		expected[string(key)] = value
+	}
+
+	a := New()
+	for _, k := range keys {
+		a.Put([]byte(k), expected[k])
+	}
+	b := New()
+	for i := len(keys) - 1; i >= 0; i-- {
+		b.Put([]byte(keys[i]), expected[keys[i]])
+	}
+	assert.Equal(t, a.Hash(), b.Hash())
+
+	// delete half of the keys and compare with a trie built from the rest
+	c := New()
+	for i, k := range keys {
+		if i%2 == 0 {
+			require.True(t, a.Delete([]byte(k)))
+			delete(expected, k)
+		} else {
+			c.Put([]byte(k), expected[k])
+		}
+	}
+	assert.Equal(t, c.Hash(), a.Hash())
+	for k, v := range expected {
+		got, ok := a.Get([]byte(k))
+		require.True(t, ok)
+		assert.Equal(t, v, got)
+	}
+
+	sorted := make([]string, 0, len(expected))
+	for k := range expected {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	visited := make([]string, 0, len(expected))
+	a.Iterate(func(key, value []byte) bool {
+		assert.True(t, bytes.Equal(expected[string(key)], value))
+		visited = append(visited, string(key))
+		return true
+	})
+	assert.Equal(t, sorted, visited)
+}
+
+func TestDiff(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	before := New()
+	values := make(map[string][]byte)
+	for i := 0; i < 300; i++ {
+		k := make([]byte, 1+rnd.Intn(4))
+		rnd.Read(k)
+		v := []byte{byte(i)}
+		before.Put(k, v)
+		values[string(k)] = v
+	}
+	assertDiff := func(after *Trie, expected map[string][2][]byte) {
+		t.Helper()
+		got := make(map[string][2][]byte)
+		var keys [][]byte
+		Diff(before, after, func(key, prev, next []byte) {
+			got[string(key)] = [2][]byte{prev, next}
+			keys = append(keys, key)
+		})
+		assert.Equal(t, expected, got)
+		assert.True(t, sort.SliceIsSorted(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		}))
+	}
+	assertDiff(before.Copy(), map[string][2][]byte{})
+
+	after := before.Copy()
+	expected := make(map[string][2][]byte)
+	for k, v := range values {
+		switch rnd.Intn(10) {
+		case 0:
+			after.Delete([]byte(k))
+			expected[k] = [2][]byte{v, nil}
+		case 1:
+			after.Put([]byte(k), []byte("changed"))
+			expected[k] = [2][]byte{v, []byte("changed")}
+		}
+	}
+	for i := 0; i < 30; i++ {
+		k := make([]byte, 1+rnd.Intn(5))
+		rnd.Read(k)
+		if _, ok := values[string(k)]; ok {
+			continue
+		}
+		after.Put(k, []byte("new"))
+		expected[string(k)] = [2][]byte{nil, []byte("new")}
+	}
+	assertDiff(after, expected)
+
+	// a value put back is no change
+	for k, v := range values {
+		after.Put([]byte(k), v)
+		delete(expected, k)
+		break
+	}
+	assertDiff(after, expected)
+}
@@ -2,7 +2,9 @@ package types
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 type Address [20]uint8
@@ -32,3 +34,23 @@ func AddressFromBytes(b []byte) Address {
 
 	return value
 }
+
+func (a *Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal("0x" + a.String())
+}
+
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return err
+	}
+	if len(b) != 20 {
+		return fmt.Errorf("address length should be 20 bytes, got %d", len(b))
+	}
+	copy(a[:], b)
+	return nil
+}